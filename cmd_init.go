@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/majorshift/safari-chain/config"
+	"github.com/majorshift/safari-chain/crypto"
+)
+
+// runInit creates a data directory with a node key, a validator key, a
+// genesis block signed by the validator and a config producing blocks.
+// With -genesis the directory joins an existing chain instead
+func runInit(args []string) error {
+	var dirs dataDirFlags
+	var alloc listFlag
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	dirs.register(fs)
+	chainID := fs.Uint("chain-id", 1, "id of the new chain")
+	listen := fs.String("listen", "", "address to accept peers on (default :3000)")
+	fs.Var(&alloc, "alloc", "genesis balance as <hex address>=<amount>; repeatable or comma separated")
+	join := fs.String("genesis", "", "genesis file of an existing chain to join instead of creating one; the node does not produce blocks")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *chainID > math.MaxUint32 {
+		return fmt.Errorf("chain id %d does not fit in 32 bits", *chainID)
+	}
+
+	dataDir, path := dirs.resolve()
+	genesisPath := dataDir + string(os.PathSeparator) + config.GenesisFile
+	if _, err := os.Stat(genesisPath); err == nil {
+		return fmt.Errorf("%s is already initialised", dataDir)
+	}
+
+	balances, err := parseAlloc(alloc)
+	if err != nil {
+		return err
+	}
+
+	var genesis *config.Genesis
+	if *join != "" && len(balances) > 0 {
+		return errors.New("-alloc cannot be combined with -genesis, the balances of the joined chain are in its genesis file")
+	}
+	if *join != "" {
+		if genesis, err = config.LoadGenesis(*join); err != nil {
+			return err
+		}
+	}
+
+	c := config.Default(dataDir)
+	if genesis == nil {
+		c.Validator = "validator"
+	}
+	if *listen != "" {
+		c.ListenAddr = *listen
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return err
+	}
+	keys := c.Keystore()
+	node, err := keys.LoadOrGenerate(c.NodeKey)
+	if err != nil {
+		return err
+	}
+	if genesis == nil {
+		validator, err := keys.LoadOrGenerate(c.Validator)
+		if err != nil {
+			return err
+		}
+		if genesis, err = config.NewGenesis(validator, uint32(*chainID), balances); err != nil {
+			return err
+		}
+	}
+	if err := genesis.Save(genesisPath); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if err := c.Save(path); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("initialised %s\n", dataDir)
+	fmt.Printf("  chain id   %d\n", genesis.ChainID)
+	fmt.Printf("  genesis    %s\n", genesis.Block.Hash(crypto.BlockHash{}).ToString())
+	fmt.Printf("  node id    %s\n", nodeID(node))
+	if c.Validator != "" {
+		addr := genesis.Block.Validator.Address()
+		fmt.Printf("  validator  %s\n", addr.String())
+	}
+	fmt.Printf("  config     %s\n", path)
+
+	return nil
+}
+
+// parseAlloc parses genesis balances given as <hex address>=<amount>,
+// keyed by the lower case address
+func parseAlloc(items []string) (map[string]uint64, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	alloc := make(map[string]uint64)
+	for _, item := range items {
+		addr, amount, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("alloc %q is not <hex address>=<amount>", item)
+		}
+		address, err := crypto.ParseAddress(addr)
+		if err != nil {
+			return nil, fmt.Errorf("alloc %q: %w", item, err)
+		}
+		balance, err := strconv.ParseUint(amount, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("alloc %q: %v", item, err)
+		}
+		alloc[address.String()] = balance
+	}
+
+	return alloc, nil
+}
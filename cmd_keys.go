@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/majorshift/safari-chain/config"
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/majorshift/safari-chain/network"
+)
+
+const keysUsage = `usage: safarichain keys <generate|import|export|list> [flags] [name]
+
+  generate <name>        create a new key
+  import <name> [hex]    store a hex encoded private key, read from stdin when omitted
+  export <name>          print the hex encoded private key
+  list                   show the stored keys with their address and node id
+`
+
+// runKeys manages the keystore of the data directory. Keys are files of
+// the keys directory, they do not need a config
+func runKeys(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, keysUsage)
+		return fmt.Errorf("missing keys command")
+	}
+
+	var dirs dataDirFlags
+	fs := flag.NewFlagSet("keys "+args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), keysUsage)
+		fs.PrintDefaults()
+	}
+	dirs.register(fs)
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	arg := func(i int) string {
+		if i < len(positional) {
+			return positional[i]
+		}
+		return ""
+	}
+	dataDir, _ := dirs.resolve()
+	keys := config.NewKeystore(dataDir + string(os.PathSeparator) + config.KeysDir)
+
+	name := arg(0)
+	needName := func() error {
+		if name == "" {
+			return fmt.Errorf("keys %s needs a key name", args[0])
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "generate":
+		if err := needName(); err != nil {
+			return err
+		}
+		key, err := keys.Generate(name)
+		if err != nil {
+			return err
+		}
+		printKey(name, key)
+	case "import":
+		if err := needName(); err != nil {
+			return err
+		}
+		encoded := arg(1)
+		if encoded == "" {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return fmt.Errorf("reading key from stdin: %w", err)
+			}
+			encoded = line
+		}
+		key, err := config.ParsePrivateKey(encoded)
+		if err != nil {
+			return err
+		}
+		if err := keys.Import(name, key); err != nil {
+			return err
+		}
+		printKey(name, key)
+	case "export":
+		if err := needName(); err != nil {
+			return err
+		}
+		key, err := keys.Load(name)
+		if err != nil {
+			return err
+		}
+		fmt.Println(hex.EncodeToString(key.ToBytes()))
+	case "list":
+		names, err := keys.List()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			key, err := keys.Load(name)
+			if err != nil {
+				return err
+			}
+			printKey(name, key)
+		}
+	default:
+		fmt.Fprint(os.Stderr, keysUsage)
+		return fmt.Errorf("unknown keys command %q", args[0])
+	}
+
+	return nil
+}
+
+// printKey prints the name, address and node id of a key
+func printKey(name string, key *crypto.PrivateKey) {
+	addr := key.PublicKey().Address()
+	fmt.Printf("%-16s address %s  node id %s\n", name, addr.String(), nodeID(key))
+}
+
+// nodeID returns the peer id of the node identified by key
+func nodeID(key *crypto.PrivateKey) network.NetAddr {
+	return network.PeerID(key.PublicKey())
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/majorshift/safari-chain/config"
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/majorshift/safari-chain/network"
+	"github.com/majorshift/safari-chain/rpc"
+	"github.com/sirupsen/logrus"
+)
+
+// runStart runs a node until it receives SIGINT or SIGTERM
+func runStart(args []string) error {
+	var dirs dataDirFlags
+	var bootstrap listFlag
+	fs := flag.NewFlagSet("start", flag.ContinueOnError)
+	dirs.register(fs)
+	listen := fs.String("listen", "", "address to accept peers on, overrides listen_addr")
+	external := fs.String("external", "", "address advertised to peers, overrides external_addr")
+	rpcAddr := fs.String("rpc", "", "address to serve the JSON-RPC API on, overrides rpc_addr; empty disables it")
+	fs.Var(&bootstrap, "bootstrap", "node address to join the network through, overrides bootstrap; repeatable or comma separated")
+	validator := fs.String("validator", "", "name of the key to produce blocks with, overrides validator")
+	noValidator := fs.Bool("no-validator", false, "do not produce blocks")
+	logLevel := fs.String("log-level", "", "log level, overrides log_level")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	set := setFlags(fs)
+	c, err := dirs.load(func(c *config.Config) {
+		if set["listen"] {
+			c.ListenAddr = *listen
+		}
+		if set["external"] {
+			c.ExternalAddr = *external
+		}
+		if set["rpc"] {
+			c.RPCAddr = *rpcAddr
+		}
+		if set["bootstrap"] {
+			c.Bootstrap = bootstrap
+		}
+		if set["validator"] {
+			c.Validator = *validator
+		}
+		if *noValidator {
+			c.Validator = ""
+		}
+		if set["log-level"] {
+			c.LogLevel = *logLevel
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	logger := logrus.New()
+	level, _ := logrus.ParseLevel(c.LogLevel)
+	logger.SetLevel(level)
+
+	opts, err := serverOpts(c, logger)
+	if err != nil {
+		return err
+	}
+	server, err := network.NewServer(opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := server.Start(ctx); err != nil {
+		return err
+	}
+	if c.RPCAddr != "" {
+		api := rpc.NewServer(server.Chain(), server.Mempool(), rpc.ServerOpts{Submit: server.SubmitTx, Metrics: server.Metrics(), Logger: logger})
+		if _, err := api.Start(ctx, c.RPCAddr); err != nil {
+			server.Stop()
+			return err
+		}
+	}
+	<-ctx.Done()
+	logger.Info("shutting down")
+	server.Stop()
+
+	return nil
+}
+
+// serverOpts builds the options of the node described by c
+func serverOpts(c *config.Config, logger *logrus.Logger) (network.ServerOpts, error) {
+	genesis, err := config.LoadGenesis(c.Path(config.GenesisFile))
+	if err != nil {
+		return network.ServerOpts{}, err
+	}
+
+	keys := c.Keystore()
+	nodeKey, err := keys.LoadOrGenerate(c.NodeKey)
+	if err != nil {
+		return network.ServerOpts{}, err
+	}
+	var validator *crypto.PrivateKey
+	if c.Validator != "" {
+		if validator, err = keys.Load(c.Validator); err != nil {
+			return network.ServerOpts{}, err
+		}
+	}
+
+	var bootstrap []network.NetAddr
+	for _, addr := range c.Bootstrap {
+		bootstrap = append(bootstrap, network.NetAddr(addr))
+	}
+	var external network.NetAddr
+	if c.ExternalAddr != "" {
+		external = network.NewNodeAddr(nodeKey.PublicKey(), c.ExternalAddr)
+	}
+	// the peer manager takes 0 for its default
+	maxInbound := c.Peers.MaxInbound
+	if maxInbound == 0 {
+		maxInbound = -1
+	}
+	var journal string
+	if c.Mempool.Journal {
+		journal = c.Path(config.JournalFile)
+	}
+
+	return network.ServerOpts{
+		Transport: network.NewTCPTransport(network.TCPTransportOpts{
+			ListenAddr: c.ListenAddr,
+			PrivateKey: nodeKey,
+			Logger:     logger,
+		}),
+		Genesis:     genesis.Block,
+		ChainConfig: genesis.ChainConfig(),
+		PrivateKey:  validator,
+		BlockTime:   c.BlockTime,
+		Mempool: network.MempoolOpts{
+			MaxSize: c.Mempool.MaxSize,
+			TTL:     c.Mempool.TTL,
+			Journal: journal,
+		},
+		Peers: network.PeerManagerOpts{
+			MaxInbound:  maxInbound,
+			MaxOutbound: c.Peers.MaxOutbound,
+			BanFile:     c.Path(config.BansFile),
+		},
+		Discovery: network.DiscoveryOpts{
+			Bootstrap:    bootstrap,
+			ExternalAddr: external,
+			MaxOutbound:  c.Peers.MaxOutbound,
+			Book:         network.NewAddressBook(network.AddressBookOpts{File: c.Path(config.PeersFile)}),
+		},
+		Logger: logger,
+	}, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/majorshift/safari-chain/network"
+	"github.com/sirupsen/logrus"
+)
+
+// runStatus connects to a running node as a peer and prints the chain
+// status it announces, along with the round trip time of a ping
+func runStatus(args []string) error {
+	var dirs dataDirFlags
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	dirs.register(fs)
+	addr := fs.String("addr", "", "node to ask, as host:port or <node id>@host:port (default: the listen address of the config)")
+	timeout := fs.Duration("timeout", 5*time.Second, "how long to wait for the node")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	target := network.NetAddr(*addr)
+	if target == "" {
+		c, err := dirs.load(nil)
+		if err != nil {
+			return err
+		}
+		host, port, _ := net.SplitHostPort(c.ListenAddr)
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "127.0.0.1"
+		}
+		target = network.NetAddr(net.JoinHostPort(host, port))
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	transport := network.NewTCPTransport(network.TCPTransportOpts{
+		ListenAddr: "127.0.0.1:0",
+		Logger:     logger,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := transport.Start(ctx); err != nil {
+		return err
+	}
+	defer transport.Close()
+
+	if _, err := transport.Dial(target); err != nil {
+		return fmt.Errorf("connecting to %s: %w", target, err)
+	}
+
+	var status *network.StatusMessage
+	var rtt time.Duration
+	var pingSent time.Time
+	for status == nil || rtt == 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("no status from %s within %s", target, *timeout)
+		case rpc := <-transport.Consume():
+			msg, err := network.DecodeMessage(rpc.Payload)
+			if err != nil {
+				return err
+			}
+			p, err := msg.DecodePayload()
+			if err != nil {
+				return err
+			}
+			switch p := p.(type) {
+			case *network.StatusMessage:
+				status = p
+				pingSent = time.Now()
+				ping, err := network.EncodeMessage(&network.PingMessage{Nonce: uint64(pingSent.UnixNano())})
+				if err != nil {
+					return err
+				}
+				if err := transport.SendMessage(rpc.From, ping); err != nil {
+					return err
+				}
+				fmt.Printf("node      %s\n", rpc.From)
+			case *network.PongMessage:
+				if !pingSent.IsZero() && p.Nonce == uint64(pingSent.UnixNano()) {
+					rtt = time.Since(pingSent)
+				}
+			}
+		}
+	}
+
+	fmt.Printf("chain id  %d\n", status.ChainID)
+	fmt.Printf("genesis   %s\n", status.Genesis.ToString())
+	fmt.Printf("height    %d\n", status.Height)
+	fmt.Printf("head      %s\n", status.Head.ToString())
+	fmt.Printf("latency   %s\n", rtt)
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/majorshift/safari-chain/network"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// File names within the data directory
+const (
+	ConfigFile  = "config.yaml"
+	GenesisFile = "genesis.json"
+	KeysDir     = "keys"
+	PeersFile   = "peers.json"
+	BansFile    = "bans.json"
+	JournalFile = "transactions.journal"
+)
+
+// EnvPrefix starts the names of the environment variables overriding the
+// config file, e.g. SAFARI_LISTEN_ADDR for listen_addr
+const EnvPrefix = "SAFARI_"
+
+// ErrInvalidConfig is wrapped by every validation error
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Config holds the settings of a node. They are read from a YAML file in
+// the data directory, then overridden by environment variables and
+// command-line flags
+type Config struct {
+	DataDir      string        `yaml:"data_dir,omitempty"` // where the chain, keys and peer files live
+	ListenAddr   string        `yaml:"listen_addr"`        // host:port to accept peers on
+	ExternalAddr string        `yaml:"external_addr"`      // host:port advertised to peers; defaults to the listen address when it has a host
+	RPCAddr      string        `yaml:"rpc_addr"`           // host:port serving the JSON-RPC API over HTTP and websockets; empty disables it
+	Bootstrap    []string      `yaml:"bootstrap"`          // node addresses dialled to join the network
+	NodeKey      string        `yaml:"node_key"`           // name of the key identifying the node to its peers
+	Validator    string        `yaml:"validator"`          // name of the key signing blocks; empty disables block production
+	BlockTime    time.Duration `yaml:"block_time"`         // interval between produced blocks
+	LogLevel     string        `yaml:"log_level"`          // one of the logrus levels
+	Mempool      MempoolConfig `yaml:"mempool"`
+	Peers        PeersConfig   `yaml:"peers"`
+}
+
+// MempoolConfig holds the mempool settings
+type MempoolConfig struct {
+	MaxSize int           `yaml:"max_size"` // transactions kept in the pool
+	TTL     time.Duration `yaml:"ttl"`      // time a transaction may stay pooled; 0 keeps it until evicted otherwise
+	Journal bool          `yaml:"journal"`  // whether local transactions survive restarts
+}
+
+// PeersConfig holds the peer connection settings
+type PeersConfig struct {
+	MaxInbound  int `yaml:"max_inbound"`  // peers that dialled us; 0 refuses them all
+	MaxOutbound int `yaml:"max_outbound"` // peers we dial; at least 1
+}
+
+// DefaultDataDir returns the data directory used when none is given:
+// .safarichain in the home directory, or in the working directory when
+// the home directory is unknown
+func DefaultDataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".safarichain"
+	}
+
+	return filepath.Join(home, ".safarichain")
+}
+
+// Default returns the config of a node keeping its files in dataDir
+func Default(dataDir string) *Config {
+	return &Config{
+		DataDir:    dataDir,
+		ListenAddr: ":3000",
+		RPCAddr:    "127.0.0.1:8545",
+		NodeKey:    "node",
+		BlockTime:  5 * time.Second,
+		LogLevel:   "info",
+		Mempool: MempoolConfig{
+			MaxSize: 4096,
+			TTL:     3 * time.Hour,
+			Journal: true,
+		},
+		Peers: PeersConfig{
+			MaxInbound:  32,
+			MaxOutbound: 8,
+		},
+	}
+}
+
+// Load reads the config file at path over the defaults for dataDir.
+// Unknown keys are an error so that typos do not go unnoticed
+func Load(path, dataDir string) (*Config, error) {
+	c := Default(dataDir)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidConfig, path, err)
+	}
+
+	return c, nil
+}
+
+// Save writes the config to path. The data directory is left out so
+// that the directory can be moved
+func (c *Config) Save(path string) error {
+	saved := *c
+	saved.DataDir = ""
+	data, err := yaml.Marshal(&saved)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// ApplyEnv overrides the settings for which lookup, usually os.LookupEnv,
+// finds a variable. Bootstrap takes a comma separated list
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	fields := map[string]*string{
+		"DATA_DIR":      &c.DataDir,
+		"LISTEN_ADDR":   &c.ListenAddr,
+		"EXTERNAL_ADDR": &c.ExternalAddr,
+		"RPC_ADDR":      &c.RPCAddr,
+		"NODE_KEY":      &c.NodeKey,
+		"VALIDATOR":     &c.Validator,
+		"LOG_LEVEL":     &c.LogLevel,
+	}
+	for name, field := range fields {
+		if v, ok := lookup(EnvPrefix + name); ok {
+			*field = v
+		}
+	}
+
+	if v, ok := lookup(EnvPrefix + "BOOTSTRAP"); ok {
+		c.Bootstrap = SplitList(v)
+	}
+	if v, ok := lookup(EnvPrefix + "BLOCK_TIME"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%w: %sBLOCK_TIME: %v", ErrInvalidConfig, EnvPrefix, err)
+		}
+		c.BlockTime = d
+	}
+	if v, ok := lookup(EnvPrefix + "MEMPOOL_MAX_SIZE"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%w: %sMEMPOOL_MAX_SIZE: %v", ErrInvalidConfig, EnvPrefix, err)
+		}
+		c.Mempool.MaxSize = n
+	}
+
+	return nil
+}
+
+// Validate checks every setting and reports all the invalid ones at once
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(field string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s: %s", ErrInvalidConfig, field, fmt.Sprintf(format, args...)))
+	}
+
+	if c.DataDir == "" {
+		invalid("data_dir", "must be set")
+	}
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		invalid("listen_addr", "%q is not a host:port address", c.ListenAddr)
+	}
+	if c.ExternalAddr != "" {
+		if _, _, err := net.SplitHostPort(c.ExternalAddr); err != nil {
+			invalid("external_addr", "%q is not a host:port address", c.ExternalAddr)
+		}
+	}
+	if c.RPCAddr != "" {
+		if _, _, err := net.SplitHostPort(c.RPCAddr); err != nil {
+			invalid("rpc_addr", "%q is not a host:port address", c.RPCAddr)
+		}
+	}
+	for _, addr := range c.Bootstrap {
+		if _, hostport, err := network.ParseNodeAddr(network.NetAddr(addr)); err != nil {
+			invalid("bootstrap", "%v", err)
+		} else if _, _, err := net.SplitHostPort(hostport); err != nil {
+			invalid("bootstrap", "%q is not a node address", addr)
+		}
+	}
+	if err := ValidateKeyName(c.NodeKey); err != nil {
+		invalid("node_key", "%v", err)
+	}
+	if c.Validator != "" {
+		if err := ValidateKeyName(c.Validator); err != nil {
+			invalid("validator", "%v", err)
+		}
+	}
+	if c.BlockTime <= 0 {
+		invalid("block_time", "must be positive, got %s", c.BlockTime)
+	}
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		invalid("log_level", "%q is not one of panic, fatal, error, warn, info, debug or trace", c.LogLevel)
+	}
+	if c.Mempool.MaxSize <= 0 {
+		invalid("mempool.max_size", "must be positive, got %d", c.Mempool.MaxSize)
+	}
+	if c.Mempool.TTL < 0 {
+		invalid("mempool.ttl", "must not be negative, got %s", c.Mempool.TTL)
+	}
+	if c.Peers.MaxInbound < 0 {
+		invalid("peers.max_inbound", "must not be negative, got %d", c.Peers.MaxInbound)
+	}
+	if c.Peers.MaxOutbound <= 0 {
+		invalid("peers.max_outbound", "must be positive, got %d", c.Peers.MaxOutbound)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Path returns the path of the file name within the data directory
+func (c *Config) Path(name string) string {
+	return filepath.Join(c.DataDir, name)
+}
+
+// Keystore returns the keystore of the data directory
+func (c *Config) Keystore() *Keystore {
+	return NewKeystore(c.Path(KeysDir))
+}
+
+// SplitList splits a comma separated list, dropping empty items
+func SplitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
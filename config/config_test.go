@@ -0,0 +1,90 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_LoadAndOverride(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ConfigFile)
+	assert.NoError(t, os.WriteFile(path, []byte(`
+listen_addr: ":4000"
+bootstrap:
+  - "127.0.0.1:3000"
+block_time: 2s
+mempool:
+  max_size: 100
+`), 0644))
+
+	c, err := Load(path, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, dir, c.DataDir)
+	assert.Equal(t, ":4000", c.ListenAddr)
+	assert.Equal(t, []string{"127.0.0.1:3000"}, c.Bootstrap)
+	assert.Equal(t, 2*time.Second, c.BlockTime)
+	assert.Equal(t, 100, c.Mempool.MaxSize)
+	// untouched settings keep their defaults
+	assert.Equal(t, 3*time.Hour, c.Mempool.TTL)
+	assert.Equal(t, "node", c.NodeKey)
+
+	env := map[string]string{
+		"SAFARI_LISTEN_ADDR": ":5000",
+		"SAFARI_BOOTSTRAP":   "127.0.0.1:3000, 127.0.0.1:3001,",
+		"SAFARI_BLOCK_TIME":  "1s",
+	}
+	assert.NoError(t, c.ApplyEnv(func(k string) (string, bool) { v, ok := env[k]; return v, ok }))
+	assert.Equal(t, ":5000", c.ListenAddr)
+	assert.Equal(t, []string{"127.0.0.1:3000", "127.0.0.1:3001"}, c.Bootstrap)
+	assert.Equal(t, time.Second, c.BlockTime)
+	assert.NoError(t, c.Validate())
+
+	env["SAFARI_BLOCK_TIME"] = "soon"
+	assert.ErrorIs(t, c.ApplyEnv(func(k string) (string, bool) { v, ok := env[k]; return v, ok }), ErrInvalidConfig)
+
+	// saved configs load back the same
+	assert.NoError(t, c.Save(path))
+	loaded, err := Load(path, "elsewhere")
+	assert.NoError(t, err)
+	assert.Equal(t, "elsewhere", loaded.DataDir)
+	loaded.DataDir = c.DataDir
+	assert.Equal(t, c, loaded)
+}
+
+func TestConfig_RejectsUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ConfigFile)
+	assert.NoError(t, os.WriteFile(path, []byte("listen_adr: \":4000\"\n"), 0644))
+
+	_, err := Load(path, "data")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, "listen_adr")
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, Default("data").Validate())
+
+	c := Default("data")
+	c.ListenAddr = "3000"
+	c.RPCAddr = "localhost"
+	c.Bootstrap = []string{"zz@127.0.0.1:3000"}
+	c.Validator = "../validator"
+	c.BlockTime = 0
+	c.LogLevel = "loud"
+	c.Mempool.MaxSize = -1
+	c.Peers.MaxOutbound = 0
+
+	err := c.Validate()
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	for _, field := range []string{"listen_addr", "rpc_addr", "bootstrap", "validator", "block_time", "log_level", "mempool.max_size", "peers.max_outbound"} {
+		assert.ErrorContains(t, err, field)
+	}
+
+	// a node may refuse inbound peers
+	c = Default("data")
+	c.Peers.MaxInbound = 0
+	assert.NoError(t, c.Validate())
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+)
+
+// Genesis describes the chain a node runs: its id, the balances it starts
+// with and its signed first block. The block has no parent, so its
+// PrevBlockHash commits to the chain id and the balances instead: nodes
+// with different genesis files cannot peer with each other
+type Genesis struct {
+	ChainID uint32
+	Alloc   map[string]uint64 // balances keyed by hex encoded address
+	Block   *crypto.Block
+}
+
+// genesisFile is the JSON form of a Genesis
+type genesisFile struct {
+	ChainID uint32            `json:"chain_id"`
+	Alloc   map[string]uint64 `json:"alloc,omitempty"`
+	Block   string            `json:"block"` // hex encoded canonical block encoding
+}
+
+// NewGenesis creates the genesis of a new chain, signing its first block
+// with validator. The addresses of alloc may carry the 0x prefix and be in
+// either case
+func NewGenesis(validator *crypto.PrivateKey, chainID uint32, alloc map[string]uint64) (*Genesis, error) {
+	alloc, err := normalizeAlloc(alloc)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &crypto.Header{
+		Version:       1,
+		PrevBlockHash: commitment(chainID, alloc),
+		Timestamp:     time.Now().UnixNano(),
+	}
+	b := crypto.NewBlock(header, []*crypto.Transaction{})
+	b.Sign(validator)
+
+	return &Genesis{ChainID: chainID, Alloc: alloc, Block: b}, nil
+}
+
+// LoadGenesis reads the genesis file at path
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f genesisFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("genesis %s: %w", path, err)
+	}
+	enc, err := hex.DecodeString(f.Block)
+	if err != nil {
+		return nil, fmt.Errorf("genesis %s: block is not hex encoded: %w", path, err)
+	}
+	b := &crypto.Block{}
+	if err := b.UnmarshalBinary(enc); err != nil {
+		return nil, fmt.Errorf("genesis %s: %w", path, err)
+	}
+	if b.Header.Height != 0 {
+		return nil, fmt.Errorf("genesis %s: block height is %d instead of 0", path, b.Header.Height)
+	}
+	if err := b.Verify(); err != nil {
+		return nil, fmt.Errorf("genesis %s: %w", path, err)
+	}
+	alloc, err := normalizeAlloc(f.Alloc)
+	if err != nil {
+		return nil, fmt.Errorf("genesis %s: %w", path, err)
+	}
+	if b.Header.PrevBlockHash != commitment(f.ChainID, alloc) {
+		return nil, fmt.Errorf("genesis %s: block does not commit to the chain id and alloc", path)
+	}
+
+	return &Genesis{ChainID: f.ChainID, Alloc: alloc, Block: b}, nil
+}
+
+// Save writes the genesis to path, refusing to overwrite an existing file
+func (g *Genesis) Save(path string) error {
+	enc, err := g.Block.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(genesisFile{
+		ChainID: g.ChainID,
+		Alloc:   g.Alloc,
+		Block:   hex.EncodeToString(enc),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// ChainConfig returns the chain config of the genesis, using the default
+// protocol parameters
+func (g *Genesis) ChainConfig() *crypto.ChainConfig {
+	config := crypto.DefaultChainConfig()
+	config.ChainID = g.ChainID
+	config.Alloc = g.Alloc
+
+	return config
+}
+
+// normalizeAlloc returns alloc keyed by addresses in the form State uses:
+// lower case hex without prefix
+func normalizeAlloc(alloc map[string]uint64) (map[string]uint64, error) {
+	if len(alloc) == 0 {
+		return nil, nil
+	}
+
+	normalized := make(map[string]uint64, len(alloc))
+	for key, balance := range alloc {
+		addr, err := crypto.ParseAddress(key)
+		if err != nil {
+			return nil, fmt.Errorf("alloc: %w", err)
+		}
+		if _, ok := normalized[addr.String()]; ok {
+			return nil, fmt.Errorf("alloc: address %s is listed twice", addr.String())
+		}
+		normalized[addr.String()] = balance
+	}
+
+	return normalized, nil
+}
+
+// commitment hashes the chain id and the normalized alloc, in address order
+func commitment(chainID uint32, alloc map[string]uint64) crypto.Hash {
+	addrs := make([]string, 0, len(alloc))
+	for addr := range alloc {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, chainID)
+	for _, addr := range addrs {
+		b, _ := hex.DecodeString(addr)
+		buf.Write(b)
+		binary.Write(buf, binary.BigEndian, alloc[addr])
+	}
+
+	return crypto.Hash(sha256.Sum256(buf.Bytes()))
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/majorshift/safari-chain/crypto"
+)
+
+const keyExt = ".key"
+
+var (
+	// ErrKeyExists is returned when storing a key under a name already taken
+	ErrKeyExists = errors.New("key already exists")
+	// ErrKeyNotFound is returned when no key is stored under a name
+	ErrKeyNotFound = errors.New("key not found")
+)
+
+var keyName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Keystore keeps named private keys as hex encoded files readable by
+// their owner only
+type Keystore struct {
+	Dir string
+}
+
+func NewKeystore(dir string) *Keystore {
+	return &Keystore{Dir: dir}
+}
+
+// ValidateKeyName checks that name can name a key: 1 to 64 letters,
+// digits, dashes or underscores
+func ValidateKeyName(name string) error {
+	if !keyName.MatchString(name) {
+		return fmt.Errorf("key name %q must be 1 to 64 letters, digits, dashes or underscores", name)
+	}
+
+	return nil
+}
+
+// Generate creates a new key and stores it under name
+func (k *Keystore) Generate(name string) (*crypto.PrivateKey, error) {
+	key, err := crypto.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return key, k.Import(name, key)
+}
+
+// Import stores key under name, which must not be taken yet
+func (k *Keystore) Import(name string, key *crypto.PrivateKey) error {
+	if err := ValidateKeyName(name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(k.Dir, 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(k.path(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%w: %s", ErrKeyExists, name)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(hex.EncodeToString(key.ToBytes()) + "\n"); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// Load returns the key stored under name
+func (k *Keystore) Load(name string) (*crypto.PrivateKey, error) {
+	if err := ValidateKeyName(name); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(k.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParsePrivateKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", name, err)
+	}
+
+	return key, nil
+}
+
+// LoadOrGenerate returns the key stored under name, generating it first
+// if there is none
+func (k *Keystore) LoadOrGenerate(name string) (*crypto.PrivateKey, error) {
+	key, err := k.Load(name)
+	if errors.Is(err, ErrKeyNotFound) {
+		return k.Generate(name)
+	}
+
+	return key, err
+}
+
+// List returns the names of the stored keys in alphabetical order
+func (k *Keystore) List() ([]string, error) {
+	entries, err := os.ReadDir(k.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if name, ok := strings.CutSuffix(e.Name(), keyExt); ok && !e.IsDir() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (k *Keystore) path(name string) string {
+	return filepath.Join(k.Dir, name+keyExt)
+}
+
+// ParsePrivateKey decodes a hex encoded private key, either its 32 byte
+// seed or the 64 bytes of crypto.PrivateKey.ToBytes
+func ParsePrivateKey(s string) (*crypto.PrivateKey, error) {
+	b, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("private key is not hex encoded: %w", err)
+	}
+
+	return crypto.PrivateKeyFromBytes(b)
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeystore(t *testing.T) {
+	k := NewKeystore(filepath.Join(t.TempDir(), KeysDir))
+
+	names, err := k.List()
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+
+	generated, err := k.Generate("validator")
+	assert.NoError(t, err)
+	_, err = k.Generate("validator")
+	assert.ErrorIs(t, err, ErrKeyExists)
+
+	imported, _ := crypto.GeneratePrivateKey()
+	assert.NoError(t, k.Import("alice", imported))
+	assert.Error(t, k.Import("../alice", imported))
+
+	loaded, err := k.Load("validator")
+	assert.NoError(t, err)
+	assert.Equal(t, generated.ToBytes(), loaded.ToBytes())
+	_, err = k.Load("bob")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	names, err = k.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "validator"}, names)
+
+	// keys are private to their owner
+	info, err := os.Stat(filepath.Join(k.Dir, "alice.key"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	node, err := k.LoadOrGenerate("node")
+	assert.NoError(t, err)
+	again, err := k.LoadOrGenerate("node")
+	assert.NoError(t, err)
+	assert.Equal(t, node.ToBytes(), again.ToBytes())
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key, _ := crypto.GeneratePrivateKey()
+
+	parsed, err := ParsePrivateKey(" " + hex.EncodeToString(key.ToBytes()[:32]) + "\n")
+	assert.NoError(t, err)
+	assert.Equal(t, key.ToBytes(), parsed.ToBytes())
+
+	_, err = ParsePrivateKey("not hex")
+	assert.Error(t, err)
+}
+
+func TestGenesis_SaveLoad(t *testing.T) {
+	validator, _ := crypto.GeneratePrivateKey()
+	path := filepath.Join(t.TempDir(), GenesisFile)
+
+	addr := validator.PublicKey().Address()
+	g, err := NewGenesis(validator, 7, map[string]uint64{"0x" + strings.ToUpper(addr.String()): 100})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint64{addr.String(): 100}, g.Alloc)
+	assert.NoError(t, g.Save(path))
+	assert.Error(t, g.Save(path), "an existing genesis is never overwritten")
+
+	loaded, err := LoadGenesis(path)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(7), loaded.ChainID)
+	assert.Equal(t, g.Alloc, loaded.Alloc)
+	assert.Equal(t, g.Block.Hash(crypto.BlockHash{}), loaded.Block.Hash(crypto.BlockHash{}))
+	assert.Equal(t, uint32(7), loaded.ChainConfig().ChainID)
+
+	// a tampered block is rejected
+	other, err := NewGenesis(validator, 7, nil)
+	assert.NoError(t, err)
+	other.Block.Header.Timestamp++
+	tampered := filepath.Join(t.TempDir(), GenesisFile)
+	assert.NoError(t, other.Save(tampered))
+	_, err = LoadGenesis(tampered)
+	assert.Error(t, err)
+
+	// so are a chain id or balances the block does not commit to
+	for _, edit := range []func(g *Genesis){
+		func(g *Genesis) { g.ChainID = 8 },
+		func(g *Genesis) { g.Alloc[addr.String()] = 1000 },
+	} {
+		g, err := NewGenesis(validator, 7, map[string]uint64{addr.String(): 100})
+		assert.NoError(t, err)
+		edit(g)
+		edited := filepath.Join(t.TempDir(), GenesisFile)
+		assert.NoError(t, g.Save(edited))
+		_, err = LoadGenesis(edited)
+		assert.ErrorContains(t, err, "does not commit")
+	}
+
+	_, err = NewGenesis(validator, 7, map[string]uint64{"aa": 100})
+	assert.ErrorIs(t, err, crypto.ErrInvalidAddress)
+}
@@ -1,6 +1,10 @@
 package crypto
 
-import "encoding/hex"
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
 
 type Address struct {
 	value []byte
@@ -14,3 +18,15 @@ func (a *Address) Bytes() []byte {
 func (a *Address) String() string {
 	return hex.EncodeToString(a.value)
 }
+
+// ParseAddress decodes a hex encoded address, with or without the 0x
+// prefix and in either case
+func ParseAddress(s string) (Address, error) {
+	hexAddr := strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	b, err := hex.DecodeString(hexAddr)
+	if err != nil || len(b) != AddressLen {
+		return Address{}, fmt.Errorf("%w: %q is not a hex encoded %d byte address", ErrInvalidAddress, s, AddressLen)
+	}
+
+	return Address{value: b}, nil
+}
@@ -3,9 +3,12 @@ package crypto
 import (
 	"bytes"
 	"crypto/sha256"
-	"encoding/gob"
 	"errors"
-	"fmt"
+)
+
+const (
+	// headerSize is the number of bytes taken by the fixed size header fields
+	headerSize = 4 + hashLen + hashLen + 8 + 4
 )
 
 // Header structure
@@ -17,11 +20,11 @@ type Header struct {
 	Height        uint32 // number of blocks in the blockchain - 1
 }
 
-// ToBytes converts Header to a byte slice
+// ToBytes returns the canonical encoding of the header,
+// which is what block hashes and signatures cover
 func (h *Header) ToBytes() []byte {
 	buf := &bytes.Buffer{}
-	enc := gob.NewEncoder(buf)
-	enc.Encode(h)
+	h.encode(buf)
 
 	return buf.Bytes()
 }
@@ -58,34 +61,60 @@ func (b *Block) Hash(hasher Hasher[*Header]) Hash {
 	return hasher.Hash(b.Header)
 }
 
-// Verify checks the validity of a block
+// Size returns the number of bytes the block occupies: the header,
+// the validator key and signature and every transaction.
+// Limits on it are set by Params.MaxBlockSize
+func (b *Block) Size() int {
+	size := headerSize + PubKeyLen + SignatureLen
+	for _, tx := range b.Transactions {
+		size += tx.Size()
+	}
+
+	return size
+}
+
+// Verify checks the validity of a block.
+// Any failure is returned as a *BlockError
 func (b *Block) Verify() error {
 	// check that the header is signed
 	if b.Signature == nil {
-		return errors.New("block header has no signature")
+		return b.error(ErrBlockNoSignature)
 	}
 
 	// verify that the signature is valid i.e. no mismatch
-	if !b.Signature.Verify(b.Validator, b.Header.ToBytes()) {
-		return errors.New("block header has invalid signature")
+	if b.Validator == nil || !b.Signature.Verify(b.Validator, b.Header.ToBytes()) {
+		return b.error(ErrBlockInvalidSignature)
 	}
 
 	// check the validity of all transaction signatures; all must be valid
-	for _, tx := range b.Transactions {
+	for i, tx := range b.Transactions {
 		if err := tx.Verify(); err != nil {
-			return err
+			var txErr *TxError
+			if errors.As(err, &txErr) {
+				txErr.Index = i
+			}
+			return b.error(err)
 		}
 	}
 
 	// verify merkle root
 	merkleRoot := ComputeMerkleRoot(b.Transactions)
 	if b.Header.MerkleRoot != merkleRoot {
-		return fmt.Errorf("merkle root does not match")
+		return b.error(ErrMerkleRootMismatch)
 	}
 
 	return nil
 }
 
+// error wraps err in a BlockError describing this block
+func (b *Block) error(err error) *BlockError {
+	return &BlockError{
+		Height: b.Header.Height,
+		Hash:   b.Hash(BlockHash{}),
+		Err:    err,
+	}
+}
+
 // ComputeMerkleRoot calculates the merkle root of a given list of transactions
 func ComputeMerkleRoot(transactions []*Transaction) Hash {
 	if len(transactions) == 0 {
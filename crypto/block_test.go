@@ -84,3 +84,31 @@ func TestComputeMerkleRoot(t *testing.T) {
 	// verification fails
 	assert.Error(t, b.Verify())
 }
+
+func TestVerifyBlock_ErrorsAreTyped(t *testing.T) {
+	validator, _ := GeneratePrivateKey()
+
+	// unsigned block
+	b := ExampleBlock(3, Hash{})
+	err := b.Verify()
+	assert.ErrorIs(t, err, ErrBlockNoSignature)
+
+	var blockErr *BlockError
+	assert.ErrorAs(t, err, &blockErr)
+	assert.Equal(t, uint32(3), blockErr.Height)
+	assert.Equal(t, b.Hash(BlockHash{}), blockErr.Hash)
+
+	// second transaction is unsigned
+	txs := []*Transaction{
+		NewTxWithSignature([]byte("Hello, World")),
+		{Data: []byte("unsigned")},
+	}
+	b = NewSignedBlockExample(validator, txs, 0, Hash{})
+	err = b.Verify()
+	assert.ErrorIs(t, err, ErrTxNoSignature)
+
+	var txErr *TxError
+	assert.ErrorAs(t, err, &txErr)
+	assert.Equal(t, 1, txErr.Index)
+	assert.Equal(t, txs[1].Hash(TxHash{}), txErr.Hash)
+}
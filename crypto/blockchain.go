@@ -4,20 +4,48 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/majorshift/safari-chain/events"
 	"github.com/sirupsen/logrus"
 )
 
+// ChainEvent describes a change of the canonical chain
+type ChainEvent struct {
+	Added    []*Block // blocks that became part of the chain, in height order
+	Detached []*Block // blocks removed from the chain by a reorg, in height order
+}
+
 type Blockchain struct {
-	lock      sync.RWMutex   // Mutex to manage concurrent read and write access
-	headers   []*Header      // Slice to store block headers instead of full blocks for efficiency
-	logger    *logrus.Logger // Logger to track blockchain activity and debugging
-	validator Validator      // Validator to verify block and transaction validity
+	lock        sync.RWMutex       // Mutex to manage concurrent read and write access
+	addLock     sync.Mutex         // Serializes AddBlock and SwitchFork, from validation until the subscribers are notified
+	headers     []*Header          // Slice to store block headers instead of full blocks for efficiency
+	blocks      []*Block           // Full blocks, indexed by height; needed to replay state and serve peers
+	state       *State             // Account state after applying every block
+	logger      *logrus.Logger     // Logger to track blockchain activity and debugging
+	validator   Validator          // Validator to verify block and transaction validity
+	config      *ChainConfig       // Protocol parameters set at genesis and their upgrades
+	subscribers []func(ChainEvent) // Callbacks notified whenever the chain changes
+	blockIndex  map[Hash]uint32    // Height of every block of the chain by header hash
+	txIndex     map[Hash]uint32    // Height of the block including every transaction of the chain by hash
+	bus         *events.Bus        // Bus the chain events are published on
 }
 
+// NewBlockchain creates a blockchain using the default chain config
 func NewBlockchain(log *logrus.Logger, genesis *Block) *Blockchain {
+	return NewBlockchainWithConfig(log, DefaultChainConfig(), genesis)
+}
+
+// NewBlockchainWithConfig creates a blockchain whose blocks are validated
+// against the protocol parameters in config
+func NewBlockchainWithConfig(log *logrus.Logger, config *ChainConfig, genesis *Block) *Blockchain {
 	bc := &Blockchain{
-		headers: []*Header{},
-		logger:  log,
+		headers:    []*Header{},
+		blocks:     []*Block{},
+		state:      NewState(config.Alloc),
+		logger:     log,
+		config:     config,
+		blockIndex: make(map[Hash]uint32),
+		txIndex:    make(map[Hash]uint32),
+		bus:        events.NewBus(events.BusOpts{Logger: log}),
 	}
 
 	bc.validator = NewBlockValidator(bc)
@@ -25,8 +53,36 @@ func NewBlockchain(log *logrus.Logger, genesis *Block) *Blockchain {
 	return bc
 }
 
-// AddBlock adds a new block to the blockchain
+// Config returns the chain config the blockchain was created with
+func (bc *Blockchain) Config() *ChainConfig {
+	return bc.config
+}
+
+// Events returns the bus the chain publishes BlockAdded, ChainReorg and
+// TxIncluded events on. Publishing never waits for the subscribers
+func (bc *Blockchain) Events() *events.Bus {
+	return bc.bus
+}
+
+// Subscribe registers fn to be called after every change of the chain.
+// Callbacks run synchronously, after the chain lock has been released and
+// before the events are published, so they may read from the blockchain
+// but should return quickly and must not add blocks. They are meant for the components that must
+// keep in step with the chain, such as the mempool; the others should
+// subscribe to the event bus
+func (bc *Blockchain) Subscribe(fn func(ChainEvent)) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.subscribers = append(bc.subscribers, fn)
+}
+
+// AddBlock adds a new block to the blockchain. Concurrent calls are
+// serialized, so every block is validated against the tip it extends
 func (bc *Blockchain) AddBlock(b *Block) error {
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
+
 	if err := bc.validator.ValidateBlock(b); err != nil {
 		return err
 	}
@@ -37,6 +93,68 @@ func (bc *Blockchain) AddBlock(b *Block) error {
 	return nil
 }
 
+// SwitchFork replaces the chain from the height of the first block in
+// branch onwards with branch, provided the resulting chain is longer.
+// Every block of the branch is validated against the state at the fork point
+func (bc *Blockchain) SwitchFork(branch []*Block) error {
+	if len(branch) == 0 {
+		return nil
+	}
+
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
+	bc.lock.Lock()
+
+	forkHeight := branch[0].Header.Height
+	if forkHeight == 0 || int(forkHeight) > len(bc.headers) {
+		bc.lock.Unlock()
+		return branch[0].error(fmt.Errorf("%w: no parent at height %d", ErrUnknownBlock, forkHeight-1))
+	}
+	if int(forkHeight)+len(branch) <= len(bc.headers) {
+		bc.lock.Unlock()
+		return branch[0].error(ErrForkTooShort)
+	}
+
+	state := bc.replay(forkHeight)
+	parent := bc.headers[forkHeight-1]
+	for i, b := range branch {
+		if b.Header.Height != forkHeight+uint32(i) {
+			bc.lock.Unlock()
+			return b.error(fmt.Errorf("%w: fork is not contiguous", ErrBlockTooHigh))
+		}
+		if err := validateChild(bc.config, b, parent, state); err != nil {
+			bc.lock.Unlock()
+			return err
+		}
+		parent = b.Header
+	}
+
+	detached := make([]*Block, len(bc.blocks)-int(forkHeight))
+	copy(detached, bc.blocks[forkHeight:])
+
+	for _, b := range detached {
+		bc.unindex(b)
+	}
+	bc.blocks = append(bc.blocks[:forkHeight], branch...)
+	bc.headers = bc.headers[:forkHeight]
+	for _, b := range branch {
+		bc.headers = append(bc.headers, b.Header)
+		bc.index(b)
+	}
+	bc.state = state
+	subscribers := bc.subscribers
+	bc.lock.Unlock()
+
+	bc.logger.WithFields(logrus.Fields{
+		"fork height": forkHeight,
+		"detached":    len(detached),
+		"attached":    len(branch),
+	}).Warn("switched to fork")
+
+	bc.notify(subscribers, ChainEvent{Added: branch, Detached: detached})
+	return nil
+}
+
 // HasBlock checks if a block of a given height exists in the blockchain
 func (bc *Blockchain) HasBlock(height uint32) bool {
 	return height <= bc.GetBlockchainHeight() // less than/equal to since height begins at 0
@@ -46,7 +164,7 @@ func (bc *Blockchain) HasBlock(height uint32) bool {
 // or error is height is higher than the blockchain height
 func (bc *Blockchain) GetHeaderByHeight(height uint32) (*Header, error) {
 	if height > bc.GetBlockchainHeight() {
-		return nil, fmt.Errorf("given height (%d) is greater than the blockchain height", height)
+		return nil, fmt.Errorf("given height (%d) is greater than the blockchain height: %w", height, ErrUnknownBlock)
 	}
 
 	bc.lock.Lock()
@@ -55,6 +173,53 @@ func (bc *Blockchain) GetHeaderByHeight(height uint32) (*Header, error) {
 	return bc.headers[height], nil
 }
 
+// GetBlockByHeight returns the full block at given height
+// or error is height is higher than the blockchain height
+func (bc *Blockchain) GetBlockByHeight(height uint32) (*Block, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	if int(height) >= len(bc.blocks) {
+		return nil, fmt.Errorf("given height (%d) is greater than the blockchain height: %w", height, ErrUnknownBlock)
+	}
+
+	return bc.blocks[height], nil
+}
+
+// GetBlockByHash returns the block of the chain whose header hashes to hash
+func (bc *Blockchain) GetBlockByHash(hash Hash) (*Block, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	height, ok := bc.blockIndex[hash]
+	if !ok {
+		return nil, fmt.Errorf("block %s: %w", hash.ToString(), ErrUnknownBlock)
+	}
+
+	return bc.blocks[height], nil
+}
+
+// GetTransaction returns the transaction of the chain with the given hash
+// along with the block including it
+func (bc *Blockchain) GetTransaction(hash Hash) (*Transaction, *Block, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	height, ok := bc.txIndex[hash]
+	if !ok {
+		return nil, nil, fmt.Errorf("transaction %s: %w", hash.ToString(), ErrUnknownTx)
+	}
+
+	b := bc.blocks[height]
+	for _, tx := range b.Transactions {
+		if tx.Hash(TxHash{}) == hash {
+			return tx, b, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("transaction %s: %w", hash.ToString(), ErrUnknownTx)
+}
+
 // GetBlockchainHeight returns the height of the entire blockchain
 // height is calculated similar to array indices hence
 // height = (number of blocks in the blockchain) - 1
@@ -65,16 +230,90 @@ func (bc *Blockchain) GetBlockchainHeight() uint32 {
 	return uint32(len(bc.headers) - 1)
 }
 
+// Account returns the confirmed account of the owner of pub
+func (bc *Blockchain) Account(pub *PublicKey) Account {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.state.Account(pub)
+}
+
+// stateCopy returns a copy of the current state that can be modified freely
+func (bc *Blockchain) stateCopy() *State {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.state.Copy()
+}
+
+// replay rebuilds the state after the first n blocks of the chain.
+// The caller must hold the lock
+func (bc *Blockchain) replay(n uint32) *State {
+	state := NewState(bc.config.Alloc)
+	for _, b := range bc.blocks[:n] {
+		// blocks in the chain were validated when added
+		// so only the genesis block can fail here
+		state.ApplyBlock(b)
+	}
+
+	return state
+}
+
 // addBlock a new block to the blockchain by appending it's
 // header to the blockchain header list
 func (bc *Blockchain) addBlock(b *Block) {
 	bc.lock.Lock()
-	defer bc.lock.Unlock()
 
 	bc.headers = append(bc.headers, b.Header)
+	bc.blocks = append(bc.blocks, b)
+	bc.index(b)
+	if err := bc.state.ApplyBlock(b); err != nil {
+		// only the genesis block skips validation
+		bc.logger.WithError(err).Warn("genesis block contains invalid transaction")
+	}
+	subscribers := bc.subscribers
+	bc.lock.Unlock()
+
 	bc.logger.WithFields(logrus.Fields{
 		"height":                 b.Header.Height,
 		"hash":                   b.Hash(BlockHash{}),
 		"number of transactions": len(b.Transactions),
 	}).Info("adding new block")
+
+	bc.notify(subscribers, ChainEvent{Added: []*Block{b}})
+}
+
+// index records the block and transaction hashes of b. The caller must hold the lock
+func (bc *Blockchain) index(b *Block) {
+	bc.blockIndex[b.Hash(BlockHash{})] = b.Header.Height
+	for _, tx := range b.Transactions {
+		bc.txIndex[tx.Hash(TxHash{})] = b.Header.Height
+	}
+}
+
+// unindex forgets the block and transaction hashes of a detached block.
+// Transactions included again by the new branch are indexed afterwards.
+// The caller must hold the lock
+func (bc *Blockchain) unindex(b *Block) {
+	delete(bc.blockIndex, b.Hash(BlockHash{}))
+	for _, tx := range b.Transactions {
+		delete(bc.txIndex, tx.Hash(TxHash{}))
+	}
+}
+
+// notify passes ev to every subscriber, then publishes it on the bus
+func (bc *Blockchain) notify(subscribers []func(ChainEvent), ev ChainEvent) {
+	for _, fn := range subscribers {
+		fn(ev)
+	}
+
+	if len(ev.Detached) > 0 {
+		bc.bus.Publish(ChainReorg{Detached: ev.Detached, Added: ev.Added})
+	}
+	for _, b := range ev.Added {
+		bc.bus.Publish(BlockAdded{Block: b})
+		for _, tx := range b.Transactions {
+			bc.bus.Publish(TxIncluded{Tx: tx, Block: b})
+		}
+	}
 }
@@ -1,9 +1,13 @@
 package crypto
 
 import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/majorshift/safari-chain/events"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
-	"testing"
 )
 
 // helper function to get previous block's hash
@@ -71,7 +75,7 @@ func TestNewBlockchain_AddBlockFailsWithInvalidPreviousBlockHash(t *testing.T) {
 	block1 := NewSignedBlockExample(validatorPrivateKey, []*Transaction{tx1}, 1, prevBlockHash)
 	// add block1 to the blockchain
 	err := blockchain.AddBlock(block1)
-	assert.NotNil(t, err)
+	assert.ErrorIs(t, err, ErrPrevHashMismatch)
 
 	// blockchain height remains as 0
 	assert.Equal(t, uint32(0), blockchain.GetBlockchainHeight())
@@ -92,7 +96,330 @@ func TestBlockTooHigh(t *testing.T) {
 
 	err := blockchain.AddBlock(block1)
 	// addition of block1 to blockchain fails
-	assert.Error(t, err)
+	assert.ErrorIs(t, err, ErrBlockTooHigh)
 	// blockchain height remains at 0
 	assert.Equal(t, uint32(0), blockchain.GetBlockchainHeight())
 }
+
+func TestAddBlock_KnownBlockIsBenign(t *testing.T) {
+	blockchain := newBlockchainWithGenesisExample()
+
+	validatorPrivateKey, _ := GeneratePrivateKey()
+	prevBlockHash := getPrevBlockHash(t, blockchain, 1)
+	block1 := NewSignedBlockExample(validatorPrivateKey, []*Transaction{NewTxWithSignature([]byte("Hello, World"))}, 1, prevBlockHash)
+	assert.Nil(t, blockchain.AddBlock(block1))
+
+	// adding the same block a second time is reported as a known block
+	err := blockchain.AddBlock(block1)
+	assert.ErrorIs(t, err, ErrBlockKnown)
+	assert.True(t, IsBenign(err))
+
+	var blockErr *BlockError
+	assert.ErrorAs(t, err, &blockErr)
+	assert.Equal(t, uint32(1), blockErr.Height)
+
+	// another block at the same height conflicts with the chain
+	other := NewSignedBlockExample(validatorPrivateKey, []*Transaction{}, 1, prevBlockHash)
+	err = blockchain.AddBlock(other)
+	assert.ErrorIs(t, err, ErrBlockConflict)
+	assert.False(t, IsBenign(err))
+
+	// and a forged one is invalid
+	other.Header.Timestamp++
+	assert.ErrorIs(t, blockchain.AddBlock(other), ErrBlockInvalidSignature)
+}
+
+// slowValidator widens the window between validating a block and adding it
+type slowValidator struct {
+	Validator
+}
+
+func (v slowValidator) ValidateBlock(b *Block) error {
+	err := v.Validator.ValidateBlock(b)
+	time.Sleep(10 * time.Millisecond)
+	return err
+}
+
+func TestAddBlock_ConcurrentBlocksAtSameHeight(t *testing.T) {
+	blockchain := newBlockchainWithGenesisExample()
+	blockchain.validator = slowValidator{blockchain.validator}
+	prevBlockHash := getPrevBlockHash(t, blockchain, 1)
+
+	// only one of the competing blocks for height 1 may be added
+	errs := make([]error, 4)
+	var wg sync.WaitGroup
+	for i := range errs {
+		validator, _ := GeneratePrivateKey()
+		b := NewSignedBlockExample(validator, []*Transaction{}, 1, prevBlockHash)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = blockchain.AddBlock(b)
+		}(i)
+	}
+	wg.Wait()
+
+	added := 0
+	for _, err := range errs {
+		if err == nil {
+			added++
+		}
+	}
+	assert.Equal(t, 1, added)
+	assert.Equal(t, uint32(1), blockchain.GetBlockchainHeight())
+}
+
+func TestChainConfig_ParamsAt(t *testing.T) {
+	upgraded := Params{MaxBlockSize: 10, MaxTxSize: 5, MaxTxsPerBlock: 1}
+	later := Params{MaxBlockSize: 20, MaxTxSize: 10, MaxTxsPerBlock: 2}
+	// upgrades apply by activation height whatever their order, including
+	// in configs built without NewChainConfig
+	for _, config := range []*ChainConfig{
+		NewChainConfig(1, DefaultParams(),
+			Upgrade{Height: 20, Version: 3, Params: later},
+			Upgrade{Height: 10, Version: 2, Params: upgraded},
+		),
+		{ChainID: 1, Params: DefaultParams(), Upgrades: []Upgrade{
+			{Height: 20, Version: 3, Params: later},
+			{Height: 10, Version: 2, Params: upgraded},
+		}},
+	} {
+		assert.Equal(t, DefaultParams(), config.ParamsAt(9))
+		assert.Equal(t, uint32(0), config.VersionAt(9))
+		assert.Equal(t, upgraded, config.ParamsAt(10))
+		assert.Equal(t, uint32(2), config.VersionAt(19))
+		assert.Equal(t, later, config.ParamsAt(30))
+		assert.Equal(t, uint32(3), config.VersionAt(30))
+		assert.Equal(t, DefaultParams().MaxTxsPerBlock, config.MaxTxsPerBlock())
+	}
+}
+
+func TestAddBlock_EnforcesUpgradedLimits(t *testing.T) {
+	validatorPrivateKey, _ := GeneratePrivateKey()
+	genesis := NewSignedBlockExample(validatorPrivateKey, []*Transaction{NewTxWithSignature([]byte("Hello, World"))}, 0, Hash{})
+
+	// from height 2 onwards only one transaction is allowed per block,
+	// and blocks must be at least version 2
+	params := DefaultParams()
+	params.MaxTxsPerBlock = 1
+	config := NewChainConfig(0, DefaultParams(), Upgrade{Height: 2, Version: 2, Params: params})
+	blockchain := NewBlockchainWithConfig(logrus.New(), config, genesis)
+
+	// blocks below the activation height still use the genesis parameters
+	txs := []*Transaction{NewTxWithSignature([]byte("a")), NewTxWithSignature([]byte("b"))}
+	block1 := NewSignedBlockExample(validatorPrivateKey, txs, 1, getPrevBlockHash(t, blockchain, 1))
+	assert.Nil(t, blockchain.AddBlock(block1))
+
+	// the producer cannot keep the old version to skip the upgrade
+	txs = []*Transaction{NewTxWithSignature([]byte("c"))}
+	block2 := NewSignedBlockExample(validatorPrivateKey, txs, 2, getPrevBlockHash(t, blockchain, 2))
+	assert.ErrorIs(t, blockchain.AddBlock(block2), ErrBlockVersion)
+
+	newBlock2 := func(txs []*Transaction) *Block {
+		b := NewBlock(&Header{
+			Version:       2,
+			PrevBlockHash: getPrevBlockHash(t, blockchain, 2),
+			Height:        2,
+		}, txs)
+		b.Sign(validatorPrivateKey)
+		return b
+	}
+	err := blockchain.AddBlock(newBlock2(append(txs, NewTxWithSignature([]byte("d")))))
+	assert.ErrorIs(t, err, ErrTooManyTxs)
+	assert.Equal(t, uint32(1), blockchain.GetBlockchainHeight())
+
+	assert.Nil(t, blockchain.AddBlock(newBlock2(txs)))
+}
+
+func TestAddBlock_RejectsOversizedTransaction(t *testing.T) {
+	validatorPrivateKey, _ := GeneratePrivateKey()
+	genesis := NewSignedBlockExample(validatorPrivateKey, []*Transaction{NewTxWithSignature([]byte("Hello, World"))}, 0, Hash{})
+
+	config := DefaultChainConfig()
+	config.Params.MaxTxSize = 256
+	blockchain := NewBlockchainWithConfig(logrus.New(), config, genesis)
+
+	txs := []*Transaction{NewTxWithSignature([]byte("a")), NewTxWithSignature(make([]byte, 512))}
+	block1 := NewSignedBlockExample(validatorPrivateKey, txs, 1, getPrevBlockHash(t, blockchain, 1))
+
+	err := blockchain.AddBlock(block1)
+	assert.ErrorIs(t, err, ErrTxTooLarge)
+
+	var txErr *TxError
+	assert.ErrorAs(t, err, &txErr)
+	assert.Equal(t, 1, txErr.Index)
+}
+
+func TestBlockchain_AppliesTransfers(t *testing.T) {
+	sender, _ := GeneratePrivateKey()
+	receiver, _ := GeneratePrivateKey()
+	senderAddr := sender.PublicKey().Address()
+
+	config := DefaultChainConfig()
+	config.Alloc = map[string]uint64{senderAddr.String(): 100}
+	genesis := NewSignedBlockExample(sender, []*Transaction{}, 0, Hash{})
+	blockchain := NewBlockchainWithConfig(logrus.New(), config, genesis)
+
+	tx := NewTransaction(sender.PublicKey(), receiver.PublicKey(), []byte("transfer"))
+	tx.Value = 40
+	tx.Sign(sender)
+	assert.Nil(t, blockchain.AddBlock(NewSignedBlockExample(sender, []*Transaction{tx}, 1, getPrevBlockHash(t, blockchain, 1))))
+
+	assert.Equal(t, Account{Balance: 60, Nonce: 1}, blockchain.Account(sender.PublicKey()))
+	assert.Equal(t, Account{Balance: 40}, blockchain.Account(receiver.PublicKey()))
+
+	// replaying the same nonce fails
+	err := blockchain.AddBlock(NewSignedBlockExample(sender, []*Transaction{tx}, 2, getPrevBlockHash(t, blockchain, 2)))
+	assert.ErrorIs(t, err, ErrInvalidNonce)
+
+	// spending more than the balance fails
+	tx = NewTransaction(sender.PublicKey(), receiver.PublicKey(), []byte("transfer"))
+	tx.Value = 61
+	tx.Nonce = 1
+	tx.Sign(sender)
+	err = blockchain.AddBlock(NewSignedBlockExample(sender, []*Transaction{tx}, 2, getPrevBlockHash(t, blockchain, 2)))
+	assert.ErrorIs(t, err, ErrInsufficientBalance)
+}
+
+func TestBlockchain_SwitchFork(t *testing.T) {
+	blockchain := newBlockchainWithGenesisExample()
+	validator, _ := GeneratePrivateKey()
+
+	var events []ChainEvent
+	blockchain.Subscribe(func(ev ChainEvent) {
+		events = append(events, ev)
+	})
+
+	block1 := NewSignedBlockExample(validator, []*Transaction{}, 1, getPrevBlockHash(t, blockchain, 1))
+	assert.Nil(t, blockchain.AddBlock(block1))
+
+	genesisHash := getPrevBlockHash(t, blockchain, 1)
+	fork1 := NewSignedBlockExample(validator, []*Transaction{NewTxWithSignature([]byte("fork"))}, 1, genesisHash)
+
+	// a fork of the same length is not enough
+	assert.ErrorIs(t, blockchain.SwitchFork([]*Block{fork1}), ErrForkTooShort)
+
+	fork2 := NewSignedBlockExample(validator, []*Transaction{}, 2, BlockHash{}.Hash(fork1.Header))
+	assert.Nil(t, blockchain.SwitchFork([]*Block{fork1, fork2}))
+
+	assert.Equal(t, uint32(2), blockchain.GetBlockchainHeight())
+	header, _ := blockchain.GetHeaderByHeight(1)
+	assert.Equal(t, fork1.Header, header)
+
+	assert.Len(t, events, 2)
+	assert.Equal(t, []*Block{block1}, events[1].Detached)
+	assert.Equal(t, []*Block{fork1, fork2}, events[1].Added)
+}
+
+func TestBlockchain_LookupByHash(t *testing.T) {
+	blockchain := newBlockchainWithGenesisExample()
+	validator, _ := GeneratePrivateKey()
+
+	tx := NewTxWithSignature([]byte("main"))
+	block1 := NewSignedBlockExample(validator, []*Transaction{}, 1, getPrevBlockHash(t, blockchain, 1))
+	assert.Nil(t, blockchain.AddBlock(block1))
+
+	b, err := blockchain.GetBlockByHash(block1.Hash(BlockHash{}))
+	assert.NoError(t, err)
+	assert.Equal(t, block1, b)
+
+	genesis, _ := blockchain.GetBlockByHeight(0)
+	found, including, err := blockchain.GetTransaction(genesis.Transactions[0].Hash(TxHash{}))
+	assert.NoError(t, err)
+	assert.Equal(t, genesis.Transactions[0], found)
+	assert.Equal(t, genesis, including)
+
+	_, _, err = blockchain.GetTransaction(tx.Hash(TxHash{}))
+	assert.ErrorIs(t, err, ErrUnknownTx)
+
+	// blocks detached by a reorg are forgotten, those of the branch are found
+	fork1 := NewSignedBlockExample(validator, []*Transaction{tx}, 1, genesis.Hash(BlockHash{}))
+	fork2 := NewSignedBlockExample(validator, []*Transaction{}, 2, fork1.Hash(BlockHash{}))
+	assert.Nil(t, blockchain.SwitchFork([]*Block{fork1, fork2}))
+
+	_, err = blockchain.GetBlockByHash(block1.Hash(BlockHash{}))
+	assert.ErrorIs(t, err, ErrUnknownBlock)
+	b, err = blockchain.GetBlockByHash(fork2.Hash(BlockHash{}))
+	assert.NoError(t, err)
+	assert.Equal(t, fork2, b)
+	_, including, err = blockchain.GetTransaction(tx.Hash(TxHash{}))
+	assert.NoError(t, err)
+	assert.Equal(t, fork1, including)
+}
+
+func TestBlockchain_CreditsFeesToValidator(t *testing.T) {
+	sender, _ := GeneratePrivateKey()
+	validator, _ := GeneratePrivateKey()
+	senderAddr := sender.PublicKey().Address()
+
+	config := DefaultChainConfig()
+	config.Alloc = map[string]uint64{senderAddr.String(): 100}
+	genesis := NewSignedBlockExample(validator, []*Transaction{}, 0, Hash{})
+	blockchain := NewBlockchainWithConfig(logrus.New(), config, genesis)
+
+	tx := NewTransaction(sender.PublicKey(), sender.PublicKey(), []byte("fee only"))
+	tx.Fee = 7
+	tx.Sign(sender)
+	assert.Nil(t, blockchain.AddBlock(NewSignedBlockExample(validator, []*Transaction{tx}, 1, getPrevBlockHash(t, blockchain, 1))))
+
+	assert.Equal(t, Account{Balance: 93, Nonce: 1}, blockchain.Account(sender.PublicKey()))
+	assert.Equal(t, Account{Balance: 7}, blockchain.Account(validator.PublicKey()))
+}
+
+func TestAddBlock_RejectsExpiredTransaction(t *testing.T) {
+	blockchain := newBlockchainWithGenesisExample()
+	validator, _ := GeneratePrivateKey()
+
+	sender, _ := GeneratePrivateKey()
+	tx := NewTransaction(sender.PublicKey(), sender.PublicKey(), []byte("short lived"))
+	tx.Expiry = 1
+	tx.Sign(sender)
+
+	block1 := NewSignedBlockExample(validator, []*Transaction{}, 1, getPrevBlockHash(t, blockchain, 1))
+	assert.Nil(t, blockchain.AddBlock(block1))
+
+	// block 2 is past the expiry height of the transaction
+	block2 := NewSignedBlockExample(validator, []*Transaction{tx}, 2, getPrevBlockHash(t, blockchain, 2))
+	err := blockchain.AddBlock(block2)
+	assert.ErrorIs(t, err, ErrTxExpired)
+
+	// the builder leaves it out
+	b := BuildBlock(DefaultParams(), &Header{Height: 2}, []*Transaction{tx})
+	assert.Empty(t, b.Transactions)
+}
+
+func TestAddBlock_RejectsTransactionForOtherChain(t *testing.T) {
+	validator, _ := GeneratePrivateKey()
+	genesis := NewSignedBlockExample(validator, []*Transaction{}, 0, Hash{})
+
+	config := DefaultChainConfig()
+	config.ChainID = 7
+	blockchain := NewBlockchainWithConfig(logrus.New(), config, genesis)
+
+	// transactions default to chain id 0
+	tx := NewTxWithSignature([]byte("replayed"))
+	err := blockchain.AddBlock(NewSignedBlockExample(validator, []*Transaction{tx}, 1, getPrevBlockHash(t, blockchain, 1)))
+	assert.ErrorIs(t, err, ErrTxWrongChain)
+}
+
+func TestBlockchain_PublishesEvents(t *testing.T) {
+	blockchain := newBlockchainWithGenesisExample()
+	validator, _ := GeneratePrivateKey()
+	sub := events.Subscribe[any](blockchain.Events(), 10)
+
+	block1 := NewSignedBlockExample(validator, []*Transaction{}, 1, getPrevBlockHash(t, blockchain, 1))
+	assert.Nil(t, blockchain.AddBlock(block1))
+	assert.Equal(t, BlockAdded{Block: block1}, <-sub.Events())
+
+	tx := NewTxWithSignature([]byte("fork"))
+	fork1 := NewSignedBlockExample(validator, []*Transaction{tx}, 1, getPrevBlockHash(t, blockchain, 1))
+	fork2 := NewSignedBlockExample(validator, []*Transaction{}, 2, BlockHash{}.Hash(fork1.Header))
+	assert.Nil(t, blockchain.SwitchFork([]*Block{fork1, fork2}))
+
+	// the reorg comes first, then every block followed by its transactions
+	assert.Equal(t, ChainReorg{Detached: []*Block{block1}, Added: []*Block{fork1, fork2}}, <-sub.Events())
+	assert.Equal(t, BlockAdded{Block: fork1}, <-sub.Events())
+	assert.Equal(t, TxIncluded{Tx: tx, Block: fork1}, <-sub.Events())
+	assert.Equal(t, BlockAdded{Block: fork2}, <-sub.Events())
+	assert.Empty(t, sub.Events())
+}
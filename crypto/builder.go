@@ -0,0 +1,41 @@
+package crypto
+
+// BuildBlock assembles an unsigned block on top of the given header from
+// candidate transactions, taken in order, while staying within params.
+// A transaction that does not fit or has expired is skipped together with
+// every later transaction from the same sender so that their order is preserved
+func BuildBlock(params Params, h *Header, candidates []*Transaction) *Block {
+	return NewBlock(h, SelectTransactions(params, h.Height, candidates))
+}
+
+// SelectTransactions returns the prefix-preserving subset of candidates
+// that fits in a single block at the given height under params
+func SelectTransactions(params Params, height uint32, candidates []*Transaction) []*Transaction {
+	var (
+		selected = []*Transaction{}
+		size     = headerSize + PubKeyLen + SignatureLen
+		skipped  = map[string]bool{}
+	)
+
+	for _, tx := range candidates {
+		if len(selected) >= params.MaxTxsPerBlock {
+			break
+		}
+
+		sender := tx.SenderKey()
+		if skipped[sender] {
+			continue
+		}
+
+		txSize := tx.Size()
+		if tx.Expired(height) || txSize > params.MaxTxSize || size+txSize > params.MaxBlockSize {
+			skipped[sender] = true
+			continue
+		}
+
+		selected = append(selected, tx)
+		size += txSize
+	}
+
+	return selected
+}
@@ -0,0 +1,61 @@
+package crypto
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildBlock_RespectsTxCountLimit(t *testing.T) {
+	var txs []*Transaction
+	for i := 0; i < 5; i++ {
+		txs = append(txs, NewTxWithSignature([]byte("Hello, World"+strconv.Itoa(i))))
+	}
+
+	params := DefaultParams()
+	params.MaxTxsPerBlock = 3
+
+	b := BuildBlock(params, &Header{Version: 1}, txs)
+	assert.Equal(t, txs[:3], b.Transactions)
+	assert.Equal(t, ComputeMerkleRoot(txs[:3]), b.MerkleRoot)
+}
+
+func TestBuildBlock_RespectsSizeLimits(t *testing.T) {
+	small := NewTxWithSignature([]byte("small"))
+	large := NewTxWithSignature(make([]byte, 512))
+
+	params := DefaultParams()
+	params.MaxTxSize = 256
+
+	// the large transaction is skipped but later ones are still considered
+	b := BuildBlock(params, &Header{Version: 1}, []*Transaction{large, small})
+	assert.Equal(t, []*Transaction{small}, b.Transactions)
+
+	// only room for a single small transaction
+	params = DefaultParams()
+	params.MaxBlockSize = headerSize + PubKeyLen + SignatureLen + small.Size()
+	other := NewTxWithSignature([]byte("other"))
+	b = BuildBlock(params, &Header{Version: 1}, []*Transaction{small, other})
+	assert.Equal(t, []*Transaction{small}, b.Transactions)
+	assert.LessOrEqual(t, b.Size(), params.MaxBlockSize)
+}
+
+func TestBuildBlock_SkipsRestOfSender(t *testing.T) {
+	sender, _ := GeneratePrivateKey()
+	receiver, _ := GeneratePrivateKey()
+
+	first := NewTransaction(sender.PublicKey(), receiver.PublicKey(), make([]byte, 512))
+	first.Sign(sender)
+	second := NewTransaction(sender.PublicKey(), receiver.PublicKey(), []byte("second"))
+	second.Sign(sender)
+	unrelated := NewTxWithSignature([]byte("unrelated"))
+
+	params := DefaultParams()
+	params.MaxTxSize = 256
+
+	// once the first transaction of a sender is dropped
+	// its later transactions must not jump ahead of it
+	b := BuildBlock(params, &Header{Version: 1}, []*Transaction{first, second, unrelated})
+	assert.Equal(t, []*Transaction{unrelated}, b.Transactions)
+}
@@ -0,0 +1,249 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// The canonical encoding of headers, transactions and blocks is used on
+// the wire and for hashing. Every value has exactly one encoding:
+// integers are big endian, variable length fields are prefixed with
+// their length as a uint32 and the decoders reject trailing bytes and
+// keys or signatures of the wrong length.
+
+// MarshalBinary returns the canonical encoding of the header
+func (h *Header) MarshalBinary() ([]byte, error) {
+	return h.ToBytes(), nil
+}
+
+// UnmarshalBinary decodes a header produced by MarshalBinary
+func (h *Header) UnmarshalBinary(b []byte) error {
+	d := &decoder{b: b}
+	h.decode(d)
+
+	return d.finish("header")
+}
+
+func (h *Header) encode(buf *bytes.Buffer) {
+	binary.Write(buf, binary.BigEndian, h.Version)
+	buf.Write(h.PrevBlockHash[:])
+	buf.Write(h.MerkleRoot[:])
+	binary.Write(buf, binary.BigEndian, h.Timestamp)
+	binary.Write(buf, binary.BigEndian, h.Height)
+}
+
+func (h *Header) decode(d *decoder) {
+	h.Version = d.uint32()
+	h.PrevBlockHash = d.hash()
+	h.MerkleRoot = d.hash()
+	h.Timestamp = int64(d.uint64())
+	h.Height = d.uint32()
+}
+
+// MarshalBinary returns the canonical encoding of the transaction:
+// its signed fields followed by the signature
+func (tx *Transaction) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBuffer(tx.signingBytes())
+	writeBytes(buf, signatureBytes(tx.Signature))
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a transaction produced by MarshalBinary
+func (tx *Transaction) UnmarshalBinary(b []byte) error {
+	d := &decoder{b: b}
+	tx.decode(d)
+
+	return d.finish("transaction")
+}
+
+func (tx *Transaction) decode(d *decoder) {
+	tx.Data = d.bytes()
+	tx.From = d.publicKey()
+	tx.Receiver = d.publicKey()
+	tx.Value = d.uint64()
+	tx.Nonce = d.uint64()
+	tx.Fee = d.uint64()
+	tx.Expiry = d.uint32()
+	tx.ChainID = d.uint32()
+	tx.Signature = d.signature()
+}
+
+// MarshalBinary returns the canonical encoding of the block: its header,
+// validator key and signature followed by the transaction count and the
+// length prefixed transactions
+func (b *Block) MarshalBinary() ([]byte, error) {
+	if b.Header == nil {
+		return nil, fmt.Errorf("encode block: missing header")
+	}
+
+	buf := &bytes.Buffer{}
+	b.Header.encode(buf)
+	writeBytes(buf, publicKeyBytes(b.Validator))
+	writeBytes(buf, signatureBytes(b.Signature))
+	binary.Write(buf, binary.BigEndian, uint32(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		enc, err := tx.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		writeBytes(buf, enc)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a block produced by MarshalBinary
+func (b *Block) UnmarshalBinary(data []byte) error {
+	d := &decoder{b: data}
+
+	b.Header = &Header{}
+	b.Header.decode(d)
+	b.Validator = d.publicKey()
+	b.Signature = d.signature()
+
+	// every transaction takes at least its length prefix
+	count := d.count(4)
+	b.Transactions = nil
+	if count > 0 {
+		b.Transactions = make([]*Transaction, 0, count)
+	}
+	for i := 0; i < count && d.err == nil; i++ {
+		tx := &Transaction{}
+		if err := tx.UnmarshalBinary(d.bytes()); err != nil && d.err == nil {
+			d.err = err
+		}
+		b.Transactions = append(b.Transactions, tx)
+	}
+
+	return d.finish("block")
+}
+
+// signatureBytes returns the value of s or nil if s is not set
+func signatureBytes(s *Signature) []byte {
+	if s == nil {
+		return nil
+	}
+
+	return s.Value
+}
+
+// decoder reads canonically encoded values from b. The first error
+// is kept and every later read returns zero values
+type decoder struct {
+	b   []byte
+	err error
+}
+
+// next consumes n bytes, or returns nil if fewer are left
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.b) {
+		d.fail("unexpected end of input")
+		return nil
+	}
+
+	v := d.b[:n]
+	d.b = d.b[n:]
+
+	return v
+}
+
+func (d *decoder) uint32() uint32 {
+	if v := d.next(4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if v := d.next(8); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+
+	return 0
+}
+
+func (d *decoder) hash() Hash {
+	var h Hash
+	copy(h[:], d.next(hashLen))
+
+	return h
+}
+
+// bytes reads a length prefixed byte slice. Empty slices decode as nil
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	if n == 0 || d.err != nil {
+		return nil
+	}
+	if uint64(n) > uint64(len(d.b)) {
+		d.fail("length %d exceeds input", n)
+		return nil
+	}
+
+	return bytes.Clone(d.next(int(n)))
+}
+
+// count reads an element count, rejecting counts that could not fit in
+// the remaining input given the minimum encoded size of an element
+func (d *decoder) count(minSize int) int {
+	n := d.uint32()
+	if d.err == nil && uint64(n)*uint64(minSize) > uint64(len(d.b)) {
+		d.fail("count %d exceeds input", n)
+		return 0
+	}
+
+	return int(n)
+}
+
+// publicKey reads an optional public key
+func (d *decoder) publicKey() *PublicKey {
+	b := d.bytes()
+	switch {
+	case len(b) == 0:
+		return nil
+	case len(b) != PubKeyLen:
+		d.fail("public key of length %d", len(b))
+		return nil
+	}
+
+	return &PublicKey{Key: b}
+}
+
+// signature reads an optional signature
+func (d *decoder) signature() *Signature {
+	b := d.bytes()
+	switch {
+	case len(b) == 0:
+		return nil
+	case len(b) != SignatureLen:
+		d.fail("signature of length %d", len(b))
+		return nil
+	}
+
+	return &Signature{Value: b}
+}
+
+func (d *decoder) fail(format string, args ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: "+format, append([]any{ErrInvalidEncoding}, args...)...)
+	}
+}
+
+// finish returns the first decoding error of what, or an error if
+// input is left over
+func (d *decoder) finish(what string) error {
+	if len(d.b) > 0 {
+		d.fail("%d trailing bytes", len(d.b))
+	}
+	if d.err != nil {
+		return fmt.Errorf("decode %s: %w", what, d.err)
+	}
+
+	return nil
+}
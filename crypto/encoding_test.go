@@ -0,0 +1,136 @@
+package crypto
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransaction_EncodingRoundTrip(t *testing.T) {
+	sender, _ := GeneratePrivateKey()
+	receiver, _ := GeneratePrivateKey()
+	tx := NewTransaction(sender.PublicKey(), receiver.PublicKey(), []byte("Hello, World"))
+	tx.Value, tx.Nonce, tx.Fee, tx.Expiry, tx.ChainID = 10, 2, 3, 40, 7
+	tx.Sign(sender)
+
+	enc, err := tx.MarshalBinary()
+	assert.NoError(t, err)
+
+	decoded := &Transaction{}
+	assert.NoError(t, decoded.UnmarshalBinary(enc))
+	assert.Equal(t, tx.Hash(TxHash{}), decoded.Hash(TxHash{}))
+	assert.Equal(t, tx.Signature, decoded.Signature)
+	assert.NoError(t, decoded.Verify())
+
+	// unset keys and signatures survive the round trip
+	unsigned := &Transaction{Value: 1}
+	enc, err = unsigned.MarshalBinary()
+	assert.NoError(t, err)
+	decoded = &Transaction{}
+	assert.NoError(t, decoded.UnmarshalBinary(enc))
+	assert.Equal(t, unsigned, decoded)
+}
+
+func TestBlock_EncodingRoundTrip(t *testing.T) {
+	validator, _ := GeneratePrivateKey()
+	var txs []*Transaction
+	for i := 0; i < 3; i++ {
+		txs = append(txs, NewTxWithSignature([]byte("Hello, World"+strconv.Itoa(i))))
+	}
+	b := NewSignedBlockExample(validator, txs, 4, Hash{1})
+
+	enc, err := b.MarshalBinary()
+	assert.NoError(t, err)
+
+	decoded := &Block{}
+	assert.NoError(t, decoded.UnmarshalBinary(enc))
+	assert.Equal(t, b.Hash(BlockHash{}), decoded.Hash(BlockHash{}))
+	assert.Equal(t, b.Size(), decoded.Size())
+	assert.Len(t, decoded.Transactions, 3)
+	assert.NoError(t, decoded.Verify())
+
+	header := &Header{}
+	assert.NoError(t, header.UnmarshalBinary(b.Header.ToBytes()))
+	assert.Equal(t, b.Header, header)
+	assert.Len(t, b.Header.ToBytes(), headerSize)
+}
+
+func TestDecoding_RejectsNonCanonicalInput(t *testing.T) {
+	enc, err := NewTxWithSignature([]byte("Hello, World")).MarshalBinary()
+	assert.NoError(t, err)
+
+	// trailing bytes
+	assert.ErrorIs(t, (&Transaction{}).UnmarshalBinary(append(enc, 0)), ErrInvalidEncoding)
+	// truncated input
+	assert.ErrorIs(t, (&Transaction{}).UnmarshalBinary(enc[:len(enc)-1]), ErrInvalidEncoding)
+
+	// a sender key of the wrong length
+	short := &Transaction{From: &PublicKey{Key: make([]byte, 31)}}
+	enc, err = short.MarshalBinary()
+	assert.NoError(t, err)
+	assert.ErrorIs(t, (&Transaction{}).UnmarshalBinary(enc), ErrInvalidEncoding)
+
+	// a transaction count larger than the input could hold
+	b := &Block{Header: &Header{Height: 1}}
+	enc, err = b.MarshalBinary()
+	assert.NoError(t, err)
+	enc[len(enc)-1] = 0xff
+	assert.ErrorIs(t, (&Block{}).UnmarshalBinary(enc), ErrInvalidEncoding)
+}
+
+func FuzzTransactionUnmarshal(f *testing.F) {
+	enc, _ := NewTxWithSignature([]byte("Hello, World")).MarshalBinary()
+	f.Add(enc)
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tx := &Transaction{}
+		if err := tx.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		// anything accepted must be in canonical form
+		enc, err := tx.MarshalBinary()
+		if err != nil || !bytes.Equal(enc, data) {
+			t.Fatalf("decoded transaction re-encodes differently")
+		}
+	})
+}
+
+func FuzzBlockUnmarshal(f *testing.F) {
+	validator, _ := GeneratePrivateKey()
+	b := NewSignedBlockExample(validator, []*Transaction{NewTxWithSignature([]byte("Hello, World"))}, 1, Hash{})
+	enc, _ := b.MarshalBinary()
+	f.Add(enc)
+	enc, _ = ExampleBlock(0, Hash{}).MarshalBinary()
+	f.Add(enc)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		b := &Block{}
+		if err := b.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		enc, err := b.MarshalBinary()
+		if err != nil || !bytes.Equal(enc, data) {
+			t.Fatalf("decoded block re-encodes differently")
+		}
+	})
+}
+
+func FuzzHeaderUnmarshal(f *testing.F) {
+	f.Add(ExampleBlock(3, Hash{2}).Header.ToBytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h := &Header{}
+		if err := h.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		if !bytes.Equal(h.ToBytes(), data) {
+			t.Fatalf("decoded header re-encodes differently")
+		}
+	})
+}
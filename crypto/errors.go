@@ -0,0 +1,78 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors returned when validating blocks and transactions.
+// Callers should match them with errors.Is since they are usually
+// wrapped in a BlockError or TxError carrying more context.
+var (
+	ErrBlockKnown            = errors.New("block already known")
+	ErrBlockConflict         = errors.New("block conflicts with the chain at its height")
+	ErrBlockTooHigh          = errors.New("block height is too high")
+	ErrUnknownBlock          = errors.New("unknown block")
+	ErrUnknownTx             = errors.New("unknown transaction")
+	ErrPrevHashMismatch      = errors.New("previous block hash mismatch")
+	ErrBlockNoSignature      = errors.New("block header has no signature")
+	ErrBlockInvalidSignature = errors.New("block header has invalid signature")
+	ErrMerkleRootMismatch    = errors.New("merkle root does not match")
+	ErrBlockTooLarge         = errors.New("block exceeds maximum size")
+	ErrBlockVersion          = errors.New("block version is below the one required at its height")
+	ErrTooManyTxs            = errors.New("block exceeds maximum transaction count")
+	ErrTxTooLarge            = errors.New("transaction exceeds maximum size")
+	ErrTxNoSignature         = errors.New("transaction has no signature")
+	ErrTxInvalidSignature    = errors.New("transaction has invalid signature")
+	ErrInvalidNonce          = errors.New("invalid transaction nonce")
+	ErrInsufficientBalance   = errors.New("insufficient balance")
+	ErrTxExpired             = errors.New("transaction expired")
+	ErrTxWrongChain          = errors.New("transaction is for another chain")
+	ErrForkTooShort          = errors.New("fork does not extend the chain")
+	ErrInvalidEncoding       = errors.New("invalid encoding")
+	ErrInvalidAddress        = errors.New("invalid address")
+)
+
+// BlockError is returned when a block fails validation.
+// It records the height and hash of the offending block
+type BlockError struct {
+	Height uint32 // height claimed by the block header
+	Hash   Hash   // hash of the block header
+	Err    error  // underlying reason, usually one of the sentinel errors or a *TxError
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("block %d (%s): %v", e.Height, e.Hash.ToString(), e.Err)
+}
+
+func (e *BlockError) Unwrap() error {
+	return e.Err
+}
+
+// TxError is returned when a transaction fails validation.
+// Index is the position of the transaction within its block
+// or -1 when the transaction was validated on its own
+type TxError struct {
+	Index int   // position of the transaction in the block
+	Hash  Hash  // hash of the transaction
+	Err   error // underlying reason
+}
+
+func (e *TxError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("transaction %s: %v", e.Hash.ToString(), e.Err)
+	}
+
+	return fmt.Sprintf("transaction %d (%s): %v", e.Index, e.Hash.ToString(), e.Err)
+}
+
+func (e *TxError) Unwrap() error {
+	return e.Err
+}
+
+// IsBenign reports whether err only signals that a block is already
+// known, as opposed to the block being invalid.
+// A peer relaying a known block should be ignored rather than penalised
+func IsBenign(err error) bool {
+	return errors.Is(err, ErrBlockKnown)
+}
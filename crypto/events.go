@@ -0,0 +1,23 @@
+package crypto
+
+// Events published on the event bus of the blockchain, see Blockchain.Events
+
+// BlockAdded is published for every block that becomes part of the chain,
+// including the blocks of a branch switched to
+type BlockAdded struct {
+	Block *Block
+}
+
+// ChainReorg is published when the chain switches to another branch,
+// before the BlockAdded events of the branch
+type ChainReorg struct {
+	Detached []*Block // blocks removed from the chain, in height order
+	Added    []*Block // blocks of the new branch, in height order
+}
+
+// TxIncluded is published for every transaction of an added block, after
+// the BlockAdded event of the block
+type TxIncluded struct {
+	Tx    *Transaction
+	Block *Block
+}
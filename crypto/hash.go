@@ -69,7 +69,7 @@ func (BlockHash) Hash(header *Header) Hash {
 // TxHash to implement the Hasher interface for transactions
 type TxHash struct{}
 
-// Hash hashes the Data property of a Transaction
+// Hash hashes every signed field of a Transaction
 func (TxHash) Hash(tx *Transaction) Hash {
-	return Hash(sha256.Sum256(tx.Data))
+	return Hash(sha256.Sum256(tx.signingBytes()))
 }
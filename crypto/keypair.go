@@ -1,16 +1,24 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"io"
 )
 
 const (
-	privKeyLen   = 64 // length of the private key
-	seedLen      = 32
-	pubKeyLen    = 32 // length of the public key
-	signatureLen = 64
+	privKeyLen = 64 // length of the private key
+	seedLen    = 32
+)
+
+const (
+	PubKeyLen    = 32 // length of a public key
+	SignatureLen = 64 // length of a signature
+	AddressLen   = 20 // length of an address derived from a public key
 )
 
 type PrivateKey struct {
@@ -42,9 +50,27 @@ func GeneratePrivateKey() (*PrivateKey, error) {
 	}, nil
 }
 
+// PrivateKeyFromBytes restores a private key from its 32 byte seed or from
+// the 64 bytes returned by ToBytes, in which case the embedded public key
+// must match the seed
+func PrivateKeyFromBytes(b []byte) (*PrivateKey, error) {
+	switch len(b) {
+	case seedLen:
+		return &PrivateKey{key: ed25519.NewKeyFromSeed(b)}, nil
+	case privKeyLen:
+		key := ed25519.NewKeyFromSeed(b[:seedLen])
+		if !bytes.Equal(key[seedLen:], b[seedLen:]) {
+			return nil, errors.New("private key does not match its public key")
+		}
+		return &PrivateKey{key: key}, nil
+	default:
+		return nil, fmt.Errorf("invalid private key length %d", len(b))
+	}
+}
+
 // PublicKey returns public key from the private key
 func (p *PrivateKey) PublicKey() *PublicKey {
-	b := make([]byte, pubKeyLen)
+	b := make([]byte, PubKeyLen)
 	copy(b, p.key[32:])
 
 	return &PublicKey{
@@ -60,6 +86,14 @@ func (p *PublicKey) ToBytes() []byte {
 	return p.Key
 }
 
+// Address returns the address derived from the public key:
+// the last 20 bytes of its sha256 hash
+func (p *PublicKey) Address() Address {
+	h := sha256.Sum256(p.Key)
+
+	return Address{value: h[len(h)-AddressLen:]}
+}
+
 type Signature struct {
 	Value []byte
 }
@@ -71,14 +105,19 @@ func (s *Signature) ToBytes() []byte {
 
 // BytesToSignature converts byte array to Signature type
 func BytesToSignature(b []byte) *Signature {
-	if len(b) != signatureLen {
+	if len(b) != SignatureLen {
 		panic("invalid signature length, must be 64")
 	}
 
 	return &Signature{Value: b}
 }
 
-// Verify checks if the signature is valid
+// Verify checks if the signature is valid.
+// Malformed keys never verify
 func (s *Signature) Verify(pubKey *PublicKey, msg []byte) bool {
+	if len(pubKey.Key) != PubKeyLen {
+		return false
+	}
+
 	return ed25519.Verify(pubKey.Key, msg, s.Value)
 }
@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrivateKeyFromBytes(t *testing.T) {
+	key, err := GeneratePrivateKey()
+	assert.NoError(t, err)
+
+	restored, err := PrivateKeyFromBytes(key.ToBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, key.PublicKey(), restored.PublicKey())
+
+	fromSeed, err := PrivateKeyFromBytes(key.ToBytes()[:32])
+	assert.NoError(t, err)
+	assert.Equal(t, key.ToBytes(), fromSeed.ToBytes())
+
+	// the public half must belong to the seed
+	other, _ := GeneratePrivateKey()
+	mixed := append(append([]byte{}, key.ToBytes()[:32]...), other.PublicKey().ToBytes()...)
+	_, err = PrivateKeyFromBytes(mixed)
+	assert.Error(t, err)
+
+	_, err = PrivateKeyFromBytes([]byte{1, 2, 3})
+	assert.Error(t, err)
+}
+
+func TestParseAddress(t *testing.T) {
+	key, _ := GeneratePrivateKey()
+	addr := key.PublicKey().Address()
+
+	for _, s := range []string{addr.String(), "0x" + addr.String(), strings.ToUpper(addr.String())} {
+		parsed, err := ParseAddress(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, addr.String(), parsed.String())
+	}
+	for _, s := range []string{"", "0x", "ab", addr.String() + "00", "zz" + addr.String()[2:]} {
+		_, err := ParseAddress(s)
+		assert.ErrorIs(t, err, ErrInvalidAddress, s)
+	}
+}
@@ -0,0 +1,90 @@
+package crypto
+
+// Params holds the protocol parameters that bound the contents of a block
+type Params struct {
+	MaxBlockSize   int // maximum size of a block in bytes, see Block.Size
+	MaxTxSize      int // maximum size of a single transaction in bytes, see Transaction.Size
+	MaxTxsPerBlock int // maximum number of transactions in a block
+}
+
+// Upgrade replaces the protocol parameters for every block from Height
+// onwards. Those blocks must also carry a header version of at least
+// Version, so that producers cannot opt out of the upgrade
+type Upgrade struct {
+	Height  uint32
+	Version uint32
+	Params  Params
+}
+
+// ChainConfig holds the protocol parameters agreed on at genesis
+// together with the upgrades that change them later on
+type ChainConfig struct {
+	ChainID  uint32            // identifies the chain; transactions must carry the same id
+	Params   Params            // parameters in effect from the genesis block
+	Upgrades []Upgrade         // parameter changes by activation height, in any order
+	Alloc    map[string]uint64 // genesis balances keyed by hex encoded address
+}
+
+// DefaultParams returns the parameters used when none are configured
+func DefaultParams() Params {
+	return Params{
+		MaxBlockSize:   1 << 20, // 1 MiB
+		MaxTxSize:      64 << 10,
+		MaxTxsPerBlock: 4096,
+	}
+}
+
+// NewChainConfig creates a config for the chain chainID starting with
+// params and changed by upgrades
+func NewChainConfig(chainID uint32, params Params, upgrades ...Upgrade) *ChainConfig {
+	return &ChainConfig{ChainID: chainID, Params: params, Upgrades: append([]Upgrade(nil), upgrades...)}
+}
+
+// DefaultChainConfig returns a config using DefaultParams with no upgrades
+func DefaultChainConfig() *ChainConfig {
+	return NewChainConfig(0, DefaultParams())
+}
+
+// ParamsAt returns the parameters in effect for the block at height.
+// The upgrade with the highest activation height at or below height wins;
+// if none applies the genesis params are used
+func (c *ChainConfig) ParamsAt(height uint32) Params {
+	if u := c.upgradeAt(height); u != nil {
+		return u.Params
+	}
+
+	return c.Params
+}
+
+// VersionAt returns the lowest header version accepted for the block at height
+func (c *ChainConfig) VersionAt(height uint32) uint32 {
+	if u := c.upgradeAt(height); u != nil {
+		return u.Version
+	}
+
+	return 0
+}
+
+// MaxTxsPerBlock returns the most transactions a block may hold at any height
+func (c *ChainConfig) MaxTxsPerBlock() int {
+	most := c.Params.MaxTxsPerBlock
+	for _, u := range c.Upgrades {
+		most = max(most, u.Params.MaxTxsPerBlock)
+	}
+
+	return most
+}
+
+// upgradeAt returns the upgrade with the highest activation height at or
+// below height, or nil. Of upgrades sharing a height the last listed wins
+func (c *ChainConfig) upgradeAt(height uint32) *Upgrade {
+	var active *Upgrade
+	for i := range c.Upgrades {
+		u := &c.Upgrades[i]
+		if u.Height <= height && (active == nil || u.Height >= active.Height) {
+			active = u
+		}
+	}
+
+	return active
+}
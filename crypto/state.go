@@ -0,0 +1,97 @@
+package crypto
+
+import "fmt"
+
+// Account holds the confirmed balance and nonce of an address
+type Account struct {
+	Balance uint64 // amount owned by the account
+	Nonce   uint64 // nonce expected in the next transaction sent by the account
+}
+
+// State maps addresses to their accounts. It is the result of applying
+// every transaction in the chain, in order, to the genesis allocation
+type State struct {
+	accounts map[string]Account // accounts keyed by hex encoded address
+}
+
+// NewState creates a state funding the given hex encoded addresses
+func NewState(alloc map[string]uint64) *State {
+	s := &State{accounts: make(map[string]Account, len(alloc))}
+	for addr, balance := range alloc {
+		s.accounts[addr] = Account{Balance: balance}
+	}
+
+	return s
+}
+
+// Account returns the account of the owner of the public key.
+// Unknown accounts are empty
+func (s *State) Account(pub *PublicKey) Account {
+	addr := pub.Address()
+	return s.accounts[addr.String()]
+}
+
+// Copy returns an independent copy of the state
+func (s *State) Copy() *State {
+	c := &State{accounts: make(map[string]Account, len(s.accounts))}
+	for addr, acc := range s.accounts {
+		c.accounts[addr] = acc
+	}
+
+	return c
+}
+
+// ApplyTx moves Value from the sender to the receiver, charges the sender
+// the fee and bumps the sender's nonce. The fee is burnt unless credited by
+// ApplyBlock. The state is left untouched if tx cannot be applied
+func (s *State) ApplyTx(tx *Transaction) error {
+	if tx.From == nil {
+		return ErrTxInvalidSignature
+	}
+
+	fromAddr := tx.From.Address()
+	from := s.accounts[fromAddr.String()]
+	if tx.Nonce != from.Nonce {
+		return fmt.Errorf("%w: expected %d, got %d", ErrInvalidNonce, from.Nonce, tx.Nonce)
+	}
+	if tx.Cost() > from.Balance {
+		return fmt.Errorf("%w: balance %d, cost %d", ErrInsufficientBalance, from.Balance, tx.Cost())
+	}
+
+	from.Balance -= tx.Cost()
+	from.Nonce++
+	s.accounts[fromAddr.String()] = from
+
+	// a transaction without receiver burns its value
+	if tx.Receiver != nil {
+		s.credit(tx.Receiver, tx.Value)
+	}
+
+	return nil
+}
+
+// credit adds amount to the balance of the owner of pub
+func (s *State) credit(pub *PublicKey, amount uint64) {
+	addr := pub.Address()
+	acc := s.accounts[addr.String()]
+	acc.Balance += amount
+	s.accounts[addr.String()] = acc
+}
+
+// ApplyBlock applies every transaction of b in order and credits
+// their fees to the validator of the block.
+// The first failure is returned as a *TxError, in which case the
+// transactions before it have already been applied; callers that
+// need to roll back should apply the block to a Copy
+func (s *State) ApplyBlock(b *Block) error {
+	for i, tx := range b.Transactions {
+		if err := s.ApplyTx(tx); err != nil {
+			return &TxError{Index: i, Hash: tx.Hash(TxHash{}), Err: err}
+		}
+		if b.Validator != nil {
+			s.credit(b.Validator, tx.Fee)
+		}
+	}
+
+	return nil
+}
@@ -1,7 +1,9 @@
 package crypto
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/binary"
+	"math"
 )
 
 // Transaction structure
@@ -9,6 +11,11 @@ type Transaction struct {
 	Data      []byte
 	From      *PublicKey // public key of the one sending value/initiating transaction
 	Receiver  *PublicKey // public key of the one receiving value
+	Value     uint64     // amount transferred from the sender to the receiver
+	Nonce     uint64     // number of transactions previously sent by the sender
+	Fee       uint64     // amount paid to the validator including the transaction
+	Expiry    uint32     // last block height the transaction may be included at; 0 never expires
+	ChainID   uint32     // chain the transaction is meant for; protects against replay on other chains
 	Signature *Signature // signature verifying transaction's authenticity
 }
 
@@ -22,7 +29,7 @@ func NewTransaction(from, receiver *PublicKey, data []byte) *Transaction {
 
 // Sign signs a transaction
 func (tx *Transaction) Sign(privKey *PrivateKey) {
-	sig := privKey.Sign(tx.Data)
+	sig := privKey.Sign(tx.signingBytes())
 
 	tx.Signature = sig
 }
@@ -32,16 +39,91 @@ func (tx *Transaction) Hash(hasher Hasher[*Transaction]) Hash {
 	return hasher.Hash(tx)
 }
 
+// Size returns the number of bytes the transaction occupies in a block.
+// Missing keys and signatures count as zero bytes
+func (tx *Transaction) Size() int {
+	size := len(tx.Data) + 8 + 8 + 8 + 4 + 4 // data, value, nonce, fee, expiry and chain id
+	if tx.From != nil {
+		size += len(tx.From.Key)
+	}
+	if tx.Receiver != nil {
+		size += len(tx.Receiver.Key)
+	}
+	if tx.Signature != nil {
+		size += len(tx.Signature.Value)
+	}
+
+	return size
+}
+
+// Cost returns the amount the sender pays for the transaction: its value
+// plus its fee. The sum saturates instead of overflowing
+func (tx *Transaction) Cost() uint64 {
+	cost := tx.Value + tx.Fee
+	if cost < tx.Value {
+		return math.MaxUint64
+	}
+
+	return cost
+}
+
+// SenderKey returns a map key identifying the sender of the transaction,
+// empty when it has none
+func (tx *Transaction) SenderKey() string {
+	if tx.From == nil {
+		return ""
+	}
+
+	return string(tx.From.Key)
+}
+
+// Expired reports whether the transaction can no longer be included
+// in a block at the given height
+func (tx *Transaction) Expired(height uint32) bool {
+	return tx.Expiry != 0 && height > tx.Expiry
+}
+
 // Verify checks the validity of the transaction signature
 func (tx *Transaction) Verify() error {
 	// transaction must be signed
 	if tx.Signature == nil {
-		return fmt.Errorf("transaction has no signature")
+		return &TxError{Index: -1, Hash: tx.Hash(TxHash{}), Err: ErrTxNoSignature}
 	}
 	// if signature is set, it must be valid and not tampered with
-	if !tx.Signature.Verify(tx.From, tx.Data) {
-		return fmt.Errorf("transaction has invalid signature")
+	if tx.From == nil || !tx.Signature.Verify(tx.From, tx.signingBytes()) {
+		return &TxError{Index: -1, Hash: tx.Hash(TxHash{}), Err: ErrTxInvalidSignature}
 	}
 
 	return nil
 }
+
+// signingBytes returns the deterministic encoding of every transaction
+// field covered by the signature, i.e. all of them except the signature
+func (tx *Transaction) signingBytes() []byte {
+	buf := &bytes.Buffer{}
+	writeBytes(buf, tx.Data)
+	writeBytes(buf, publicKeyBytes(tx.From))
+	writeBytes(buf, publicKeyBytes(tx.Receiver))
+	binary.Write(buf, binary.BigEndian, tx.Value)
+	binary.Write(buf, binary.BigEndian, tx.Nonce)
+	binary.Write(buf, binary.BigEndian, tx.Fee)
+	binary.Write(buf, binary.BigEndian, tx.Expiry)
+	binary.Write(buf, binary.BigEndian, tx.ChainID)
+
+	return buf.Bytes()
+}
+
+// writeBytes writes b to buf prefixed with its length
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(b)))
+	buf.Write(b)
+}
+
+// publicKeyBytes returns the key bytes of p or nil if p is not set
+func publicKeyBytes(p *PublicKey) []byte {
+	if p == nil {
+		return nil
+	}
+
+	return p.Key
+}
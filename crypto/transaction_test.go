@@ -32,7 +32,7 @@ func TestSignTransaction(t *testing.T) {
 
 	//	Sign transaction
 	tx.Sign(privKeyFrom)
-	assert.True(t, tx.Signature.Verify(pubKeyFrom, data))
+	assert.True(t, tx.Signature.Verify(pubKeyFrom, tx.signingBytes()))
 }
 
 func TestVerifyTransaction(t *testing.T) {
@@ -58,5 +58,28 @@ func TestVerifyTransaction(t *testing.T) {
 	tx.Sign(privKeyReceiver)
 
 	// verification should fail with the error: transaction has invalid signature
-	assert.Error(t, tx.Verify())
+	err = tx.Verify()
+	assert.ErrorIs(t, err, ErrTxInvalidSignature)
+
+	var txErr *TxError
+	assert.ErrorAs(t, err, &txErr)
+	assert.Equal(t, -1, txErr.Index)
+}
+
+func TestVerifyTransaction_SignatureCoversAllFields(t *testing.T) {
+	privKeyFrom, err := GeneratePrivateKey()
+	assert.NoError(t, err)
+	privKeyReceiver, err := GeneratePrivateKey()
+	assert.NoError(t, err)
+
+	tx := NewTransaction(privKeyFrom.PublicKey(), privKeyReceiver.PublicKey(), []byte("Hello, World"))
+	tx.Value = 10
+	tx.Sign(privKeyFrom)
+	assert.NoError(t, tx.Verify())
+	hash := tx.Hash(TxHash{})
+
+	// tampering with the value invalidates the signature and changes the hash
+	tx.Value = 1000
+	assert.ErrorIs(t, tx.Verify(), ErrTxInvalidSignature)
+	assert.NotEqual(t, hash, tx.Hash(TxHash{}))
 }
@@ -17,34 +17,58 @@ func NewBlockValidator(bc *Blockchain) *BlockValidator {
 }
 
 // ValidateBlock validates a new block before being added to the blockchain
-// to ensure correctness
+// to ensure correctness. Failures are returned as a *BlockError wrapping
+// one of the sentinel errors so callers can tell them apart with errors.Is
 func (v *BlockValidator) ValidateBlock(b *Block) error {
-	// no error means that we are attempting to add a block at a height in the blockchain
-	// already occupied by an existing block
-	if _, err := v.bc.GetHeaderByHeight(b.Header.Height); err == nil {
-		return fmt.Errorf("the blockchain already contains block of height: %d with hash %s", b.Header.Height, b.headerHash)
+	// the height is already occupied by an existing block: either b is that
+	// block, or it conflicts with the chain
+	if v.bc.HasBlock(b.Header.Height) {
+		header, err := v.bc.GetHeaderByHeight(b.Header.Height)
+		if err != nil {
+			return b.error(err)
+		}
+		if (BlockHash{}).Hash(header) == b.Hash(BlockHash{}) {
+			return b.error(ErrBlockKnown)
+		}
+		// forged blocks are reported as invalid rather than conflicting
+		if err := b.Verify(); err != nil {
+			return err
+		}
+		return b.error(ErrBlockConflict)
 	}
 
 	// the new block to be added must occupy the next available slot i.e. blockchain height + 1
 	// if the block height is greater than that, return error
 	if b.Header.Height > v.bc.GetBlockchainHeight()+1 {
-		return fmt.Errorf("the height of block with hash (%s) is too high", b.Hash(BlockHash{}))
+		return b.error(ErrBlockTooHigh)
 	}
 
 	// get header of the previous block in the blockchain
 	prevHeader, err := v.bc.GetHeaderByHeight(b.Header.Height - 1)
 	if err != nil {
-		return err
+		return b.error(err)
 	}
 
+	return validateChild(v.bc.Config(), b, prevHeader, v.bc.stateCopy())
+}
+
+// validateChild checks that b correctly extends the block with header parent.
+// state must be the state after parent and is updated with the
+// transactions of b on success
+func validateChild(config *ChainConfig, b *Block, parent *Header, state *State) error {
 	// recalculate hash of previous block header
-	prevHash := BlockHash{}.Hash(prevHeader)
+	prevHash := BlockHash{}.Hash(parent)
 
 	// the recalculated previous hash must match the PrevBlockHash of the new block
 	// being added to the chain, otherwise treated as a fraudulent case
 	// raise an error
 	if prevHash != b.Header.PrevBlockHash {
-		return fmt.Errorf("hash mismatch. expected previous hash: %s actual hash: %s", prevHeader.PrevBlockHash, prevHash)
+		return b.error(fmt.Errorf("%w: expected %s, got %s", ErrPrevHashMismatch, prevHash.ToString(), b.Header.PrevBlockHash.ToString()))
+	}
+
+	// the block must follow the protocol parameters in effect at its height
+	if err := validateLimits(config, b); err != nil {
+		return err
 	}
 
 	// verify the new block
@@ -52,5 +76,60 @@ func (v *BlockValidator) ValidateBlock(b *Block) error {
 		return err
 	}
 
+	for i, tx := range b.Transactions {
+		// transactions signed for another chain must not be replayed here
+		if tx.ChainID != config.ChainID {
+			return b.error(&TxError{
+				Index: i,
+				Hash:  tx.Hash(TxHash{}),
+				Err:   fmt.Errorf("%w: expected %d, got %d", ErrTxWrongChain, config.ChainID, tx.ChainID),
+			})
+		}
+
+		// no transaction may be included past its expiry height
+		if tx.Expired(b.Header.Height) {
+			return b.error(&TxError{
+				Index: i,
+				Hash:  tx.Hash(TxHash{}),
+				Err:   fmt.Errorf("%w: expiry %d, block height %d", ErrTxExpired, tx.Expiry, b.Header.Height),
+			})
+		}
+	}
+
+	// every transaction must be applicable to the state in order
+	if err := state.ApplyBlock(b); err != nil {
+		return b.error(err)
+	}
+
+	return nil
+}
+
+// validateLimits checks the block against the header version, size and
+// transaction count limits in effect at its height
+func validateLimits(config *ChainConfig, b *Block) error {
+	if required := config.VersionAt(b.Header.Height); b.Header.Version < required {
+		return b.error(fmt.Errorf("%w: %d < %d", ErrBlockVersion, b.Header.Version, required))
+	}
+
+	params := config.ParamsAt(b.Header.Height)
+
+	if len(b.Transactions) > params.MaxTxsPerBlock {
+		return b.error(fmt.Errorf("%w: %d > %d", ErrTooManyTxs, len(b.Transactions), params.MaxTxsPerBlock))
+	}
+
+	for i, tx := range b.Transactions {
+		if size := tx.Size(); size > params.MaxTxSize {
+			return b.error(&TxError{
+				Index: i,
+				Hash:  tx.Hash(TxHash{}),
+				Err:   fmt.Errorf("%w: %d > %d", ErrTxTooLarge, size, params.MaxTxSize),
+			})
+		}
+	}
+
+	if size := b.Size(); size > params.MaxBlockSize {
+		return b.error(fmt.Errorf("%w: %d > %d", ErrBlockTooLarge, size, params.MaxBlockSize))
+	}
+
 	return nil
 }
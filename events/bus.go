@@ -0,0 +1,138 @@
+package events
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultBuffer = 256
+
+// BusOpts configures a Bus
+type BusOpts struct {
+	Buffer int // size of the buffer of subscriptions asking for none; defaults to 256
+	Logger *logrus.Logger
+}
+
+// Bus is an in-process publish/subscribe event bus. Events are plain
+// values routed by type: a subscription receives every published event
+// assignable to its type, so subscribing to an interface, or to any,
+// gathers several kinds of events in the order they were published.
+// Publishing never blocks; each subscription has its own buffer and
+// events that do not fit are dropped for that subscription only
+type Bus struct {
+	BusOpts
+	lock   sync.RWMutex
+	subs   map[uint64]subscriber
+	lastID uint64
+}
+
+// subscriber is the untyped side of a Subscription, seen by the bus
+type subscriber interface {
+	accepts(t reflect.Type) bool
+	deliver(ev any) bool
+}
+
+func NewBus(opts BusOpts) *Bus {
+	if opts.Buffer == 0 {
+		opts.Buffer = defaultBuffer
+	}
+	if opts.Logger == nil {
+		opts.Logger = logrus.New()
+	}
+
+	return &Bus{
+		BusOpts: opts,
+		subs:    make(map[uint64]subscriber),
+	}
+}
+
+// Publish hands ev to every subscription accepting its type. It does
+// nothing on a nil bus
+func (b *Bus) Publish(ev any) {
+	if b == nil || ev == nil {
+		return
+	}
+
+	t := reflect.TypeOf(ev)
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	for _, sub := range b.subs {
+		if sub.accepts(t) && !sub.deliver(ev) {
+			b.Logger.WithField("event", t).Debug("dropped event for a subscriber falling behind")
+		}
+	}
+}
+
+// Subscription receives the events of type T published on a bus
+type Subscription[T any] struct {
+	bus     *Bus
+	id      uint64
+	typ     reflect.Type
+	ch      chan T
+	dropped atomic.Uint64
+	once    sync.Once
+}
+
+// Subscribe returns a subscription to the events of type T buffering up
+// to buffer of them, or the bus default when buffer is 0
+func Subscribe[T any](b *Bus, buffer int) *Subscription[T] {
+	if buffer == 0 {
+		buffer = b.Buffer
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.lastID++
+	s := &Subscription[T]{
+		bus: b,
+		id:  b.lastID,
+		typ: reflect.TypeOf((*T)(nil)).Elem(),
+		ch:  make(chan T, buffer),
+	}
+	b.subs[s.id] = s
+
+	return s
+}
+
+// Events returns the channel the events are received on. It is closed by
+// Unsubscribe
+func (s *Subscription[T]) Events() <-chan T {
+	return s.ch
+}
+
+// Dropped returns the number of events lost because the buffer was full
+func (s *Subscription[T]) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Unsubscribe stops the delivery of events and closes the channel; the
+// events already buffered can still be read from it. It may be called
+// more than once
+func (s *Subscription[T]) Unsubscribe() {
+	s.bus.lock.Lock()
+	defer s.bus.lock.Unlock()
+
+	delete(s.bus.subs, s.id)
+	s.once.Do(func() { close(s.ch) })
+}
+
+func (s *Subscription[T]) accepts(t reflect.Type) bool {
+	return t.AssignableTo(s.typ)
+}
+
+// deliver queues ev unless the buffer is full. The bus lock is held, so
+// the channel cannot be closed meanwhile
+func (s *Subscription[T]) deliver(ev any) bool {
+	select {
+	case s.ch <- ev.(T):
+		return true
+	default:
+		s.dropped.Add(1)
+		return false
+	}
+}
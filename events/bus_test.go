@@ -0,0 +1,77 @@
+package events
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type started struct{ id int }
+type stopped struct{ id int }
+
+// helper function reading the events buffered by sub
+func drain[T any](sub *Subscription[T]) []T {
+	var got []T
+	for {
+		select {
+		case ev := <-sub.Events():
+			got = append(got, ev)
+		default:
+			return got
+		}
+	}
+}
+
+func TestBus_RoutesByType(t *testing.T) {
+	b := NewBus(BusOpts{})
+	starts := Subscribe[started](b, 10)
+	all := Subscribe[any](b, 10)
+	stringers := Subscribe[fmt.Stringer](b, 10)
+
+	b.Publish(started{1})
+	b.Publish(stopped{1})
+	b.Publish(started{2})
+
+	assert.Equal(t, []started{{1}, {2}}, drain(starts))
+	// subscriptions to interfaces get every event implementing them, in order
+	assert.Equal(t, []any{started{1}, stopped{1}, started{2}}, drain(all))
+	assert.Empty(t, drain(stringers))
+}
+
+func TestBus_DropsWhenBufferFull(t *testing.T) {
+	b := NewBus(BusOpts{Buffer: 2})
+	slow := Subscribe[started](b, 0)
+	fast := Subscribe[started](b, 10)
+
+	// publishing never blocks on the slow subscriber
+	for i := 0; i < 5; i++ {
+		b.Publish(started{i})
+	}
+
+	assert.Equal(t, []started{{0}, {1}}, drain(slow))
+	assert.Equal(t, uint64(3), slow.Dropped())
+	assert.Len(t, drain(fast), 5)
+	assert.Equal(t, uint64(0), fast.Dropped())
+}
+
+func TestBus_Unsubscribe(t *testing.T) {
+	b := NewBus(BusOpts{})
+	sub := Subscribe[started](b, 10)
+
+	b.Publish(started{1})
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	b.Publish(started{2})
+
+	// buffered events are still read, then the channel is closed
+	ev, ok := <-sub.Events()
+	assert.True(t, ok)
+	assert.Equal(t, started{1}, ev)
+	_, ok = <-sub.Events()
+	assert.False(t, ok)
+
+	// a nil bus ignores events
+	var none *Bus
+	none.Publish(started{3})
+}
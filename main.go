@@ -1,7 +1,170 @@
 package main
 
-import "fmt"
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/majorshift/safari-chain/config"
+)
+
+// set at build time, see the Makefile
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
+const usage = `usage: safarichain <command> [flags]
+
+commands:
+  init      create a data directory with keys, a genesis block and a config
+  start     run a node
+  keys      manage the keys of the data directory: generate, import, export, list
+  status    show the chain status of a running node
+  version   print the version
+
+Run "safarichain <command> -h" for the flags of a command.
+Settings are read from <datadir>/config.yaml, then overridden by the
+SAFARI_* environment variables (e.g. SAFARI_LISTEN_ADDR) and the flags.
+`
+
+// command runs a subcommand with its arguments
+type command func(args []string) error
 
 func main() {
-	fmt.Println("Hello World")
+	commands := map[string]command{
+		"init":    runInit,
+		"start":   runStart,
+		"keys":    runKeys,
+		"status":  runStatus,
+		"version": runVersion,
+	}
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "--help" {
+		fmt.Print(usage)
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "safarichain: unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
+
+	if err := cmd(os.Args[2:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "safarichain %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+func runVersion(args []string) error {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	fmt.Printf("safarichain %s (commit %s, %s %s/%s)\n", version, commit, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return nil
+}
+
+// dataDirFlags are the flags locating the data directory and config
+// file, shared by the commands working on a data directory
+type dataDirFlags struct {
+	dataDir string
+	config  string
+}
+
+func (f *dataDirFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.dataDir, "datadir", "", "data directory (default $SAFARI_DATA_DIR or "+config.DefaultDataDir()+")")
+	fs.StringVar(&f.config, "config", "", "config file (default <datadir>/"+config.ConfigFile+")")
+}
+
+// resolve returns the data directory and config file to use
+func (f *dataDirFlags) resolve() (dataDir, path string) {
+	dataDir = f.dataDir
+	if dataDir == "" {
+		dataDir = os.Getenv(config.EnvPrefix + "DATA_DIR")
+	}
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir()
+	}
+
+	path = f.config
+	if path == "" {
+		path = dataDir + string(os.PathSeparator) + config.ConfigFile
+	}
+
+	return dataDir, path
+}
+
+// load reads the config file, applies the environment overrides and then
+// override, which applies the flags, and validates the result
+func (f *dataDirFlags) load(override func(*config.Config)) (*config.Config, error) {
+	dataDir, path := f.resolve()
+
+	c, err := config.Load(path, dataDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no config at %s; run \"safarichain init\" first", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if f.dataDir != "" {
+		c.DataDir = f.dataDir
+	}
+	if override != nil {
+		override(c)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// parseArgs parses args with fs, accepting flags after the positional
+// arguments too, and returns the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// setFlags returns the names of the flags given on the command line
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	return set
+}
+
+// listFlag is a comma separated list flag
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, config.SplitList(s)...)
+	return nil
 }
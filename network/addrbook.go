@@ -0,0 +1,374 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxBookAddrs = 4096
+	defaultMaxPerSource = 64
+	defaultAddrHorizon  = 7 * 24 * time.Hour
+	defaultMaxFailures  = 5
+	defaultRetryDelay   = 30 * time.Second
+	maxRetryDelay       = time.Hour
+)
+
+// AddressBookOpts configures an AddressBook
+type AddressBookOpts struct {
+	MaxAddrs     int           // addresses kept, the least recently seen are dropped first; defaults to 4096
+	MaxPerSource int           // addresses reported by a single peer kept until we connect to them; defaults to 64
+	Horizon      time.Duration // addresses not seen for longer are dropped; defaults to 7 days
+	MaxFailures  int           // failed dials in a row after which an address is dropped; defaults to 5
+	RetryDelay   time.Duration // wait after a failed dial, doubling with every failure; defaults to 30s
+	// File is where the book is saved to so it survives restarts;
+	// empty keeps it in memory only
+	File string
+}
+
+// AddressBook remembers the addresses of the nodes of the network along
+// with when they were last seen reachable and how dialling them went.
+// It is safe for concurrent use
+type AddressBook struct {
+	AddressBookOpts
+	lock    sync.Mutex
+	addrs   map[NetAddr]*KnownAddr
+	sources map[NetAddr]int // entries by the peer that reported them
+}
+
+// KnownAddr is an entry of the address book
+type KnownAddr struct {
+	Addr        NetAddr   `json:"addr"`
+	Seen        time.Time `json:"seen"`                   // last time the node was connected or reported by a peer
+	LastAttempt time.Time `json:"last_attempt,omitempty"` // last time we dialled it
+	LastSuccess time.Time `json:"last_success,omitempty"` // last time dialling it succeeded
+	Failures    int       `json:"failures,omitempty"`     // failed dials since the last success
+	Source      NetAddr   `json:"source,omitempty"`       // peer id of the node that reported it, until we connect to it
+}
+
+func NewAddressBook(opts AddressBookOpts) *AddressBook {
+	if opts.MaxAddrs == 0 {
+		opts.MaxAddrs = defaultMaxBookAddrs
+	}
+	if opts.MaxPerSource == 0 {
+		opts.MaxPerSource = defaultMaxPerSource
+	}
+	if opts.Horizon == 0 {
+		opts.Horizon = defaultAddrHorizon
+	}
+	if opts.MaxFailures == 0 {
+		opts.MaxFailures = defaultMaxFailures
+	}
+	if opts.RetryDelay == 0 {
+		opts.RetryDelay = defaultRetryDelay
+	}
+
+	return &AddressBook{
+		AddressBookOpts: opts,
+		addrs:           make(map[NetAddr]*KnownAddr),
+		sources:         make(map[NetAddr]int),
+	}
+}
+
+// PeerIDOf returns the peer id of the node at addr: the id part of a node
+// address, or addr itself when it names no identity
+func PeerIDOf(addr NetAddr) NetAddr {
+	if id, _, ok := strings.Cut(string(addr), "@"); ok {
+		return NetAddr(id)
+	}
+
+	return addr
+}
+
+// Load restores the book saved to File, dropping addresses past the
+// horizon. A missing file is not an error
+func (b *AddressBook) Load() error {
+	if b.File == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(b.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var addrs []*KnownAddr
+	if err := json.Unmarshal(data, &addrs); err != nil {
+		return err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, ka := range addrs {
+		b.remove(ka.Addr)
+		b.put(ka)
+	}
+	b.expire(time.Now())
+	b.evict()
+
+	return nil
+}
+
+// Save writes the book to File, replacing it atomically
+func (b *AddressBook) Save() error {
+	if b.File == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(b.All(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := b.File + ".new"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, b.File)
+}
+
+// Add records that addr was reachable at seen. Times in the future count
+// as now and addresses last seen past the horizon are ignored
+func (b *AddressBook) Add(addr NetAddr, seen time.Time) {
+	b.AddFrom(addr, seen, "")
+}
+
+// AddFrom is Add for an address reported by the peer source. New
+// addresses are ignored once source has MaxPerSource of them in the book
+func (b *AddressBook) AddFrom(addr NetAddr, seen time.Time, source NetAddr) {
+	now := time.Now()
+	if seen.After(now) {
+		seen = now
+	}
+	if addr == "" || len(addr) > MaxAddrLen || now.Sub(seen) >= b.Horizon {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	ka, ok := b.addrs[addr]
+	if !ok {
+		if source != "" && b.sources[source] >= b.MaxPerSource {
+			return
+		}
+		b.put(&KnownAddr{Addr: addr, Seen: seen, Source: source})
+		b.evict()
+		return
+	}
+	if seen.After(ka.Seen) {
+		ka.Seen = seen
+	}
+}
+
+// Attempt records that addr is being dialled
+func (b *AddressBook) Attempt(addr NetAddr) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if ka, ok := b.addrs[addr]; ok {
+		ka.LastAttempt = time.Now()
+	}
+}
+
+// Good records a successful connection to addr
+func (b *AddressBook) Good(addr NetAddr) {
+	now := time.Now()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	ka, ok := b.addrs[addr]
+	if ok && ka.Source != "" {
+		b.remove(addr)
+		ok = false
+	}
+	if !ok {
+		ka = &KnownAddr{Addr: addr}
+		b.put(ka)
+		b.evict()
+	}
+	ka.Seen, ka.LastSuccess, ka.Failures = now, now, 0
+}
+
+// Failed records a failed dial of addr, dropping the address after
+// MaxFailures failures in a row
+func (b *AddressBook) Failed(addr NetAddr) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	ka, ok := b.addrs[addr]
+	if !ok {
+		return
+	}
+
+	ka.Failures++
+	if ka.Failures >= b.MaxFailures {
+		b.remove(addr)
+	}
+}
+
+// Remove forgets addr
+func (b *AddressBook) Remove(addr NetAddr) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.remove(addr)
+}
+
+// Known reports whether addr is in the book
+func (b *AddressBook) Known(addr NetAddr) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	_, ok := b.addrs[addr]
+	return ok
+}
+
+// Len returns the number of known addresses
+func (b *AddressBook) Len() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return len(b.addrs)
+}
+
+// All returns a copy of every entry, the most recently seen first
+func (b *AddressBook) All() []KnownAddr {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.expire(time.Now())
+	addrs := make([]KnownAddr, 0, len(b.addrs))
+	for _, ka := range b.addrs {
+		addrs = append(addrs, *ka)
+	}
+	sortBySeen(addrs)
+
+	return addrs
+}
+
+// Fresh returns up to n entries to share with peers, the most recently
+// seen first
+func (b *AddressBook) Fresh(n int) []AddrEntry {
+	all := b.All()
+	entries := make([]AddrEntry, 0, min(n, len(all)))
+	for _, ka := range all[:min(n, len(all))] {
+		entries = append(entries, AddrEntry{Addr: ka.Addr, Seen: ka.Seen.Unix()})
+	}
+
+	return entries
+}
+
+// Candidates returns up to n addresses to dial in random order, leaving
+// out those skip returns true for and those still waiting to be retried
+// after a failure. Addresses dialled successfully before come first
+func (b *AddressBook) Candidates(n int, skip func(NetAddr) bool) []NetAddr {
+	now := time.Now()
+
+	b.lock.Lock()
+	b.expire(now)
+	var eligible []KnownAddr
+	for addr, ka := range b.addrs {
+		if skip != nil && skip(addr) {
+			continue
+		}
+		if ka.Failures > 0 && now.Sub(ka.LastAttempt) < b.retryDelay(ka.Failures) {
+			continue
+		}
+		eligible = append(eligible, *ka)
+	}
+	b.lock.Unlock()
+
+	rand.Shuffle(len(eligible), func(i, j int) {
+		eligible[i], eligible[j] = eligible[j], eligible[i]
+	})
+	sort.SliceStable(eligible, func(i, j int) bool {
+		return !eligible[i].LastSuccess.IsZero() && eligible[j].LastSuccess.IsZero()
+	})
+
+	addrs := make([]NetAddr, 0, min(n, len(eligible)))
+	for _, ka := range eligible[:min(n, len(eligible))] {
+		addrs = append(addrs, ka.Addr)
+	}
+
+	return addrs
+}
+
+// retryDelay returns how long to wait before dialling an address again
+// after failures failed attempts
+func (b *AddressBook) retryDelay(failures int) time.Duration {
+	delay := b.RetryDelay
+	for i := 1; i < failures && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxRetryDelay)
+}
+
+// evict drops the least recently seen addresses until at most MaxAddrs
+// are left. Callers hold the lock
+func (b *AddressBook) evict() {
+	for len(b.addrs) > b.MaxAddrs {
+		var oldest *KnownAddr
+		for _, ka := range b.addrs {
+			if oldest == nil || ka.Seen.Before(oldest.Seen) {
+				oldest = ka
+			}
+		}
+		b.remove(oldest.Addr)
+	}
+}
+
+// expire drops the addresses not seen within the horizon. Callers hold the lock
+func (b *AddressBook) expire(now time.Time) {
+	for addr, ka := range b.addrs {
+		if now.Sub(ka.Seen) >= b.Horizon {
+			b.remove(addr)
+		}
+	}
+}
+
+// put adds the entry ka, counting it for its source. Callers hold the lock
+func (b *AddressBook) put(ka *KnownAddr) {
+	b.addrs[ka.Addr] = ka
+	if ka.Source != "" {
+		b.sources[ka.Source]++
+	}
+}
+
+// remove forgets addr and uncounts it for its source. Callers hold the lock
+func (b *AddressBook) remove(addr NetAddr) {
+	ka, ok := b.addrs[addr]
+	if !ok {
+		return
+	}
+	delete(b.addrs, addr)
+	if ka.Source != "" {
+		if b.sources[ka.Source]--; b.sources[ka.Source] == 0 {
+			delete(b.sources, ka.Source)
+		}
+	}
+}
+
+// sortBySeen orders addrs by the time they were last seen, most recent
+// first, then by address
+func sortBySeen(addrs []KnownAddr) {
+	sort.Slice(addrs, func(i, j int) bool {
+		if !addrs[i].Seen.Equal(addrs[j].Seen) {
+			return addrs[i].Seen.After(addrs[j].Seen)
+		}
+		return addrs[i].Addr < addrs[j].Addr
+	})
+}
@@ -0,0 +1,110 @@
+package network
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerIDOf(t *testing.T) {
+	assert.Equal(t, NetAddr("ab12"), PeerIDOf("ab12@127.0.0.1:3000"))
+	assert.Equal(t, NetAddr("127.0.0.1:3000"), PeerIDOf("127.0.0.1:3000"))
+}
+
+func TestAddressBook_Freshness(t *testing.T) {
+	b := NewAddressBook(AddressBookOpts{MaxAddrs: 2, Horizon: time.Hour})
+	now := time.Now()
+
+	b.Add("a", now.Add(-30*time.Minute))
+	b.Add("b", now.Add(-2*time.Hour)) // past the horizon
+	b.Add("c", now.Add(time.Hour))    // in the future, counts as now
+	assert.Equal(t, 2, b.Len())
+	assert.False(t, b.Known("b"))
+
+	// seeing an address again refreshes it, older reports do not
+	b.Add("a", now.Add(-10*time.Minute))
+	b.Add("a", now.Add(-50*time.Minute))
+	entries := b.Fresh(10)
+	assert.Equal(t, []NetAddr{"c", "a"}, []NetAddr{entries[0].Addr, entries[1].Addr})
+	assert.Equal(t, now.Add(-10*time.Minute).Unix(), entries[1].Seen)
+
+	// the least recently seen address makes room for a new one
+	b.Add("d", now.Add(-time.Minute))
+	assert.False(t, b.Known("a"))
+	assert.True(t, b.Known("d"))
+	assert.Len(t, b.Fresh(1), 1)
+}
+
+func TestAddressBook_CapsAddressesPerSource(t *testing.T) {
+	b := NewAddressBook(AddressBookOpts{MaxPerSource: 2})
+	now := time.Now()
+
+	b.AddFrom("a", now, "peer")
+	b.AddFrom("b", now, "peer")
+	b.AddFrom("c", now, "peer")
+	assert.False(t, b.Known("c"))
+
+	// known addresses are still refreshed and other sources are not limited
+	b.AddFrom("a", now.Add(time.Minute), "peer")
+	b.AddFrom("c", now, "other")
+	assert.True(t, b.Known("c"))
+
+	// an address we connected to no longer counts for its source
+	b.Good("a")
+	b.AddFrom("d", now, "peer")
+	assert.True(t, b.Known("d"))
+	b.Remove("d")
+	b.AddFrom("e", now, "peer")
+	assert.True(t, b.Known("e"))
+}
+
+func TestAddressBook_RetriesWithBackoff(t *testing.T) {
+	b := NewAddressBook(AddressBookOpts{MaxFailures: 3, RetryDelay: time.Hour})
+	b.Add("a", time.Now())
+	b.Add("b", time.Now())
+	b.Good("b")
+
+	// addresses dialled successfully before come first
+	assert.Equal(t, []NetAddr{"b", "a"}, b.Candidates(2, nil))
+	assert.Equal(t, []NetAddr{"a"}, b.Candidates(2, func(addr NetAddr) bool { return addr == "b" }))
+
+	b.Attempt("a")
+	b.Failed("a")
+	assert.Equal(t, []NetAddr{"b"}, b.Candidates(2, nil))
+
+	b.Failed("a")
+	b.Failed("a")
+	assert.False(t, b.Known("a"))
+
+	assert.Equal(t, 30*time.Second, NewAddressBook(AddressBookOpts{}).retryDelay(1))
+	assert.Equal(t, 2*time.Minute, NewAddressBook(AddressBookOpts{}).retryDelay(3))
+	assert.Equal(t, time.Hour, NewAddressBook(AddressBookOpts{}).retryDelay(20))
+}
+
+func TestAddressBook_Persists(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "peers.json")
+
+	b := NewAddressBook(AddressBookOpts{File: file})
+	assert.NoError(t, b.Load())
+	b.Add("a", time.Now().Add(-time.Minute))
+	b.Good("b")
+	b.Attempt("a")
+	b.Failed("a")
+	assert.NoError(t, b.Save())
+
+	restarted := NewAddressBook(AddressBookOpts{File: file})
+	assert.NoError(t, restarted.Load())
+	all := restarted.All()
+	assert.Len(t, all, 2)
+	assert.Equal(t, NetAddr("b"), all[0].Addr)
+	assert.False(t, all[0].LastSuccess.IsZero())
+	assert.Equal(t, NetAddr("a"), all[1].Addr)
+	assert.Equal(t, 1, all[1].Failures)
+
+	// entries gone stale while the node was down are dropped
+	stale := NewAddressBook(AddressBookOpts{File: file, Horizon: time.Second})
+	assert.NoError(t, stale.Load())
+	assert.Equal(t, 1, stale.Len())
+}
@@ -0,0 +1,201 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/majorshift/safari-chain/crypto"
+)
+
+// RejectReason tells at which stage of admission a transaction was rejected
+type RejectReason int
+
+const (
+	RejectMalformed           RejectReason = iota // missing or malformed sender, receiver or signature
+	RejectTooLarge                                // larger than the maximum transaction size
+	RejectInvalidSignature                        // signature does not match the sender
+	RejectWrongChain                              // signed for another chain id
+	RejectNonceTooLow                             // nonce already used by the sender
+	RejectInsufficientBalance                     // sender cannot pay the value and fee
+	RejectExpired                                 // past its expiry height
+	RejectUnderpriced                             // does not outbid the cheapest transaction of a full pool
+	RejectReplaceUnderpriced                      // same nonce as a pooled transaction without the required fee bump
+	RejectSenderSlotsFull                         // the sender already fills its slots in the pool
+	RejectPolicy                                  // refused by a custom policy check
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectMalformed:
+		return "malformed"
+	case RejectTooLarge:
+		return "too large"
+	case RejectInvalidSignature:
+		return "invalid signature"
+	case RejectWrongChain:
+		return "wrong chain"
+	case RejectNonceTooLow:
+		return "nonce too low"
+	case RejectInsufficientBalance:
+		return "insufficient balance"
+	case RejectExpired:
+		return "expired"
+	case RejectUnderpriced:
+		return "underpriced"
+	case RejectReplaceUnderpriced:
+		return "replacement underpriced"
+	case RejectSenderSlotsFull:
+		return "sender slots full"
+	case RejectPolicy:
+		return "policy"
+	default:
+		return "unknown"
+	}
+}
+
+// RejectError is returned by MemPool.Add when a transaction is not admitted
+type RejectError struct {
+	Hash   crypto.Hash  // hash of the rejected transaction
+	Reason RejectReason // stage that rejected it
+	Err    error        // underlying error
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("transaction %s rejected (%s): %v", e.Hash.ToString(), e.Reason, e.Err)
+}
+
+func (e *RejectError) Unwrap() error {
+	return e.Err
+}
+
+// reject wraps err in a RejectError for tx
+func reject(tx *crypto.Transaction, reason RejectReason, err error) *RejectError {
+	return &RejectError{Hash: tx.Hash(crypto.TxHash{}), Reason: reason, Err: err}
+}
+
+var errMalformedTx = errors.New("malformed transaction")
+
+// AdmissionCheck is a stateless stage of the admission pipeline.
+// It runs before the pool lock is taken, so it must not touch the pool.
+// Errors that are not a *RejectError are reported as RejectPolicy
+type AdmissionCheck func(tx *crypto.Transaction) error
+
+// DefaultAdmissionChecks returns the stateless checks every transaction goes
+// through, in order: structure, size, signature and chain id
+func DefaultAdmissionChecks(chainID uint32, maxTxSize int) []AdmissionCheck {
+	return []AdmissionCheck{
+		CheckStructure,
+		CheckSize(maxTxSize),
+		CheckSignature,
+		CheckChainID(chainID),
+	}
+}
+
+// CheckStructure rejects transactions without a well formed sender key,
+// receiver key or signature
+func CheckStructure(tx *crypto.Transaction) error {
+	switch {
+	case tx.From == nil || len(tx.From.Key) != crypto.PubKeyLen:
+		return reject(tx, RejectMalformed, fmt.Errorf("%w: bad sender key", errMalformedTx))
+	case tx.Receiver != nil && len(tx.Receiver.Key) != crypto.PubKeyLen:
+		return reject(tx, RejectMalformed, fmt.Errorf("%w: bad receiver key", errMalformedTx))
+	case tx.Signature == nil:
+		return reject(tx, RejectMalformed, crypto.ErrTxNoSignature)
+	case len(tx.Signature.Value) != crypto.SignatureLen:
+		return reject(tx, RejectMalformed, fmt.Errorf("%w: bad signature length", errMalformedTx))
+	}
+
+	return nil
+}
+
+// CheckSize rejects transactions larger than max bytes
+func CheckSize(max int) AdmissionCheck {
+	return func(tx *crypto.Transaction) error {
+		if size := tx.Size(); size > max {
+			return reject(tx, RejectTooLarge, fmt.Errorf("%w: %d > %d", crypto.ErrTxTooLarge, size, max))
+		}
+
+		return nil
+	}
+}
+
+// CheckSignature rejects transactions not signed by their sender
+func CheckSignature(tx *crypto.Transaction) error {
+	if err := tx.Verify(); err != nil {
+		return reject(tx, RejectInvalidSignature, err)
+	}
+
+	return nil
+}
+
+// CheckChainID rejects transactions signed for another chain
+func CheckChainID(chainID uint32) AdmissionCheck {
+	return func(tx *crypto.Transaction) error {
+		if tx.ChainID != chainID {
+			return reject(tx, RejectWrongChain, fmt.Errorf("%w: expected %d, got %d", crypto.ErrTxWrongChain, chainID, tx.ChainID))
+		}
+
+		return nil
+	}
+}
+
+// runChecks passes tx through the stateless pipeline followed by the policies
+func (p *MemPool) runChecks(tx *crypto.Transaction) error {
+	for _, check := range p.Checks {
+		if err := check(tx); err != nil {
+			return asRejectError(tx, err)
+		}
+	}
+	for _, policy := range p.Policies {
+		if err := policy(tx); err != nil {
+			return asRejectError(tx, err)
+		}
+	}
+
+	return nil
+}
+
+// checkState rejects transactions that the confirmed state cannot execute.
+// Like revalidate, it pays for the sender's pooled transactions with lower
+// nonces first, so a replacement only swaps its cost for the replaced one.
+// The caller must hold the lock
+func (p *MemPool) checkState(tx *crypto.Transaction) error {
+	if p.State == nil {
+		return nil
+	}
+
+	account := p.State.Account(tx.From)
+	if tx.Nonce < account.Nonce {
+		return reject(tx, RejectNonceTooLow, fmt.Errorf("%w: expected at least %d, got %d", ErrNonceTooLow, account.Nonce, tx.Nonce))
+	}
+	if tx.Expired(p.nextHeight()) {
+		return reject(tx, RejectExpired, crypto.ErrTxExpired)
+	}
+
+	balance := account.Balance
+	if queue := p.senders[tx.SenderKey()]; queue != nil {
+		for _, pooled := range queue.sorted() {
+			if pooled.Nonce >= tx.Nonce {
+				break
+			}
+			if pooled.Nonce >= account.Nonce {
+				balance -= min(pooled.Cost(), balance)
+			}
+		}
+	}
+	if tx.Cost() > balance {
+		return reject(tx, RejectInsufficientBalance, fmt.Errorf("%w: balance %d left after pooled transactions, cost %d", crypto.ErrInsufficientBalance, balance, tx.Cost()))
+	}
+
+	return nil
+}
+
+// asRejectError returns err as a *RejectError, wrapping it as a policy rejection if needed
+func asRejectError(tx *crypto.Transaction, err error) error {
+	var rejectErr *RejectError
+	if errors.As(err, &rejectErr) {
+		return err
+	}
+
+	return reject(tx, RejectPolicy, err)
+}
@@ -0,0 +1,135 @@
+package network
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// helper function asserting that err is a RejectError with the given reason
+func assertRejected(t *testing.T, err error, reason RejectReason) {
+	var rejectErr *RejectError
+	if assert.ErrorAs(t, err, &rejectErr) {
+		assert.Equal(t, reason, rejectErr.Reason)
+	}
+}
+
+func TestAdmission_StatelessChecks(t *testing.T) {
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 10, ChainID: 3, MaxTxSize: 256})
+	sender, _ := crypto.GeneratePrivateKey()
+
+	unsigned := crypto.NewTransaction(sender.PublicKey(), sender.PublicKey(), []byte("unsigned"))
+	unsigned.ChainID = 3
+	assertRejected(t, p.Add(unsigned), RejectMalformed)
+
+	noSender := &crypto.Transaction{Data: []byte("anonymous")}
+	assertRejected(t, p.Add(noSender), RejectMalformed)
+
+	large := newTransferTx(t, sender, 0, 0)
+	large.Data = make([]byte, 512)
+	large.ChainID = 3
+	large.Sign(sender)
+	err := p.Add(large)
+	assertRejected(t, err, RejectTooLarge)
+	assert.ErrorIs(t, err, crypto.ErrTxTooLarge)
+
+	tampered := newTransferTx(t, sender, 0, 0)
+	tampered.ChainID = 3
+	tampered.Sign(sender)
+	tampered.Value = 100
+	err = p.Add(tampered)
+	assertRejected(t, err, RejectInvalidSignature)
+	assert.ErrorIs(t, err, crypto.ErrTxInvalidSignature)
+
+	otherChain := newTransferTx(t, sender, 0, 0)
+	err = p.Add(otherChain)
+	assertRejected(t, err, RejectWrongChain)
+	assert.ErrorIs(t, err, crypto.ErrTxWrongChain)
+
+	valid := newTransferTx(t, sender, 0, 0)
+	valid.ChainID = 3
+	valid.Sign(sender)
+	assert.NoError(t, p.Add(valid))
+	assert.Equal(t, 1, p.AllTxCount())
+}
+
+func TestAdmission_StateChecks(t *testing.T) {
+	sender, _ := crypto.GeneratePrivateKey()
+	chain := newTestChain(t, fundedConfig(100, sender))
+
+	p := NewMempool(10)
+	p.SubscribeChain(chain)
+
+	err := p.Add(newTransferTx(t, sender, 0, 101))
+	assertRejected(t, err, RejectInsufficientBalance)
+	assert.ErrorIs(t, err, crypto.ErrInsufficientBalance)
+
+	assert.NoError(t, chain.AddBlock(nextBlock(t, chain, 0, newTransferTx(t, sender, 0, 1))))
+	err = p.Add(newTransferTx(t, sender, 0, 1))
+	assertRejected(t, err, RejectNonceTooLow)
+	assert.ErrorIs(t, err, ErrNonceTooLow)
+}
+
+func TestAdmission_PaysForPooledTransactionsFirst(t *testing.T) {
+	sender, _ := crypto.GeneratePrivateKey()
+	chain := newTestChain(t, fundedConfig(100, sender))
+
+	p := NewMempool(10)
+	p.SubscribeChain(chain)
+	tx := func(nonce, value, fee uint64) *crypto.Transaction {
+		tx := newTransferTx(t, sender, nonce, value)
+		tx.Fee = fee
+		tx.Sign(sender)
+		return tx
+	}
+
+	// the first transaction spends the whole balance
+	assert.NoError(t, p.Add(tx(0, 90, 10)))
+	assertRejected(t, p.Add(tx(1, 1, 0)), RejectInsufficientBalance)
+
+	// a cheaper replacement frees part of it
+	assert.NoError(t, p.Add(tx(0, 50, 20)))
+	assert.NoError(t, p.Add(tx(1, 30, 0)))
+	assertRejected(t, p.Add(tx(2, 1, 0)), RejectInsufficientBalance)
+	assert.Equal(t, 2, p.PendingTxCount())
+}
+
+func TestAdmission_PoolRejectionsAreTyped(t *testing.T) {
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 1, MaxSenderSlots: 1})
+	sender, _ := crypto.GeneratePrivateKey()
+
+	assert.NoError(t, p.Add(newFeeTx(t, sender, 0, 10)))
+	assertRejected(t, p.Add(newFeeTx(t, sender, 0, 10)), RejectReplaceUnderpriced)
+	assertRejected(t, p.Add(newFeeTx(t, sender, 1, 10)), RejectSenderSlotsFull)
+
+	other, _ := crypto.GeneratePrivateKey()
+	assertRejected(t, p.Add(newFeeTx(t, other, 0, 1)), RejectUnderpriced)
+}
+
+func TestAdmission_Policies(t *testing.T) {
+	errNoData := errors.New("empty data not allowed")
+	p := NewMempoolWithOpts(MempoolOpts{
+		MaxSize: 10,
+		Policies: []AdmissionCheck{
+			func(tx *crypto.Transaction) error {
+				if len(tx.Data) == 0 {
+					return errNoData
+				}
+				return nil
+			},
+		},
+	})
+
+	sender, _ := crypto.GeneratePrivateKey()
+	tx := newTransferTx(t, sender, 0, 0)
+	tx.Data = nil
+	tx.Sign(sender)
+
+	err := p.Add(tx)
+	assertRejected(t, err, RejectPolicy)
+	assert.ErrorIs(t, err, errNoData)
+
+	assert.NoError(t, p.Add(newTransferTx(t, sender, 0, 0)))
+}
@@ -0,0 +1,381 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultDiscoveryInterval = 5 * time.Second
+	defaultExchangeInterval  = 2 * time.Minute
+	defaultBookSaveInterval  = 10 * time.Minute
+	addrRelayLimit           = 10            // larger address messages answer a request and are not relayed
+	addrRelayFanout          = 2             // peers a newly learnt address is relayed to
+	addrSeenPenalty          = 2 * time.Hour // addresses reported for other nodes count as seen at least this long ago
+)
+
+// DiscoveryOpts configures a Discovery
+type DiscoveryOpts struct {
+	Bootstrap []NetAddr // nodes dialled while no other outbound peer can be found
+	// ExternalAddr is the address advertised to peers. It defaults to the
+	// transport address when peers can dial it; otherwise, as for a node
+	// listening on ":3000", the node is not advertised
+	ExternalAddr     NetAddr
+	MaxOutbound      int           // outbound connections to keep; defaults to 8
+	Interval         time.Duration // how often missing outbound connections are dialled; defaults to 5s
+	ExchangeInterval time.Duration // how often a random peer is asked for addresses; defaults to 2m
+	SaveInterval     time.Duration // how often the book is saved; defaults to 10m
+	Book             *AddressBook  // defaults to an empty book kept in memory
+	// Dial connects to the node at addr and returns the PeerID it authenticated
+	// with; defaults to the Dial method of the transport
+	Dial   func(addr NetAddr) (NetAddr, error)
+	Logger *logrus.Logger
+}
+
+// Discovery finds the nodes of the network and keeps MaxOutbound of them
+// connected. New peers are asked for the addresses they know and told the
+// address of this node, which they relay to a few of their peers. Learnt
+// addresses go to the address book, which is dialled from to fill the
+// outbound slots. The bootstrap nodes are the way in for a node knowing
+// nobody yet
+type Discovery struct {
+	DiscoveryOpts
+	transport Transport
+	lock      sync.Mutex
+	greeted   map[NetAddr]bool    // connected peers sent our address and a get addr
+	dialing   map[NetAddr]bool    // addresses being dialled
+	outbound  map[NetAddr]NetAddr // address by peer id of the peers we dialled
+	wg        sync.WaitGroup
+}
+
+func NewDiscovery(transport Transport, opts DiscoveryOpts) *Discovery {
+	if opts.ExternalAddr == "" && routable(transport.Addr()) {
+		opts.ExternalAddr = transport.Addr()
+	}
+	if opts.MaxOutbound == 0 {
+		opts.MaxOutbound = defaultMaxOutbound
+	}
+	if opts.Interval == 0 {
+		opts.Interval = defaultDiscoveryInterval
+	}
+	if opts.ExchangeInterval == 0 {
+		opts.ExchangeInterval = defaultExchangeInterval
+	}
+	if opts.SaveInterval == 0 {
+		opts.SaveInterval = defaultBookSaveInterval
+	}
+	if opts.Book == nil {
+		opts.Book = NewAddressBook(AddressBookOpts{})
+	}
+	if opts.Dial == nil {
+		if dialer, ok := transport.(interface {
+			Dial(NetAddr) (NetAddr, error)
+		}); ok {
+			opts.Dial = dialer.Dial
+		} else {
+			opts.Dial = func(addr NetAddr) (NetAddr, error) {
+				return "", fmt.Errorf("cannot dial %s over %T", addr, transport)
+			}
+		}
+	}
+	if opts.Logger == nil {
+		opts.Logger = logrus.New()
+	}
+
+	return &Discovery{
+		DiscoveryOpts: opts,
+		transport:     transport,
+		greeted:       make(map[NetAddr]bool),
+		dialing:       make(map[NetAddr]bool),
+		outbound:      make(map[NetAddr]NetAddr),
+	}
+}
+
+// Start fills the outbound slots every Interval and exchanges addresses
+// every ExchangeInterval until ctx is cancelled. The book is saved
+// every SaveInterval and once more before Start returns
+func (d *Discovery) Start(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	exchange := time.NewTicker(d.ExchangeInterval)
+	defer exchange.Stop()
+	save := time.NewTicker(d.SaveInterval)
+	defer save.Stop()
+
+	if d.ExternalAddr == "" {
+		d.Logger.Warn("no routable address to advertise, peers will not learn how to reach this node")
+	}
+
+	d.step()
+	for {
+		select {
+		case <-ctx.Done():
+			d.wg.Wait()
+			d.save()
+			return
+		case <-ticker.C:
+			d.step()
+		case <-exchange.C:
+			d.exchange()
+		case <-save.C:
+			d.save()
+		}
+	}
+}
+
+// OutboundPeers returns the ids of the connected peers we dialled
+func (d *Discovery) OutboundPeers() []NetAddr {
+	peers := d.transport.Peers()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var ids []NetAddr
+	for _, id := range peers {
+		if _, ok := d.outbound[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// HandleMessage processes a peer exchange message received from the peer
+// from. Only get addr and addr messages are accepted
+func (d *Discovery) HandleMessage(from NetAddr, msg *Message) error {
+	p, err := msg.DecodePayload()
+	if err != nil {
+		return err
+	}
+
+	switch p := p.(type) {
+	case *GetAddrMessage:
+		return d.handleGetAddr(from)
+	case *AddrMessage:
+		return d.handleAddr(from, p)
+	default:
+		return fmt.Errorf("discovery cannot handle %s message", msg.Type)
+	}
+}
+
+// handleGetAddr answers with the freshest addresses of the book
+func (d *Discovery) handleGetAddr(from NetAddr) error {
+	entries := d.Book.Fresh(MaxAddrs + 1)
+	addrs := make([]AddrEntry, 0, len(entries))
+	for _, e := range entries {
+		if PeerIDOf(e.Addr) != from && len(addrs) < MaxAddrs {
+			addrs = append(addrs, e)
+		}
+	}
+
+	return d.send(from, &AddrMessage{Addrs: addrs})
+}
+
+// handleAddr adds the shared addresses to the book, leaving out those we
+// could not dial. Only a node advertising its own address is trusted with
+// when it was seen, and a peer may only add MaxPerSource addresses to the
+// book, so that one peer cannot flood it. Small messages are
+// advertisements, whose addresses are relayed on if they are new
+func (d *Discovery) handleAddr(from NetAddr, m *AddrMessage) error {
+	self := PeerIDOf(d.transport.Addr())
+	arrived := time.Now()
+
+	var fresh []AddrEntry
+	for _, e := range m.Addrs {
+		id := PeerIDOf(e.Addr)
+		if id == self || !routable(e.Addr) {
+			continue
+		}
+		seen := time.Unix(e.Seen, 0)
+		if limit := arrived.Add(-addrSeenPenalty); id != from && seen.After(limit) {
+			seen = limit
+		}
+
+		known := d.Book.Known(e.Addr)
+		d.Book.AddFrom(e.Addr, seen, from)
+		if !known && d.Book.Known(e.Addr) {
+			fresh = append(fresh, AddrEntry{Addr: e.Addr, Seen: seen.Unix()})
+		}
+	}
+
+	if len(m.Addrs) > addrRelayLimit || len(fresh) == 0 {
+		return nil
+	}
+
+	var targets []NetAddr
+	for _, id := range d.transport.Peers() {
+		if id != from {
+			targets = append(targets, id)
+		}
+	}
+	rand.Shuffle(len(targets), func(i, j int) {
+		targets[i], targets[j] = targets[j], targets[i]
+	})
+	for _, id := range targets[:min(addrRelayFanout, len(targets))] {
+		if err := d.send(id, &AddrMessage{Addrs: fresh}); err != nil {
+			d.Logger.WithField("peer", id).WithError(err).Debug("failed to relay addresses")
+		}
+	}
+
+	return nil
+}
+
+// step greets new peers and dials addresses from the book until the
+// outbound slots are filled, resorting to the bootstrap nodes when no
+// outbound peer is connected and the book is of no help
+func (d *Discovery) step() {
+	connected := d.connected()
+
+	d.lock.Lock()
+	var greet []NetAddr
+	for id := range connected {
+		if !d.greeted[id] {
+			d.greeted[id] = true
+			greet = append(greet, id)
+		}
+	}
+	for id := range d.greeted {
+		if !connected[id] {
+			delete(d.greeted, id)
+		}
+	}
+
+	// addresses of connected outbound peers, which may differ from their ids
+	dialled := make(map[NetAddr]bool)
+	for id, addr := range d.outbound {
+		if connected[id] {
+			dialled[addr] = true
+			continue
+		}
+		delete(d.outbound, id)
+	}
+	outbound := len(d.outbound)
+	free := d.MaxOutbound - outbound - len(d.dialing)
+
+	self := PeerIDOf(d.transport.Addr())
+	skip := func(addr NetAddr) bool {
+		id := PeerIDOf(addr)
+		return id == self || connected[id] || d.dialing[addr] || dialled[addr]
+	}
+	var dial []NetAddr
+	if free > 0 {
+		dial = d.Book.Candidates(free, skip)
+	}
+	if len(dial) == 0 && outbound == 0 && len(d.dialing) == 0 {
+		for _, addr := range d.Bootstrap {
+			if !skip(addr) {
+				dial = append(dial, addr)
+			}
+		}
+	}
+	for _, addr := range dial {
+		d.dialing[addr] = true
+	}
+	d.lock.Unlock()
+
+	for _, id := range greet {
+		d.greet(id)
+	}
+	for _, addr := range dial {
+		d.wg.Add(1)
+		go d.dial(addr)
+	}
+}
+
+// greet asks a new peer for addresses and tells it our own, if we have one
+func (d *Discovery) greet(id NetAddr) {
+	greeting := []Payload{&GetAddrMessage{}}
+	if d.ExternalAddr != "" {
+		greeting = append(greeting, &AddrMessage{Addrs: []AddrEntry{{Addr: d.ExternalAddr, Seen: time.Now().Unix()}}})
+	}
+	for _, p := range greeting {
+		if err := d.send(id, p); err != nil {
+			d.Logger.WithField("peer", id).WithError(err).Debug("failed to greet peer")
+			return
+		}
+	}
+}
+
+// dial connects to addr and records the outcome in the book
+func (d *Discovery) dial(addr NetAddr) {
+	defer d.wg.Done()
+
+	d.Book.Attempt(addr)
+	id, err := d.Dial(addr)
+
+	d.lock.Lock()
+	delete(d.dialing, addr)
+	if err == nil {
+		d.outbound[id] = addr
+	}
+	d.lock.Unlock()
+
+	if err != nil {
+		d.Logger.WithField("addr", addr).WithError(err).Debug("failed to dial peer")
+		d.Book.Failed(addr)
+		return
+	}
+	d.Book.Good(addr)
+}
+
+// exchange asks a random peer for the addresses it knows
+func (d *Discovery) exchange() {
+	peers := d.transport.Peers()
+	if len(peers) == 0 {
+		return
+	}
+
+	id := peers[rand.Intn(len(peers))]
+	if err := d.send(id, &GetAddrMessage{}); err != nil {
+		d.Logger.WithField("peer", id).WithError(err).Debug("failed to ask for addresses")
+	}
+}
+
+// connected returns the set of connected peer ids
+func (d *Discovery) connected() map[NetAddr]bool {
+	connected := make(map[NetAddr]bool)
+	for _, id := range d.transport.Peers() {
+		connected[id] = true
+	}
+
+	return connected
+}
+
+func (d *Discovery) save() {
+	if err := d.Book.Save(); err != nil && !errors.Is(err, context.Canceled) {
+		d.Logger.WithError(err).Error("failed to save address book")
+	}
+}
+
+func (d *Discovery) send(to NetAddr, p Payload) error {
+	payload, err := EncodeMessage(p)
+	if err != nil {
+		return err
+	}
+
+	return d.transport.SendMessage(to, payload)
+}
+
+// routable tells whether peers can dial addr. TCP addresses need a host
+// other than a wildcard; other addresses, such as the ones of local
+// transports, always are
+func routable(addr NetAddr) bool {
+	_, hostport, err := ParseNodeAddr(addr)
+	if err != nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return host != "" && (ip == nil || !ip.IsUnspecified())
+}
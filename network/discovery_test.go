@@ -0,0 +1,235 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+type discoveryNode struct {
+	transport *LocalTransport
+	discovery *Discovery
+}
+
+// helper function creating n nodes discovering each other through the
+// first, dialling by connecting local transports
+func newDiscoveryNodes(t *testing.T, n int, opts DiscoveryOpts) []*discoveryNode {
+	transports := make(map[NetAddr]*LocalTransport)
+	for i := 0; i < n; i++ {
+		addr := NetAddr(fmt.Sprintf("node%d", i))
+		transports[addr] = NewLocalTransport(addr)
+	}
+
+	var nodes []*discoveryNode
+	for i := 0; i < n; i++ {
+		tr := transports[NetAddr(fmt.Sprintf("node%d", i))]
+		o := opts
+		o.Book = NewAddressBook(AddressBookOpts{})
+		if i > 0 {
+			o.Bootstrap = []NetAddr{"node0"}
+		}
+		o.Dial = func(addr NetAddr) (NetAddr, error) {
+			peer, ok := transports[addr]
+			if !ok {
+				return "", fmt.Errorf("no node at %s", addr)
+			}
+			return peer.Addr(), tr.Connect(peer)
+		}
+		nodes = append(nodes, &discoveryNode{transport: tr, discovery: NewDiscovery(tr, o)})
+	}
+
+	return nodes
+}
+
+// helper function running the discovery of n and delivering it the
+// messages received by n until the test ends
+func startDiscovery(t *testing.T, n *discoveryNode) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go n.discovery.Start(ctx)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case rpc := <-n.transport.Consume():
+				msg, err := DecodeMessage(rpc.Payload)
+				if err != nil {
+					continue
+				}
+				n.discovery.HandleMessage(rpc.From, msg)
+			}
+		}
+	}()
+}
+
+func TestDiscovery_FindsTheNetwork(t *testing.T) {
+	opts := DiscoveryOpts{MaxOutbound: 3, Interval: 10 * time.Millisecond, ExchangeInterval: 20 * time.Millisecond}
+	nodes := newDiscoveryNodes(t, 12, opts)
+	for _, n := range nodes {
+		startDiscovery(t, n)
+	}
+
+	// every node learns about all others and fills its outbound slots,
+	// except for the bootstrap node everybody is connected to already
+	assert.Eventually(t, func() bool {
+		for i, n := range nodes {
+			if n.discovery.Book.Len() != len(nodes)-1 {
+				return false
+			}
+			if i > 0 && len(n.discovery.OutboundPeers()) != 3 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// nodes do not know or dial themselves
+	for _, n := range nodes {
+		assert.False(t, n.discovery.Book.Known(n.transport.Addr()))
+		assert.NotContains(t, n.discovery.OutboundPeers(), n.transport.Addr())
+	}
+}
+
+func TestDiscovery_AnswersGetAddr(t *testing.T) {
+	a := NewLocalTransport("a")
+	b := NewLocalTransport("b")
+	assert.NoError(t, a.Connect(b))
+
+	d := NewDiscovery(a, DiscoveryOpts{})
+	d.Book.Add("b", time.Now())
+	d.Book.Add("c", time.Now().Add(-time.Minute))
+
+	msg, err := NewMessage(&GetAddrMessage{})
+	assert.NoError(t, err)
+	assert.NoError(t, d.HandleMessage("b", msg))
+
+	// the requester is left out
+	reply, ok := nextPayload(t, b).(*AddrMessage)
+	assert.True(t, ok)
+	assert.Len(t, reply.Addrs, 1)
+	assert.Equal(t, NetAddr("c"), reply.Addrs[0].Addr)
+
+	// our own address is not added
+	msg, err = NewMessage(&AddrMessage{Addrs: []AddrEntry{{Addr: "a", Seen: time.Now().Unix()}, {Addr: "d", Seen: time.Now().Unix()}}})
+	assert.NoError(t, err)
+	assert.NoError(t, d.HandleMessage("b", msg))
+	assert.False(t, d.Book.Known("a"))
+	assert.True(t, d.Book.Known("d"))
+}
+
+func TestDiscovery_AdvertisesRoutableAddress(t *testing.T) {
+	key, _ := crypto.GeneratePrivateKey()
+	cases := map[string]bool{
+		"10.0.0.1:3000":     true,
+		"node.example:3000": true,
+		":3000":             false,
+		"0.0.0.0:3000":      false,
+		"[::]:3000":         false,
+	}
+	for hostport, want := range cases {
+		assert.Equal(t, want, routable(NewNodeAddr(key.PublicKey(), hostport)), hostport)
+	}
+	assert.True(t, routable("a"))
+
+	// without a routable address the node only asks for addresses
+	a := NewLocalTransport("a")
+	b := NewLocalTransport("b")
+	assert.NoError(t, a.Connect(b))
+	d := NewDiscovery(a, DiscoveryOpts{})
+	d.ExternalAddr = ""
+	d.greet("b")
+	assert.IsType(t, &GetAddrMessage{}, nextPayload(t, b))
+	assertNoMessage(t, b)
+}
+
+func TestDiscovery_TracksBootstrapByPeerID(t *testing.T) {
+	a := NewLocalTransport("a")
+	b := NewLocalTransport("b")
+
+	// the bootstrap node is dialled by host:port and authenticates as b
+	var dials atomic.Int32
+	d := NewDiscovery(a, DiscoveryOpts{
+		Bootstrap: []NetAddr{"10.0.0.2:3000"},
+		Interval:  10 * time.Millisecond,
+		Dial: func(addr NetAddr) (NetAddr, error) {
+			dials.Add(1)
+			return b.Addr(), a.Connect(b)
+		},
+	})
+	startDiscovery(t, &discoveryNode{transport: a, discovery: d})
+
+	assert.Eventually(t, func() bool {
+		return len(d.OutboundPeers()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []NetAddr{"b"}, d.OutboundPeers())
+
+	// the connected bootstrap node is not dialled again
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), dials.Load())
+}
+
+func TestDiscovery_LimitsReportedAddresses(t *testing.T) {
+	a := NewLocalTransport("a")
+	d := NewDiscovery(a, DiscoveryOpts{Book: NewAddressBook(AddressBookOpts{MaxPerSource: 3})})
+	d.Book.Add("genuine", time.Now().Add(-time.Hour))
+
+	key, _ := crypto.GeneratePrivateKey()
+	now := time.Now().Unix()
+	addrs := []AddrEntry{
+		{Addr: "peer", Seen: now},
+		{Addr: "zz@10.0.0.1:3000", Seen: now},
+		{Addr: NewNodeAddr(key.PublicKey(), ":3000"), Seen: now},
+		{Addr: NewNodeAddr(key.PublicKey(), "0.0.0.0:3000"), Seen: now},
+	}
+	for i := 0; i < 10; i++ {
+		addrs = append(addrs, AddrEntry{Addr: NetAddr(fmt.Sprintf("junk%d", i)), Seen: now})
+	}
+	msg, err := NewMessage(&AddrMessage{Addrs: addrs})
+	assert.NoError(t, err)
+	assert.NoError(t, d.HandleMessage("peer", msg))
+
+	// unparseable and unroutable addresses are left out, and the peer
+	// adds no more than its share
+	assert.Equal(t, 4, d.Book.Len())
+	assert.True(t, d.Book.Known("genuine"))
+	assert.True(t, d.Book.Known("junk0"))
+	assert.True(t, d.Book.Known("junk1"))
+	assert.False(t, d.Book.Known("junk2"))
+
+	// only the peer's own address keeps the claimed time
+	for _, ka := range d.Book.All() {
+		switch ka.Addr {
+		case "peer":
+			assert.Equal(t, now, ka.Seen.Unix())
+		case "junk0", "junk1":
+			assert.LessOrEqual(t, ka.Seen.Unix(), now-int64(addrSeenPenalty/time.Second))
+		}
+	}
+}
+
+func TestDiscovery_SavesBook(t *testing.T) {
+	file := t.TempDir() + "/peers.json"
+	tr := NewLocalTransport("a")
+	d := NewDiscovery(tr, DiscoveryOpts{Book: NewAddressBook(AddressBookOpts{File: file})})
+	d.Book.Add("b", time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Start(ctx)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	book := NewAddressBook(AddressBookOpts{File: file})
+	assert.NoError(t, book.Load())
+	assert.True(t, book.Known("b"))
+}
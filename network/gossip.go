@@ -0,0 +1,366 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/majorshift/safari-chain/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAnnounceInterval = 100 * time.Millisecond
+	defaultAnnounceBatch    = 256
+	defaultAnnounceRate     = 1024 // hashes per second
+	defaultKnownTxs         = 32768
+	defaultRequestTimeout   = 5 * time.Second
+)
+
+// TxGossipOpts configures a TxGossip
+type TxGossipOpts struct {
+	AnnounceInterval time.Duration // how long new hashes are batched before being announced; defaults to 100ms
+	AnnounceBatch    int           // most hashes in a single announcement; defaults to 256
+	AnnounceRate     int           // hashes announced to a peer per second; defaults to 1024
+	KnownTxs         int           // hashes remembered per peer to avoid echoing them back; defaults to 32768
+	RequestTimeout   time.Duration // wait for a requested transaction before asking another peer; defaults to 5s
+	Logger           *logrus.Logger
+}
+
+// TxGossip spreads transactions between the mempools of connected nodes.
+// New transactions are announced by hash; peers request the ones missing
+// from their pool and announce them in turn once admitted. Every peer
+// remembers which hashes the other side knows, so nothing is echoed back
+type TxGossip struct {
+	TxGossipOpts
+	transport Transport
+	mempool   *MemPool
+	lock      sync.Mutex
+	peers     map[NetAddr]*gossipPeer
+	requested map[crypto.Hash]time.Time // in flight requests by time sent
+}
+
+// gossipPeer is the gossip state kept for a connected peer
+type gossipPeer struct {
+	known    *knownCache
+	queue    []crypto.Hash // hashes waiting to be announced
+	tokens   float64       // hashes the peer may be sent right now
+	refilled time.Time     // when tokens were last topped up
+}
+
+func NewTxGossip(transport Transport, mempool *MemPool, opts TxGossipOpts) *TxGossip {
+	if opts.AnnounceInterval == 0 {
+		opts.AnnounceInterval = defaultAnnounceInterval
+	}
+	if opts.AnnounceBatch == 0 {
+		opts.AnnounceBatch = defaultAnnounceBatch
+	}
+	if opts.AnnounceBatch > MaxInventory {
+		opts.AnnounceBatch = MaxInventory
+	}
+	if opts.AnnounceRate == 0 {
+		opts.AnnounceRate = defaultAnnounceRate
+	}
+	if opts.KnownTxs == 0 {
+		opts.KnownTxs = defaultKnownTxs
+	}
+	if opts.RequestTimeout == 0 {
+		opts.RequestTimeout = defaultRequestTimeout
+	}
+	if opts.Logger == nil {
+		opts.Logger = logrus.New()
+	}
+
+	return &TxGossip{
+		TxGossipOpts: opts,
+		transport:    transport,
+		mempool:      mempool,
+		peers:        make(map[NetAddr]*gossipPeer),
+		requested:    make(map[crypto.Hash]time.Time),
+	}
+}
+
+// Start announces the queued hashes every AnnounceInterval until ctx is cancelled
+func (g *TxGossip) Start(ctx context.Context) {
+	ticker := time.NewTicker(g.AnnounceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			g.flush(now)
+		}
+	}
+}
+
+// Announce queues hashes to be announced to every peer not known to
+// have them. Each peer queues at most MaxInventory hashes; the oldest
+// are dropped first when it cannot keep up. Queued hashes count as known
+// to the peer until they are dropped or fail to be sent
+func (g *TxGossip) Announce(hashes ...crypto.Hash) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.syncPeers(time.Now())
+	for _, peer := range g.peers {
+		for _, hash := range hashes {
+			if peer.known.contains(hash) {
+				continue
+			}
+			peer.known.add(hash)
+			peer.queue = append(peer.queue, hash)
+		}
+		if over := len(peer.queue) - MaxInventory; over > 0 {
+			peer.forget(peer.queue[:over])
+			peer.queue = append(peer.queue[:0], peer.queue[over:]...)
+		}
+	}
+}
+
+// HandleMessage processes a gossip message received from the peer from.
+// Only tx announce, tx request and txs messages are accepted
+func (g *TxGossip) HandleMessage(from NetAddr, msg *Message) error {
+	p, err := msg.DecodePayload()
+	if err != nil {
+		return err
+	}
+
+	switch p := p.(type) {
+	case *TxAnnounceMessage:
+		return g.handleAnnounce(from, p.Hashes)
+	case *TxRequestMessage:
+		return g.handleRequest(from, p.Hashes)
+	case *TxsMessage:
+		return g.handleTxs(from, p.Txs)
+	default:
+		return fmt.Errorf("tx gossip cannot handle %s message", msg.Type)
+	}
+}
+
+// handleAnnounce requests the announced transactions missing from the
+// pool, unless they were requested from another peer recently
+func (g *TxGossip) handleAnnounce(from NetAddr, hashes []crypto.Hash) error {
+	now := time.Now()
+
+	g.lock.Lock()
+	peer := g.peer(from, now)
+	var missing []crypto.Hash
+	for _, hash := range hashes {
+		peer.known.add(hash)
+		if sent, ok := g.requested[hash]; ok && now.Sub(sent) < g.RequestTimeout {
+			continue
+		}
+		if g.mempool.Contains(hash) {
+			continue
+		}
+		g.requested[hash] = now
+		missing = append(missing, hash)
+	}
+	g.lock.Unlock()
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	return g.send(from, &TxRequestMessage{Hashes: missing})
+}
+
+// handleRequest answers with the requested transactions found in the pool
+func (g *TxGossip) handleRequest(from NetAddr, hashes []crypto.Hash) error {
+	var txs []*crypto.Transaction
+	for _, hash := range hashes {
+		if tx := g.mempool.Get(hash); tx != nil {
+			txs = append(txs, tx)
+		}
+	}
+	if len(txs) == 0 {
+		return nil
+	}
+
+	g.lock.Lock()
+	peer := g.peer(from, time.Now())
+	for _, tx := range txs {
+		peer.known.add(tx.Hash(crypto.TxHash{}))
+	}
+	g.lock.Unlock()
+
+	return g.send(from, &TxsMessage{Txs: txs})
+}
+
+// handleTxs adds the received transactions to the pool and announces
+// the ones admitted to the other peers. It returns the rejection that
+// weighs the most against the sender, see Penalty
+func (g *TxGossip) handleTxs(from NetAddr, txs []*crypto.Transaction) error {
+	hashes := make([]crypto.Hash, len(txs))
+
+	g.lock.Lock()
+	peer := g.peer(from, time.Now())
+	for i, tx := range txs {
+		hashes[i] = tx.Hash(crypto.TxHash{})
+		peer.known.add(hashes[i])
+		delete(g.requested, hashes[i])
+	}
+	g.lock.Unlock()
+
+	var admitted []crypto.Hash
+	var worst error
+	for i, tx := range txs {
+		if g.mempool.Contains(hashes[i]) {
+			continue
+		}
+		if err := g.mempool.Add(tx); err != nil {
+			g.Logger.WithFields(logrus.Fields{
+				"peer": from,
+				"hash": hashes[i].ToString(),
+			}).WithError(err).Debug("gossiped transaction rejected")
+			if worst == nil || Penalty(err) > Penalty(worst) {
+				worst = err
+			}
+			continue
+		}
+		admitted = append(admitted, hashes[i])
+	}
+
+	if len(admitted) > 0 {
+		g.Announce(admitted...)
+	}
+
+	return worst
+}
+
+// flush sends every peer the next batch of its queued hashes
+// its rate limit allows and forgets expired requests
+func (g *TxGossip) flush(now time.Time) {
+	type announcement struct {
+		to     NetAddr
+		hashes []crypto.Hash
+	}
+	var batches []announcement
+
+	g.lock.Lock()
+	g.syncPeers(now)
+	for addr, peer := range g.peers {
+		peer.refill(now, g.AnnounceRate)
+
+		n := min(len(peer.queue), g.AnnounceBatch, int(peer.tokens))
+		if n == 0 {
+			continue
+		}
+		peer.tokens -= float64(n)
+
+		hashes := make([]crypto.Hash, n)
+		copy(hashes, peer.queue)
+		peer.queue = append(peer.queue[:0], peer.queue[n:]...)
+		batches = append(batches, announcement{to: addr, hashes: hashes})
+	}
+	for hash, sent := range g.requested {
+		if now.Sub(sent) >= g.RequestTimeout {
+			delete(g.requested, hash)
+		}
+	}
+	g.lock.Unlock()
+
+	for _, b := range batches {
+		if err := g.send(b.to, &TxAnnounceMessage{Hashes: b.hashes}); err != nil {
+			g.Logger.WithField("peer", b.to).WithError(err).Debug("failed to announce transactions")
+			g.lock.Lock()
+			if peer, ok := g.peers[b.to]; ok {
+				peer.forget(b.hashes)
+			}
+			g.lock.Unlock()
+		}
+	}
+}
+
+// syncPeers starts tracking newly connected peers and forgets the
+// ones that went away. Callers hold the lock
+func (g *TxGossip) syncPeers(now time.Time) {
+	connected := make(map[NetAddr]bool)
+	for _, addr := range g.transport.Peers() {
+		connected[addr] = true
+		g.peer(addr, now)
+	}
+	for addr := range g.peers {
+		if !connected[addr] {
+			delete(g.peers, addr)
+		}
+	}
+}
+
+// peer returns the state of the peer at addr, creating it if needed.
+// Callers hold the lock
+func (g *TxGossip) peer(addr NetAddr, now time.Time) *gossipPeer {
+	peer, ok := g.peers[addr]
+	if !ok {
+		peer = &gossipPeer{
+			known:    newKnownCache(g.KnownTxs),
+			tokens:   float64(g.AnnounceRate),
+			refilled: now,
+		}
+		g.peers[addr] = peer
+	}
+
+	return peer
+}
+
+func (g *TxGossip) send(to NetAddr, p Payload) error {
+	payload, err := EncodeMessage(p)
+	if err != nil {
+		return err
+	}
+
+	return g.transport.SendMessage(to, payload)
+}
+
+// refill tops up the tokens of the peer for the time elapsed since the
+// last refill, holding at most a second worth of them
+func (p *gossipPeer) refill(now time.Time, rate int) {
+	elapsed := now.Sub(p.refilled).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	p.tokens = min(p.tokens+elapsed*float64(rate), float64(rate))
+	p.refilled = now
+}
+
+// forget marks hashes as unknown to the peer again, so that they are
+// announced to it the next time they come up
+func (p *gossipPeer) forget(hashes []crypto.Hash) {
+	for _, hash := range hashes {
+		p.known.remove(hash)
+	}
+}
+
+// knownCache is a bounded set of hashes forgetting the oldest first
+type knownCache struct {
+	hashes *types.OrderedMap[crypto.Hash, struct{}]
+	max    int
+}
+
+func newKnownCache(max int) *knownCache {
+	return &knownCache{
+		hashes: types.NewOrderedMap[crypto.Hash, struct{}](),
+		max:    max,
+	}
+}
+
+func (c *knownCache) add(hash crypto.Hash) {
+	c.hashes.Set(hash, struct{}{})
+	for c.hashes.Len() > c.max {
+		oldest, _, _ := c.hashes.Oldest()
+		c.hashes.Delete(oldest)
+	}
+}
+
+func (c *knownCache) remove(hash crypto.Hash) {
+	c.hashes.Delete(hash)
+}
+
+func (c *knownCache) contains(hash crypto.Hash) bool {
+	return c.hashes.Contains(hash)
+}
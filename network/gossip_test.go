@@ -0,0 +1,230 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// helper function delivering the messages received by tr to g until the test ends
+func pumpGossip(t *testing.T, tr Transport, g *TxGossip) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case rpc := <-tr.Consume():
+				if msg, err := DecodeMessage(rpc.Payload); err == nil {
+					g.HandleMessage(rpc.From, msg)
+				}
+			}
+		}
+	}()
+}
+
+// helper function reading the next message sent to tr
+func nextPayload(t *testing.T, tr Transport) Payload {
+	select {
+	case rpc := <-tr.Consume():
+		msg, err := DecodeMessage(rpc.Payload)
+		assert.NoError(t, err)
+		p, err := msg.DecodePayload()
+		assert.NoError(t, err)
+		return p
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+		return nil
+	}
+}
+
+// helper function asserting that nothing was sent to tr
+func assertNoMessage(t *testing.T, tr Transport) {
+	select {
+	case rpc := <-tr.Consume():
+		t.Fatalf("unexpected message from %s", rpc.From)
+	default:
+	}
+}
+
+func TestTxGossip_SpreadsAcrossNodes(t *testing.T) {
+	// a - b - c in a line; c only hears about the transaction through b
+	var nodes []*TxGossip
+	var pools []*MemPool
+	var transports []*LocalTransport
+	for _, addr := range []NetAddr{"a", "b", "c"} {
+		tr := NewLocalTransport(addr)
+		pool := NewMempool(10)
+		g := NewTxGossip(tr, pool, TxGossipOpts{AnnounceInterval: 10 * time.Millisecond})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go g.Start(ctx)
+		pumpGossip(t, tr, g)
+
+		nodes = append(nodes, g)
+		pools = append(pools, pool)
+		transports = append(transports, tr)
+	}
+	assert.NoError(t, transports[0].Connect(transports[1]))
+	assert.NoError(t, transports[1].Connect(transports[2]))
+
+	tx := crypto.NewTxWithSignature([]byte("gossip"))
+	hash := tx.Hash(crypto.TxHash{})
+	assert.NoError(t, pools[0].Add(tx))
+	nodes[0].Announce(hash)
+
+	assert.Eventually(t, func() bool {
+		return pools[1].Contains(hash) && pools[2].Contains(hash)
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestTxGossip_RequestsOnlyMissingAndDoesNotEcho(t *testing.T) {
+	tr := NewLocalTransport("a")
+	peer := NewLocalTransport("b")
+	assert.NoError(t, tr.Connect(peer))
+
+	pool := NewMempool(10)
+	g := NewTxGossip(tr, pool, TxGossipOpts{})
+
+	known := crypto.NewTxWithSignature([]byte("known"))
+	unknown := crypto.NewTxWithSignature([]byte("unknown"))
+	assert.NoError(t, pool.Add(known))
+
+	// b announces both; a only asks for the one it lacks
+	hashes := []crypto.Hash{known.Hash(crypto.TxHash{}), unknown.Hash(crypto.TxHash{})}
+	assert.NoError(t, g.handleAnnounce("b", hashes))
+	assert.Equal(t, &TxRequestMessage{Hashes: hashes[1:]}, nextPayload(t, peer))
+
+	// a repeated announcement does not trigger another request
+	assert.NoError(t, g.handleAnnounce("b", hashes))
+	assertNoMessage(t, peer)
+
+	// b delivers it; a admits it but does not announce it back to b
+	g.handleTxs("b", []*crypto.Transaction{unknown})
+	assert.True(t, pool.Contains(hashes[1]))
+	g.Announce(hashes...)
+	g.flush(time.Now())
+	assertNoMessage(t, peer)
+}
+
+func TestTxGossip_AnswersRequests(t *testing.T) {
+	tr := NewLocalTransport("a")
+	peer := NewLocalTransport("b")
+	assert.NoError(t, tr.Connect(peer))
+
+	pool := NewMempool(10)
+	g := NewTxGossip(tr, pool, TxGossipOpts{})
+
+	tx := crypto.NewTxWithSignature([]byte("requested"))
+	assert.NoError(t, pool.Add(tx))
+
+	assert.NoError(t, g.handleRequest("b", []crypto.Hash{tx.Hash(crypto.TxHash{}), {9}}))
+	txs := nextPayload(t, peer).(*TxsMessage).Txs
+	assert.Len(t, txs, 1)
+	assert.Equal(t, tx.Hash(crypto.TxHash{}), txs[0].Hash(crypto.TxHash{}))
+
+	// b has the transaction now, so it is not announced to it
+	g.Announce(tx.Hash(crypto.TxHash{}))
+	g.flush(time.Now())
+	assertNoMessage(t, peer)
+}
+
+func TestTxGossip_RetriesRequestAfterTimeout(t *testing.T) {
+	tr := NewLocalTransport("a")
+	b, c := NewLocalTransport("b"), NewLocalTransport("c")
+	assert.NoError(t, tr.Connect(b))
+	assert.NoError(t, tr.Connect(c))
+
+	g := NewTxGossip(tr, NewMempool(10), TxGossipOpts{RequestTimeout: 20 * time.Millisecond})
+	hash := crypto.Hash{1}
+
+	assert.NoError(t, g.handleAnnounce("b", []crypto.Hash{hash}))
+	nextPayload(t, b)
+
+	// c announces the same hash while the request to b is in flight
+	assert.NoError(t, g.handleAnnounce("c", []crypto.Hash{hash}))
+	assertNoMessage(t, c)
+
+	// b never answers, so c is asked once the request timed out
+	time.Sleep(30 * time.Millisecond)
+	assert.NoError(t, g.handleAnnounce("c", []crypto.Hash{hash}))
+	assert.Equal(t, &TxRequestMessage{Hashes: []crypto.Hash{hash}}, nextPayload(t, c))
+}
+
+func TestTxGossip_BatchesAndRateLimits(t *testing.T) {
+	tr := NewLocalTransport("a")
+	peer := NewLocalTransport("b")
+	assert.NoError(t, tr.Connect(peer))
+
+	g := NewTxGossip(tr, NewMempool(10), TxGossipOpts{AnnounceBatch: 2, AnnounceRate: 3})
+
+	hashes := []crypto.Hash{{1}, {2}, {3}, {4}, {5}}
+	g.Announce(hashes...)
+
+	// announcing again does not queue the hashes twice
+	g.Announce(hashes...)
+
+	now := time.Now()
+	g.flush(now)
+	assert.Equal(t, &TxAnnounceMessage{Hashes: hashes[:2]}, nextPayload(t, peer))
+
+	// one token left
+	g.flush(now)
+	assert.Equal(t, &TxAnnounceMessage{Hashes: hashes[2:3]}, nextPayload(t, peer))
+	g.flush(now)
+	assertNoMessage(t, peer)
+
+	// tokens refill over time
+	g.flush(now.Add(time.Second))
+	assert.Equal(t, &TxAnnounceMessage{Hashes: hashes[3:]}, nextPayload(t, peer))
+}
+
+func TestTxGossip_ReannouncesUnsentHashes(t *testing.T) {
+	tr := NewLocalTransport("a")
+	peer := NewLocalTransport("b")
+	assert.NoError(t, tr.Connect(peer))
+
+	// the oldest hash is dropped from a full queue and can be queued again
+	g := NewTxGossip(tr, NewMempool(10), TxGossipOpts{})
+	hashes := make([]crypto.Hash, MaxInventory+1)
+	for i := range hashes {
+		hashes[i] = crypto.Hash{byte(i), byte(i >> 8), 1}
+	}
+	g.Announce(hashes...)
+	assert.Equal(t, hashes[1], g.peers["b"].queue[0])
+	g.Announce(hashes[0])
+	queue := g.peers["b"].queue
+	assert.Equal(t, hashes[0], queue[len(queue)-1])
+
+	// a hash that could not be sent is announced once the peer catches up
+	g = NewTxGossip(tr, NewMempool(10), TxGossipOpts{})
+	for i := 0; i < localTransportBuffer; i++ {
+		assert.NoError(t, tr.SendMessage("b", nil))
+	}
+	g.Announce(hashes[0])
+	g.flush(time.Now())
+	for i := 0; i < localTransportBuffer; i++ {
+		<-peer.Consume()
+	}
+
+	g.Announce(hashes[0])
+	g.flush(time.Now())
+	assert.Equal(t, &TxAnnounceMessage{Hashes: hashes[:1]}, nextPayload(t, peer))
+}
+
+func TestKnownCache_ForgetsOldest(t *testing.T) {
+	c := newKnownCache(2)
+	c.add(crypto.Hash{1})
+	c.add(crypto.Hash{2})
+	c.add(crypto.Hash{3})
+
+	assert.False(t, c.contains(crypto.Hash{1}))
+	assert.True(t, c.contains(crypto.Hash{2}))
+	assert.True(t, c.contains(crypto.Hash{3}))
+}
@@ -0,0 +1,130 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/majorshift/safari-chain/crypto"
+)
+
+// maxJournalRecord bounds the size of a journal record so that a corrupt
+// length prefix cannot make the loader allocate an arbitrary amount of memory
+const maxJournalRecord = 1 << 20
+
+// txJournal is an append-only file of local transactions that lets the
+// mempool survive restarts. Each record is a big endian uint32 length
+// followed by the gob encoding of a transaction
+type txJournal struct {
+	path   string
+	writer *os.File // append handle; nil until the journal is rotated
+}
+
+func newTxJournal(path string) *txJournal {
+	return &txJournal{path: path}
+}
+
+// load calls add for every transaction in the journal, in the order they were
+// recorded. A missing journal is not an error. Reading stops at the first
+// truncated or corrupt record, e.g. one left behind by a crash
+func (j *txJournal) load(add func(*crypto.Transaction)) error {
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil
+		}
+		if size > maxJournalRecord {
+			return nil
+		}
+
+		record := make([]byte, size)
+		if _, err := io.ReadFull(r, record); err != nil {
+			return nil
+		}
+
+		tx := &crypto.Transaction{}
+		if err := gob.NewDecoder(bytes.NewReader(record)).Decode(tx); err != nil {
+			return nil
+		}
+		add(tx)
+	}
+}
+
+// insert appends tx to the journal
+func (j *txJournal) insert(tx *crypto.Transaction) error {
+	if j.writer == nil {
+		return errors.New("journal is not open")
+	}
+
+	return writeJournalRecord(j.writer, tx)
+}
+
+// rotate replaces the journal with one holding only txs
+// and reopens it for appending
+func (j *txJournal) rotate(txs []*crypto.Transaction) error {
+	if j.writer != nil {
+		j.writer.Close()
+		j.writer = nil
+	}
+
+	tmp := j.path + ".new"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	for _, tx := range txs {
+		if err := writeJournalRecord(f, tx); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+
+	j.writer, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// close releases the append handle
+func (j *txJournal) close() error {
+	if j.writer == nil {
+		return nil
+	}
+
+	err := j.writer.Close()
+	j.writer = nil
+	return err
+}
+
+// writeJournalRecord writes tx to w as a length prefixed gob record
+func writeJournalRecord(w io.Writer, tx *crypto.Transaction) error {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(tx); err != nil {
+		return err
+	}
+
+	record := make([]byte, 4+buf.Len())
+	binary.BigEndian.PutUint32(record, uint32(buf.Len()))
+	copy(record[4:], buf.Bytes())
+
+	_, err := w.Write(record)
+	return err
+}
@@ -0,0 +1,150 @@
+package network
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal_RestoresLocalTransactions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.journal")
+	sender, _ := crypto.GeneratePrivateKey()
+
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 10, Journal: path})
+	loaded, dropped, err := p.LoadJournal()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, loaded+dropped)
+
+	local0 := newFeeTx(t, sender, 0, 1)
+	local1 := newFeeTx(t, sender, 1, 1)
+	assert.NoError(t, p.AddLocal(local0))
+	assert.NoError(t, p.AddLocal(local1))
+	// relayed transactions are not journaled
+	assert.NoError(t, p.Add(crypto.NewTxWithSignature([]byte("remote"))))
+
+	// restart
+	restarted := NewMempoolWithOpts(MempoolOpts{MaxSize: 10, Journal: path})
+	loaded, dropped, err = restarted.LoadJournal()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, loaded)
+	assert.Equal(t, 0, dropped)
+	assert.Equal(t, []*crypto.Transaction{local0, local1}, restarted.GetPendingTx())
+}
+
+func TestJournal_RecordsLocalsAddedBeforeLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.journal")
+	sender, _ := crypto.GeneratePrivateKey()
+
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 10, Journal: path})
+	local := newFeeTx(t, sender, 0, 1)
+	assert.NoError(t, p.AddLocal(local))
+	_, _, err := p.LoadJournal()
+	assert.NoError(t, err)
+
+	restarted := NewMempoolWithOpts(MempoolOpts{MaxSize: 10, Journal: path})
+	loaded, _, err := restarted.LoadJournal()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+	assert.Equal(t, []*crypto.Transaction{local}, restarted.GetPendingTx())
+}
+
+func TestJournal_WarnsAboutFailedWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.journal")
+	sender, _ := crypto.GeneratePrivateKey()
+	logger, hook := test.NewNullLogger()
+
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 10, Journal: path, Logger: logger})
+	_, _, err := p.LoadJournal()
+	assert.NoError(t, err)
+	p.journal.writer.Close()
+
+	// the transaction is pooled even though it could not be journaled
+	assert.NoError(t, p.AddLocal(newFeeTx(t, sender, 0, 1)))
+	assert.Equal(t, 1, p.PendingTxCount())
+	if assert.NotNil(t, hook.LastEntry()) {
+		assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	}
+}
+
+func TestJournal_DropsInvalidEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.journal")
+	sender, _ := crypto.GeneratePrivateKey()
+	chain := newTestChain(t, fundedConfig(100, sender))
+
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 10, Journal: path})
+	_, _, err := p.LoadJournal()
+	assert.NoError(t, err)
+
+	included := newTransferTx(t, sender, 0, 1)
+	pending := newTransferTx(t, sender, 1, 1)
+	assert.NoError(t, p.AddLocal(included))
+	assert.NoError(t, p.AddLocal(pending))
+
+	// the first transaction gets included while the node is down
+	assert.NoError(t, chain.AddBlock(nextBlock(t, chain, 0, included)))
+
+	restarted := NewMempoolWithOpts(MempoolOpts{MaxSize: 10, Journal: path, State: chain})
+	loaded, dropped, err := restarted.LoadJournal()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+	assert.Equal(t, 1, dropped)
+	assert.Equal(t, []*crypto.Transaction{pending}, restarted.GetPendingTx())
+}
+
+func TestJournal_ToleratesTruncatedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.journal")
+
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 10, Journal: path})
+	_, _, err := p.LoadJournal()
+	assert.NoError(t, err)
+	assert.NoError(t, p.AddLocal(crypto.NewTxWithSignature([]byte("complete"))))
+
+	// simulate a crash in the middle of writing a record
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	f.Write([]byte{0, 0, 1, 0, 42})
+	f.Close()
+
+	restarted := NewMempoolWithOpts(MempoolOpts{MaxSize: 10, Journal: path})
+	loaded, _, err := restarted.LoadJournal()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+}
+
+func TestJournal_RotationKeepsOnlyPooledLocals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.journal")
+
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 10, Journal: path, Rejournal: time.Millisecond})
+	_, _, err := p.LoadJournal()
+	assert.NoError(t, err)
+
+	kept := crypto.NewTxWithSignature([]byte("kept"))
+	removed := crypto.NewTxWithSignature([]byte("removed"))
+	assert.NoError(t, p.AddLocal(kept))
+	assert.NoError(t, p.AddLocal(removed))
+	p.Remove(removed.Hash(crypto.TxHash{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.StartJournal(ctx)
+		close(done)
+	}()
+
+	// wait for at least one rotation
+	assert.Eventually(t, func() bool {
+		var count int
+		newTxJournal(path).load(func(*crypto.Transaction) { count++ })
+		return count == 1
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,137 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const localTransportBuffer = 1024
+
+// ErrPeerBufferFull is returned when a local peer has not consumed the
+// messages sent to it yet
+var ErrPeerBufferFull = errors.New("peer receive buffer full")
+
+// LocalTransport is an in-memory Transport that connects nodes living in
+// the same process. Messages are delivered in the order they are sent,
+// which makes multi-node behaviour deterministic to test
+type LocalTransport struct {
+	addr      NetAddr
+	consumeCh chan RPC
+	lock      sync.RWMutex
+	peers     map[NetAddr]*LocalTransport
+}
+
+func NewLocalTransport(addr NetAddr) *LocalTransport {
+	return &LocalTransport{
+		addr:      addr,
+		consumeCh: make(chan RPC, localTransportBuffer),
+		peers:     make(map[NetAddr]*LocalTransport),
+	}
+}
+
+// Consume returns the channel delivering messages received from peers
+func (t *LocalTransport) Consume() <-chan RPC {
+	return t.consumeCh
+}
+
+// Connect links t and tr in both directions. tr must be a *LocalTransport
+func (t *LocalTransport) Connect(tr Transport) error {
+	peer, ok := tr.(*LocalTransport)
+	if !ok {
+		return fmt.Errorf("cannot connect local transport to %T", tr)
+	}
+	if peer == t {
+		return fmt.Errorf("cannot connect transport %s to itself", t.addr)
+	}
+
+	t.addPeer(peer)
+	peer.addPeer(t)
+
+	return nil
+}
+
+// SendMessage delivers payload to the peer at addr. It fails instead of
+// blocking when the peer's consume buffer is full, so that two nodes
+// answering each other's messages cannot wait on one another forever
+func (t *LocalTransport) SendMessage(addr NetAddr, payload []byte) error {
+	t.lock.RLock()
+	peer, ok := t.peers[addr]
+	t.lock.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("%s: could not send message to unknown peer %s", t.addr, addr)
+	}
+
+	// copy so that neither side can modify the message seen by the other
+	msg := make([]byte, len(payload))
+	copy(msg, payload)
+
+	select {
+	case peer.consumeCh <- RPC{From: t.addr, Payload: msg}:
+		return nil
+	default:
+		return fmt.Errorf("%s: sending to %s: %w", t.addr, addr, ErrPeerBufferFull)
+	}
+}
+
+// Disconnect unlinks t and the peer at addr in both directions
+func (t *LocalTransport) Disconnect(addr NetAddr) error {
+	t.lock.Lock()
+	peer, ok := t.peers[addr]
+	delete(t.peers, addr)
+	t.lock.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%s: could not disconnect unknown peer %s", t.addr, addr)
+	}
+
+	peer.lock.Lock()
+	delete(peer.peers, t.addr)
+	peer.lock.Unlock()
+
+	return nil
+}
+
+// Broadcast sends payload to every connected peer, in address order. It
+// tries all of them and returns the first error encountered
+func (t *LocalTransport) Broadcast(payload []byte) error {
+	var firstErr error
+	for _, addr := range t.Peers() {
+		if err := t.SendMessage(addr, payload); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Addr returns the address of the transport
+func (t *LocalTransport) Addr() NetAddr {
+	return t.addr
+}
+
+// Peers returns the addresses of the connected peers in sorted order
+func (t *LocalTransport) Peers() []NetAddr {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	addrs := make([]NetAddr, 0, len(t.peers))
+	for addr := range t.peers {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i] < addrs[j]
+	})
+
+	return addrs
+}
+
+// addPeer registers peer as connected
+func (t *LocalTransport) addPeer(peer *LocalTransport) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.peers[peer.addr] = peer
+}
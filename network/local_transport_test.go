@@ -0,0 +1,97 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalTransport_Connect(t *testing.T) {
+	a := NewLocalTransport("A")
+	b := NewLocalTransport("B")
+
+	assert.NoError(t, a.Connect(b))
+	assert.Error(t, a.Connect(a))
+
+	// connections are bidirectional
+	assert.Equal(t, []NetAddr{"B"}, a.Peers())
+	assert.Equal(t, []NetAddr{"A"}, b.Peers())
+}
+
+func TestLocalTransport_SendMessage(t *testing.T) {
+	a := NewLocalTransport("A")
+	b := NewLocalTransport("B")
+	assert.NoError(t, a.Connect(b))
+
+	msg := []byte("hello world")
+	assert.NoError(t, a.SendMessage(b.Addr(), msg))
+	assert.NoError(t, b.SendMessage(a.Addr(), []byte("hello back")))
+
+	rpc := <-b.Consume()
+	assert.Equal(t, NetAddr("A"), rpc.From)
+	assert.Equal(t, msg, rpc.Payload)
+
+	// the receiver gets its own copy of the payload
+	msg[0] = 'j'
+	assert.Equal(t, []byte("hello world"), rpc.Payload)
+
+	rpc = <-a.Consume()
+	assert.Equal(t, NetAddr("B"), rpc.From)
+	assert.Equal(t, []byte("hello back"), rpc.Payload)
+
+	// unknown peers are an error
+	assert.Error(t, a.SendMessage("C", msg))
+}
+
+func TestLocalTransport_Broadcast(t *testing.T) {
+	a := NewLocalTransport("A")
+	b := NewLocalTransport("B")
+	c := NewLocalTransport("C")
+	assert.NoError(t, a.Connect(b))
+	assert.NoError(t, a.Connect(c))
+
+	assert.NoError(t, a.Broadcast([]byte("hello everyone")))
+
+	for _, tr := range []*LocalTransport{b, c} {
+		rpc := <-tr.Consume()
+		assert.Equal(t, NetAddr("A"), rpc.From)
+		assert.Equal(t, []byte("hello everyone"), rpc.Payload)
+	}
+}
+
+func TestLocalTransport_Disconnect(t *testing.T) {
+	a := NewLocalTransport("A")
+	b := NewLocalTransport("B")
+	assert.NoError(t, a.Connect(b))
+
+	assert.NoError(t, b.Disconnect("A"))
+	assert.Empty(t, a.Peers())
+	assert.Empty(t, b.Peers())
+	assert.Error(t, a.SendMessage("B", []byte("hello")))
+	assert.Error(t, a.Disconnect("B"))
+}
+
+func TestLocalTransport_FullBufferDoesNotBlock(t *testing.T) {
+	a := NewLocalTransport("A")
+	b := NewLocalTransport("B")
+	c := NewLocalTransport("C")
+	assert.NoError(t, a.Connect(b))
+	assert.NoError(t, a.Connect(c))
+
+	// neither node reads while both fill the other's buffer
+	for i := 0; i < localTransportBuffer; i++ {
+		assert.NoError(t, a.SendMessage(b.Addr(), []byte("ping")))
+		assert.NoError(t, b.SendMessage(a.Addr(), []byte("pong")))
+	}
+	assert.ErrorIs(t, a.SendMessage(b.Addr(), []byte("ping")), ErrPeerBufferFull)
+	assert.ErrorIs(t, b.SendMessage(a.Addr(), []byte("pong")), ErrPeerBufferFull)
+
+	// a broadcast still reaches the peers with room
+	assert.ErrorIs(t, a.Broadcast([]byte("hello")), ErrPeerBufferFull)
+	rpc := <-c.Consume()
+	assert.Equal(t, []byte("hello"), rpc.Payload)
+
+	// consuming makes room again
+	<-b.Consume()
+	assert.NoError(t, a.SendMessage(b.Addr(), []byte("ping")))
+}
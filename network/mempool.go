@@ -1,100 +1,672 @@
 package network
 
 import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
 	"github.com/majorshift/safari-chain/crypto"
+	"github.com/majorshift/safari-chain/events"
 	"github.com/majorshift/safari-chain/types"
-	"sync"
+	"github.com/sirupsen/logrus"
 )
 
-// MemPool is the structure for the mempool
+// StateReader gives the mempool access to the confirmed chain state
+// that pooled transactions are checked against
+type StateReader interface {
+	Account(*crypto.PublicKey) crypto.Account
+	GetBlockchainHeight() uint32
+}
+
+// EvictionReason tells why a transaction left the mempool without being included
+type EvictionReason int
+
+const (
+	EvictedUnderpriced  EvictionReason = iota // pushed out of a full pool by a better paying transaction
+	EvictedReplaced                           // replaced by a transaction with the same nonce and a higher fee
+	EvictedNonceUsed                          // its nonce was used by a transaction included in a block
+	EvictedUnaffordable                       // the sender can no longer pay for it
+	EvictedExpired                            // the chain passed its expiry height
+	EvictedTTL                                // it stayed in the pool longer than the TTL
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictedUnderpriced:
+		return "underpriced"
+	case EvictedReplaced:
+		return "replaced"
+	case EvictedNonceUsed:
+		return "nonce used"
+	case EvictedUnaffordable:
+		return "unaffordable"
+	case EvictedExpired:
+		return "expired"
+	case EvictedTTL:
+		return "ttl"
+	default:
+		return "unknown"
+	}
+}
+
+// TxAdmitted is published on the mempool bus for every transaction
+// entering the pool through Add or AddLocal
+type TxAdmitted struct {
+	Tx    *crypto.Transaction
+	Local bool // submitted to this node rather than relayed by a peer
+}
+
+// TxEvicted is published on the mempool bus for every transaction evicted
+// from the pool
+type TxEvicted struct {
+	Tx     *crypto.Transaction
+	Reason EvictionReason
+}
+
+const (
+	defaultPriceBump      = 10 // percent
+	defaultMaxSenderSlots = 16
+	defaultRejournal      = time.Hour
+)
+
+var (
+	// ErrTxUnderpriced is returned when the mempool is full and a transaction
+	// does not pay a higher fee rate than the cheapest one in the pool
+	ErrTxUnderpriced = errors.New("transaction fee rate too low for a full mempool")
+	// ErrReplaceUnderpriced is returned when a transaction reuses the nonce of a
+	// pooled transaction without raising the fee by at least the price bump
+	ErrReplaceUnderpriced = errors.New("replacement transaction underpriced")
+	// ErrSenderSlotsFull is returned when the sender already has the maximum
+	// number of transactions in the pool
+	ErrSenderSlotsFull = errors.New("sender has too many transactions in the mempool")
+	// ErrNonceTooLow is returned when the nonce of a transaction has already been used
+	ErrNonceTooLow = errors.New("transaction nonce too low")
+)
+
+// MempoolOpts configures a MemPool
+type MempoolOpts struct {
+	MaxSize        int           // maximum number of transactions in the pool
+	PriceBump      uint64        // minimum fee increase, in percent, for a same-nonce replacement; defaults to 10
+	MaxSenderSlots int           // maximum number of transactions per sender; defaults to 16
+	State          StateReader   // confirmed chain state; nonces start at 0 when unset
+	TTL            time.Duration // time a transaction may stay in the pool; 0 keeps it until evicted otherwise
+	ChainID        uint32        // chain id transactions must be signed for
+	MaxTxSize      int           // maximum transaction size in bytes; defaults to crypto.DefaultParams
+	// Checks is the stateless admission pipeline; defaults to DefaultAdmissionChecks
+	Checks []AdmissionCheck
+	// Policies are custom checks run after Checks
+	Policies []AdmissionCheck
+	// Journal is the file local transactions are recorded to so they survive
+	// restarts; empty disables journaling
+	Journal   string
+	Rejournal time.Duration // interval between journal rotations; defaults to 1h
+	// Bus is where TxAdmitted and TxEvicted events are published; defaults
+	// to a bus of the pool's own. Nodes share the bus of their blockchain
+	Bus    *events.Bus
+	Logger *logrus.Logger
+}
+
+// MemPool is the structure for the mempool. Transactions are grouped per
+// sender and split into executable ones, whose nonces follow the sender's
+// confirmed nonce without gaps, and future ones that wait for a gap to fill.
+// It is safe for concurrent use: every operation holds the pool lock so
+// admission, eviction and snapshots see all indexes in a consistent state
 type MemPool struct {
-	allTransactions     *TxMap // Stores all transactions in the pool
-	pendingTransactions *TxMap // Stores only pending transactions
-	maxSize             int    // Maximum number of transactions in the pool
+	MempoolOpts
+	lock                sync.RWMutex              // Guards the pool as a whole; held across check and update
+	allTransactions     *TxMap                    // Stores all transactions in the pool
+	pendingTransactions *TxMap                    // Stores only executable transactions
+	priced              *txHeap                   // Orders all transactions by fee rate for eviction
+	senders             map[string]*senderQueue   // Transactions of each sender by nonce
+	arrivals            map[crypto.Hash]time.Time // Time each transaction entered the pool, for the TTL
+	locals              map[crypto.Hash]bool      // Transactions submitted to this node rather than relayed
+	journal             *txJournal                // Records local transactions; nil when disabled
 }
 
 func NewMempool(maxLength int) *MemPool {
+	return NewMempoolWithOpts(MempoolOpts{MaxSize: maxLength})
+}
+
+// NewMempoolWithOpts creates a mempool configured by opts
+func NewMempoolWithOpts(opts MempoolOpts) *MemPool {
+	if opts.PriceBump == 0 {
+		opts.PriceBump = defaultPriceBump
+	}
+	if opts.MaxSenderSlots == 0 {
+		opts.MaxSenderSlots = defaultMaxSenderSlots
+	}
+	if opts.MaxTxSize == 0 {
+		opts.MaxTxSize = crypto.DefaultParams().MaxTxSize
+	}
+	if opts.Checks == nil {
+		opts.Checks = DefaultAdmissionChecks(opts.ChainID, opts.MaxTxSize)
+	}
+	if opts.Rejournal == 0 {
+		opts.Rejournal = defaultRejournal
+	}
+	if opts.Bus == nil {
+		opts.Bus = events.NewBus(events.BusOpts{})
+	}
+	if opts.Logger == nil {
+		opts.Logger = logrus.New()
+	}
+
+	var journal *txJournal
+	if opts.Journal != "" {
+		journal = newTxJournal(opts.Journal)
+	}
+
 	return &MemPool{
+		MempoolOpts:         opts,
 		allTransactions:     NewTxMap(),
 		pendingTransactions: NewTxMap(),
-		maxSize:             maxLength,
+		priced:              newTxHeap(),
+		senders:             make(map[string]*senderQueue),
+		arrivals:            make(map[crypto.Hash]time.Time),
+		locals:              make(map[crypto.Hash]bool),
+		journal:             journal,
+	}
+}
+
+// Add inserts a new transaction to the mempool once it passed the admission
+// pipeline: the stateless Checks and Policies, then the nonce, expiry and
+// balance checks against the confirmed state. Rejections are returned as a
+// *RejectError telling which stage refused the transaction.
+// A transaction reusing the nonce of a pooled one replaces it if it raises
+// the fee by at least PriceBump percent. Otherwise, when the pool is full, the
+// transaction paying the lowest fee rate is evicted to make room, unless the
+// new transaction does not pay more, in which case ErrTxUnderpriced is returned
+func (p *MemPool) Add(tx *crypto.Transaction) error {
+	// stateless checks, signature verification in particular,
+	// run outside the lock so admissions can proceed in parallel
+	if err := p.runChecks(tx); err != nil {
+		return err
 	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.admit(tx, false)
 }
 
-// Add inserts a new transaction to the mempool
-func (p *MemPool) Add(tx *crypto.Transaction) {
-	// prune the oldest transaction that is sitting in the allTransactions pool
-	if p.allTransactions.Count() == p.maxSize {
-		oldest := p.allTransactions.First()
-		p.allTransactions.Remove(oldest.Hash(crypto.TxHash{}))
+// AddLocal adds a transaction submitted to this node, e.g. through RPC,
+// rather than relayed by a peer. Local transactions are recorded in the
+// journal, when one is configured, so that they survive a restart. The
+// journal is opened by LoadJournal; transactions added before it are
+// written when LoadJournal rotates the journal
+func (p *MemPool) AddLocal(tx *crypto.Transaction) error {
+	if err := p.runChecks(tx); err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err := p.admit(tx, true); err != nil {
+		return err
 	}
 
+	hash := tx.Hash(crypto.TxHash{})
+	if !p.locals[hash] {
+		p.locals[hash] = true
+		if p.journal != nil && p.journal.writer != nil {
+			// a failed write only means the transaction is not restored after a restart
+			if err := p.journal.insert(tx); err != nil {
+				p.Logger.WithField("hash", hash.ToString()).WithError(err).Warn("failed to journal local transaction")
+			}
+		}
+	}
+
+	return nil
+}
+
+// LoadJournal replays the journal through the admission pipeline, dropping
+// entries that are no longer valid, then compacts it and opens it to record
+// new local transactions. It does nothing if no Journal is configured
+func (p *MemPool) LoadJournal() (loaded, dropped int, err error) {
+	if p.journal == nil {
+		return 0, 0, nil
+	}
+
+	var txs []*crypto.Transaction
+	if err := p.journal.load(func(tx *crypto.Transaction) {
+		txs = append(txs, tx)
+	}); err != nil {
+		return 0, 0, err
+	}
+
+	for _, tx := range txs {
+		if err := p.AddLocal(tx); err != nil {
+			dropped++
+			continue
+		}
+		loaded++
+	}
+
+	return loaded, dropped, p.rotateJournal()
+}
+
+// StartJournal rotates the journal every Rejournal, keeping only the local
+// transactions still in the pool, until ctx is cancelled. The journal is
+// closed on return. LoadJournal must have been called first
+func (p *MemPool) StartJournal(ctx context.Context) {
+	if p.journal == nil {
+		return
+	}
+
+	ticker := time.NewTicker(p.Rejournal)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			p.lock.Lock()
+			p.journal.close()
+			p.lock.Unlock()
+			return
+		case <-ticker.C:
+			if err := p.rotateJournal(); err != nil {
+				p.Logger.WithError(err).Warn("failed to rotate transaction journal")
+			}
+		}
+	}
+}
+
+// rotateJournal rewrites the journal with the local transactions in the pool
+func (p *MemPool) rotateJournal() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var locals []*crypto.Transaction
+	p.allTransactions.Range(func(h crypto.Hash, tx *crypto.Transaction) bool {
+		if p.locals[h] {
+			locals = append(locals, tx)
+		}
+		return true
+	})
+
+	return p.journal.rotate(locals)
+}
+
+// admit adds tx and publishes a TxAdmitted event if it entered the pool,
+// which it does not when it is rejected or already pooled.
+// The caller must hold the lock
+func (p *MemPool) admit(tx *crypto.Transaction, local bool) error {
+	if p.allTransactions.Contains(tx.Hash(crypto.TxHash{})) {
+		return nil
+	}
+	if err := p.add(tx); err != nil {
+		return err
+	}
+
+	p.Bus.Publish(TxAdmitted{Tx: tx, Local: local})
+	return nil
+}
+
+// add admits tx, which already passed the stateless checks. The caller must hold the lock
+func (p *MemPool) add(tx *crypto.Transaction) error {
+	hash := tx.Hash(crypto.TxHash{})
+
 	// prevent duplicate inclusion of transactions to mempool
-	if !p.allTransactions.Contains(tx.Hash(crypto.TxHash{})) {
-		p.allTransactions.Add(tx)
-		p.pendingTransactions.Add(tx)
+	if p.allTransactions.Contains(hash) {
+		return nil
+	}
+
+	if err := p.checkState(tx); err != nil {
+		return err
+	}
+
+	queue := p.senders[tx.SenderKey()]
+	if queue != nil {
+		if old, ok := queue.txs[tx.Nonce]; ok {
+			return p.replace(queue, old, tx)
+		}
+		if len(queue.txs) >= p.MaxSenderSlots {
+			return reject(tx, RejectSenderSlotsFull, ErrSenderSlotsFull)
+		}
+	}
+
+	// make room by evicting the cheapest transaction sitting in the pool
+	if p.allTransactions.Count() >= p.MaxSize {
+		cheapest := p.priced.peek()
+		if cheapest == nil || compareFeeRate(tx, cheapest) <= 0 {
+			return reject(tx, RejectUnderpriced, ErrTxUnderpriced)
+		}
+		p.evict(cheapest, EvictedUnderpriced)
+	}
+
+	p.insert(tx)
+	p.promote(tx.SenderKey())
+
+	return nil
+}
+
+// replace swaps old for tx, which uses the same nonce, if tx pays enough more
+func (p *MemPool) replace(queue *senderQueue, old, tx *crypto.Transaction) error {
+	// fee * (100 + bump) / 100 without overflowing
+	bump := old.Fee / 100 * p.PriceBump
+	bump += old.Fee % 100 * p.PriceBump / 100
+	if tx.Fee <= old.Fee || tx.Fee-old.Fee < bump {
+		return reject(tx, RejectReplaceUnderpriced, ErrReplaceUnderpriced)
 	}
+
+	p.evict(old, EvictedReplaced)
+	p.insert(tx)
+	p.promote(tx.SenderKey())
+
+	return nil
 }
 
 // Contains checks if a transaction already exists in the mempool
 func (p *MemPool) Contains(hash crypto.Hash) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
 	return p.allTransactions.Contains(hash)
 }
 
-// GetPendingTx returns a slice of transactions that are in the pending pool
+// Get returns the pooled transaction matching hash or nil if there is none
+func (p *MemPool) Get(hash crypto.Hash) *crypto.Transaction {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.allTransactions.Get(hash)
+}
+
+// IsPending tells whether the transaction matching hash is pooled and executable
+func (p *MemPool) IsPending(hash crypto.Hash) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.pendingTransactions.Contains(hash)
+}
+
+// GetPendingTx returns the executable transactions by decreasing fee rate,
+// keeping the transactions of each sender in nonce order.
+// The returned slice is a copy owned by the caller
 func (p *MemPool) GetPendingTx() []*crypto.Transaction {
-	return p.pendingTransactions.transactionList.Data
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return sortByPriority(p.pendingTransactions.List())
 }
 
-// ClearPendingList deletes all transactions in the pending list of the mempool
+// GetAllTx returns every pooled transaction, executable or not, in
+// arrival order. The returned slice is a copy owned by the caller
+func (p *MemPool) GetAllTx() []*crypto.Transaction {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.allTransactions.List()
+}
+
+// ClearPendingList deletes all executable transactions from the mempool
 func (p *MemPool) ClearPendingList() {
-	p.pendingTransactions.Clear()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, tx := range p.pendingTransactions.List() {
+		p.remove(tx)
+	}
+}
+
+// Remove deletes the transactions matching the given hashes from the
+// mempool, e.g. once they have been included in a block
+func (p *MemPool) Remove(hashes ...crypto.Hash) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, h := range hashes {
+		if tx := p.allTransactions.Get(h); tx != nil {
+			p.remove(tx)
+			p.promote(tx.SenderKey())
+		}
+	}
+}
+
+// insert adds tx to the pool as a future transaction;
+// promote decides whether it is executable.
+// This and the other unexported helpers expect the caller to hold the lock
+func (p *MemPool) insert(tx *crypto.Transaction) {
+	key := tx.SenderKey()
+	queue, ok := p.senders[key]
+	if !ok {
+		queue = newSenderQueue(tx.From)
+		p.senders[key] = queue
+	}
+
+	queue.txs[tx.Nonce] = tx
+	p.allTransactions.Add(tx)
+	p.priced.push(tx)
+	p.arrivals[tx.Hash(crypto.TxHash{})] = time.Now()
+}
+
+// remove deletes tx from every index of the pool
+func (p *MemPool) remove(tx *crypto.Transaction) {
+	hash := tx.Hash(crypto.TxHash{})
+	key := tx.SenderKey()
+	if queue, ok := p.senders[key]; ok {
+		delete(queue.txs, tx.Nonce)
+		if len(queue.txs) == 0 {
+			delete(p.senders, key)
+		}
+	}
+
+	p.allTransactions.Remove(hash)
+	p.pendingTransactions.Remove(hash)
+	p.priced.remove(hash)
+	delete(p.arrivals, hash)
+	delete(p.locals, hash)
+}
+
+// evict removes tx, re-splits its sender's remaining transactions
+// and reports the eviction
+func (p *MemPool) evict(tx *crypto.Transaction, reason EvictionReason) {
+	p.remove(tx)
+	p.promote(tx.SenderKey())
+
+	p.Bus.Publish(TxEvicted{Tx: tx, Reason: reason})
+}
+
+// promote marks the transactions of a sender whose nonces follow the confirmed
+// nonce without gaps as executable and every later one as future
+func (p *MemPool) promote(key string) {
+	queue, ok := p.senders[key]
+	if !ok {
+		return
+	}
+
+	next := p.accountNonce(queue.from)
+	for _, tx := range queue.sorted() {
+		if tx.Nonce == next {
+			p.pendingTransactions.Add(tx)
+			next++
+		} else {
+			p.pendingTransactions.Remove(tx.Hash(crypto.TxHash{}))
+		}
+	}
+}
+
+// accountNonce returns the confirmed nonce of the owner of pub
+func (p *MemPool) accountNonce(pub *crypto.PublicKey) uint64 {
+	if p.State == nil || pub == nil {
+		return 0
+	}
+
+	return p.State.Account(pub).Nonce
+}
+
+// nextHeight returns the height of the next block to be added to the chain
+func (p *MemPool) nextHeight() uint32 {
+	if p.State == nil {
+		return 0
+	}
+
+	return p.State.GetBlockchainHeight() + 1
+}
+
+// StartJanitor evicts transactions older than the TTL every interval
+// until ctx is cancelled. It returns immediately if no TTL is set
+func (p *MemPool) StartJanitor(ctx context.Context, interval time.Duration) {
+	if p.TTL == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.evictStale(now)
+		}
+	}
+}
+
+// evictStale evicts every transaction that entered the pool more than TTL before now.
+// The pool keeps transactions in arrival order so it stops at the first fresh one
+func (p *MemPool) evictStale(now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var stale []*crypto.Transaction
+	p.allTransactions.Range(func(h crypto.Hash, tx *crypto.Transaction) bool {
+		if arrival, ok := p.arrivals[h]; ok && now.Sub(arrival) < p.TTL {
+			return false
+		}
+		stale = append(stale, tx)
+		return true
+	})
+
+	for _, tx := range stale {
+		p.evict(tx, EvictedTTL)
+	}
+}
+
+// SubscribeChain keeps the mempool in sync with bc by resetting it
+// after every block that is added or reorganised
+func (p *MemPool) SubscribeChain(bc *crypto.Blockchain) {
+	p.lock.Lock()
+	p.State = bc
+	p.lock.Unlock()
+
+	bc.Subscribe(func(ev crypto.ChainEvent) {
+		p.Reset(ev, bc)
+	})
+}
+
+// Reset updates the mempool after the chain changed. Transactions included
+// in the added blocks leave the pool, transactions of detached blocks that
+// were not included again are re-inserted and every transaction is checked
+// against the new state; those that became invalid are evicted
+func (p *MemPool) Reset(ev crypto.ChainEvent, state StateReader) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.State = state
+
+	included := make(map[crypto.Hash]bool)
+	for _, b := range ev.Added {
+		for _, tx := range b.Transactions {
+			hash := tx.Hash(crypto.TxHash{})
+			included[hash] = true
+			if pooled := p.allTransactions.Get(hash); pooled != nil {
+				p.remove(pooled)
+			}
+		}
+	}
+
+	for _, b := range ev.Detached {
+		for _, tx := range b.Transactions {
+			if included[tx.Hash(crypto.TxHash{})] {
+				continue
+			}
+			p.admit(tx, false)
+		}
+	}
+
+	p.revalidate()
+}
+
+// revalidate evicts transactions whose nonce has already been used, that
+// have expired or whose sender can no longer pay for them, then re-splits
+// every sender's transactions into executable and future ones. A sender's
+// transactions are paid for in nonce order so a later one is evicted before
+// an earlier one
+func (p *MemPool) revalidate() {
+	height := p.nextHeight()
+	for key, queue := range p.senders {
+		account := p.State.Account(queue.from)
+		balance := account.Balance
+		for _, tx := range queue.sorted() {
+			switch {
+			case tx.Nonce < account.Nonce:
+				p.evict(tx, EvictedNonceUsed)
+			case tx.Expired(height):
+				p.evict(tx, EvictedExpired)
+			case tx.Cost() > balance:
+				p.evict(tx, EvictedUnaffordable)
+			default:
+				balance -= tx.Cost()
+			}
+		}
+
+		p.promote(key)
+	}
 }
 
 // PendingTxCount returns the number of transactions in the pending list
 func (p *MemPool) PendingTxCount() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
 	return p.pendingTransactions.Count()
 }
 
 // AllTxCount returns the number of transactions in ever handled by the mempool
 func (p *MemPool) AllTxCount() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
 	return p.allTransactions.Count()
 }
 
 // TxMap The TxMap struct acts as a thread-safe transaction storage
 // with both fast lookup and ordered access capabilities.
-// It efficiently manages transactions by combining a hashmap
-// for quick retrieval and a list for ordered storage while ensuring
+// It keeps transactions in an ordered map: a hashmap indexing the nodes
+// of a doubly linked list, so adding, removing, looking up and reading the
+// oldest transaction are O(1) whatever the pool size, while ensuring
 // safe concurrent access using a read-write mutex.
 type TxMap struct {
 	// Lock for concurrent access
 	lock sync.RWMutex
-	// Quick lookup for transactions by hash
-	transactionsByHash map[crypto.Hash]*crypto.Transaction
-	// Ordered list of transactions
-	transactionList *types.List[*crypto.Transaction]
+	// Transactions by hash, in insertion order
+	transactions *types.OrderedMap[crypto.Hash, *crypto.Transaction]
 }
 
 func NewTxMap() *TxMap {
 	return &TxMap{
-		transactionsByHash: make(map[crypto.Hash]*crypto.Transaction),
-		transactionList:    types.NewList[*crypto.Transaction](),
+		transactions: types.NewOrderedMap[crypto.Hash, *crypto.Transaction](),
 	}
 }
 
-// First returns the first transaction in the pool
+// First returns the first transaction in the pool or nil if it is empty
 func (t *TxMap) First() *crypto.Transaction {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
-	first := t.transactionList.Get(0)
-	return t.transactionsByHash[first.Hash(crypto.TxHash{})]
+	_, first, _ := t.transactions.Oldest()
+	return first
 }
 
 // Get returns transaction in the pool matching hash
 func (t *TxMap) Get(h crypto.Hash) *crypto.Transaction {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
-	return t.transactionsByHash[h]
+	tx, _ := t.transactions.Get(h)
+	return tx
 }
 
 // Add inserts a new transaction to the pool
@@ -103,9 +675,8 @@ func (t *TxMap) Add(tx *crypto.Transaction) {
 
 	t.lock.Lock()
 	defer t.lock.Unlock()
-	if _, ok := t.transactionsByHash[hash]; !ok {
-		t.transactionsByHash[hash] = tx
-		t.transactionList.Insert(tx)
+	if !t.transactions.Contains(hash) {
+		t.transactions.Set(hash, tx)
 	}
 }
 
@@ -113,29 +684,41 @@ func (t *TxMap) Add(tx *crypto.Transaction) {
 func (t *TxMap) Remove(h crypto.Hash) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
-	t.transactionList.Remove(t.transactionsByHash[h])
-	delete(t.transactionsByHash, h)
+	t.transactions.Delete(h)
+}
+
+// List returns a copy of the transactions in insertion order
+func (t *TxMap) List() []*crypto.Transaction {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.transactions.Values()
+}
+
+// Range calls fn for every transaction in insertion order until fn returns false.
+// fn must not modify the TxMap
+func (t *TxMap) Range(fn func(h crypto.Hash, tx *crypto.Transaction) bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	t.transactions.Range(fn)
 }
 
-// Count returns the number of transactions in the transactionsByHash map
+// Count returns the number of transactions in the pool
 func (t *TxMap) Count() int {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
-	return len(t.transactionsByHash)
+	return t.transactions.Len()
 }
 
 // Contains checks if a transaction matching hash is contained in the mempool of a node
 func (t *TxMap) Contains(h crypto.Hash) bool {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
-	_, ok := t.transactionsByHash[h]
-	return ok
+	return t.transactions.Contains(h)
 }
 
-// Clear removes all transactions in the transactionsByHash
+// Clear removes all transactions in the pool
 func (t *TxMap) Clear() {
 	t.lock.Lock()
 	defer t.lock.Unlock()
-	t.transactionsByHash = make(map[crypto.Hash]*crypto.Transaction)
-	t.transactionList.Clear()
+	t.transactions.Clear()
 }
@@ -0,0 +1,176 @@
+package network
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// these tests are meant to be run with the race detector: go test -race ./network
+
+// helper function checking that every index of the pool agrees
+func assertPoolConsistent(t *testing.T, p *MemPool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	all := p.allTransactions.Count()
+	assert.LessOrEqual(t, all, p.MaxSize)
+	assert.Equal(t, all, p.priced.Len())
+	assert.Equal(t, all, len(p.arrivals))
+
+	queued := 0
+	for _, queue := range p.senders {
+		queued += len(queue.txs)
+		assert.LessOrEqual(t, len(queue.txs), p.MaxSenderSlots)
+	}
+	assert.Equal(t, all, queued)
+
+	for _, tx := range p.pendingTransactions.List() {
+		assert.True(t, p.allTransactions.Contains(tx.Hash(crypto.TxHash{})))
+	}
+}
+
+// helper function creating n senders with a few transactions each
+func newStressTxs(t *testing.T, senders, perSender int) []*crypto.Transaction {
+	var txs []*crypto.Transaction
+	for i := 0; i < senders; i++ {
+		sender, _ := crypto.GeneratePrivateKey()
+		for nonce := 0; nonce < perSender; nonce++ {
+			txs = append(txs, newFeeTx(t, sender, uint64(nonce), uint64(rand.Intn(100))))
+		}
+	}
+
+	return txs
+}
+
+func TestTxPool_ConcurrentAddRespectsMaxSize(t *testing.T) {
+	p := NewMempool(50)
+	txs := newStressTxs(t, 40, 5)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(txs); i += 8 {
+				p.Add(txs[i])
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 50, p.AllTxCount())
+	assertPoolConsistent(t, p)
+}
+
+func TestTxPool_ConcurrentDuplicateAdds(t *testing.T) {
+	p := NewMempool(10)
+	tx := crypto.NewTxWithSignature([]byte("hello world"))
+
+	var wg sync.WaitGroup
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, p.Add(tx))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, p.AllTxCount())
+	assert.Equal(t, 1, p.PendingTxCount())
+	assertPoolConsistent(t, p)
+}
+
+func TestTxPool_ConcurrentMixedOperations(t *testing.T) {
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 64, MaxSenderSlots: 4})
+	txs := newStressTxs(t, 30, 6)
+
+	var wg sync.WaitGroup
+	// writers
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(txs); i += 4 {
+				p.Add(txs[i])
+			}
+		}(w)
+	}
+	// block producer like removals of pending snapshots
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			pending := p.GetPendingTx()
+			if len(pending) > 2 {
+				pending = pending[:2]
+			}
+			for _, tx := range pending {
+				p.Remove(tx.Hash(crypto.TxHash{}))
+			}
+		}
+	}()
+	// readers
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				pending := p.GetPendingTx()
+				// snapshots are copies that may be modified freely
+				for j := range pending {
+					pending[j] = nil
+				}
+				p.Contains(txs[i%len(txs)].Hash(crypto.TxHash{}))
+				p.PendingTxCount()
+				p.AllTxCount()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assertPoolConsistent(t, p)
+	for _, tx := range p.GetPendingTx() {
+		assert.NotNil(t, tx)
+	}
+}
+
+func TestTxPool_ConcurrentResetAndAdd(t *testing.T) {
+	sender, _ := crypto.GeneratePrivateKey()
+	chain := newTestChain(t, fundedConfig(1000, sender))
+
+	p := NewMempool(100)
+	p.SubscribeChain(chain)
+
+	var txs []*crypto.Transaction
+	for nonce := uint64(0); nonce < 20; nonce++ {
+		txs = append(txs, newTransferTx(t, sender, nonce, 1))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for _, tx := range txs {
+			p.Add(tx)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5; i++ {
+			height := chain.GetBlockchainHeight()
+			chain.AddBlock(nextBlock(t, chain, height, txs[i]))
+		}
+	}()
+	wg.Wait()
+
+	assertPoolConsistent(t, p)
+	// nothing included in the chain remains pooled
+	for _, tx := range txs[:5] {
+		assert.False(t, p.Contains(tx.Hash(crypto.TxHash{})))
+	}
+}
@@ -1,9 +1,12 @@
 package network
 
 import (
+	"context"
 	"github.com/majorshift/safari-chain/crypto"
+	"github.com/majorshift/safari-chain/events"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestTxPoolAdd(t *testing.T) {
@@ -32,11 +35,13 @@ func TestTxPool_Prune(t *testing.T) {
 	txHash1 := tx1.Hash(&crypto.TxHash{})
 	assert.True(t, p.allTransactions.Contains(txHash1))
 
-	// add another transaction. expect original tx to be pruned
+	// add another transaction paying the same fee. the pool is full
+	// and the new transaction does not outbid the cheapest one
 	tx2 := crypto.NewTxWithSignature([]byte("hello world 1"))
-	p.Add(tx2)
+	assert.ErrorIs(t, p.Add(tx2), ErrTxUnderpriced)
 
 	assert.Equal(t, 1, p.AllTxCount())
+	assert.True(t, p.Contains(txHash1))
 
 	// transaction count remains the at maximum
 	assert.Equal(t, 1, p.allTransactions.Count())
@@ -53,3 +58,327 @@ func TestTxPool_ClearPending(t *testing.T) {
 	p.ClearPendingList()
 	assert.Equal(t, 0, p.PendingTxCount())
 }
+
+// helper function creating a transaction from sender with the given nonce and value
+func newTransferTx(t *testing.T, sender *crypto.PrivateKey, nonce, value uint64) *crypto.Transaction {
+	receiver, err := crypto.GeneratePrivateKey()
+	assert.NoError(t, err)
+
+	tx := crypto.NewTransaction(sender.PublicKey(), receiver.PublicKey(), []byte("transfer"))
+	tx.Nonce = nonce
+	tx.Value = value
+	tx.Sign(sender)
+
+	return tx
+}
+
+// helper function creating a chain config funding the given senders
+func fundedConfig(balance uint64, senders ...*crypto.PrivateKey) *crypto.ChainConfig {
+	config := crypto.DefaultChainConfig()
+	config.Alloc = make(map[string]uint64)
+	for _, sender := range senders {
+		addr := sender.PublicKey().Address()
+		config.Alloc[addr.String()] = balance
+	}
+
+	return config
+}
+
+// helper function building a signed block of txs on top of the chain tip
+func nextBlock(t *testing.T, chain *crypto.Blockchain, parentHeight uint32, txs ...*crypto.Transaction) *crypto.Block {
+	parent, err := chain.GetHeaderByHeight(parentHeight)
+	assert.NoError(t, err)
+
+	validator, _ := crypto.GeneratePrivateKey()
+	return crypto.NewSignedBlockExample(validator, txs, parentHeight+1, crypto.BlockHash{}.Hash(parent))
+}
+
+func TestTxPool_ResetRemovesIncludedTransactions(t *testing.T) {
+	sender, _ := crypto.GeneratePrivateKey()
+	chain := newTestChain(t, fundedConfig(100, sender))
+
+	p := NewMempool(10)
+	p.SubscribeChain(chain)
+
+	tx0 := newTransferTx(t, sender, 0, 10)
+	tx1 := newTransferTx(t, sender, 1, 10)
+	p.Add(tx0)
+	p.Add(tx1)
+
+	// only the first transaction makes it into the block
+	assert.NoError(t, chain.AddBlock(nextBlock(t, chain, 0, tx0)))
+
+	assert.Equal(t, []*crypto.Transaction{tx1}, p.GetPendingTx())
+	assert.Equal(t, uint64(1), chain.Account(sender.PublicKey()).Nonce)
+}
+
+func TestTxPool_ResetEvictsInvalidTransactions(t *testing.T) {
+	sender, _ := crypto.GeneratePrivateKey()
+	chain := newTestChain(t, fundedConfig(100, sender))
+
+	p := NewMempool(10)
+	p.SubscribeChain(chain)
+
+	// competing transaction using the same nonce as the one that gets included
+	stale := newTransferTx(t, sender, 0, 5)
+	// affordable before the block but not after it
+	tooExpensive := newTransferTx(t, sender, 1, 50)
+	p.Add(stale)
+	p.Add(tooExpensive)
+
+	included := newTransferTx(t, sender, 0, 60)
+	assert.NoError(t, chain.AddBlock(nextBlock(t, chain, 0, included)))
+
+	assert.Equal(t, 0, p.PendingTxCount())
+	assert.False(t, p.Contains(stale.Hash(crypto.TxHash{})))
+	assert.False(t, p.Contains(tooExpensive.Hash(crypto.TxHash{})))
+}
+
+func TestTxPool_ResetReinsertsDetachedTransactions(t *testing.T) {
+	sender, _ := crypto.GeneratePrivateKey()
+	chain := newTestChain(t, fundedConfig(100, sender))
+
+	p := NewMempool(10)
+	p.SubscribeChain(chain)
+
+	tx0 := newTransferTx(t, sender, 0, 10)
+	p.Add(tx0)
+	assert.NoError(t, chain.AddBlock(nextBlock(t, chain, 0, tx0)))
+	assert.Equal(t, 0, p.PendingTxCount())
+
+	// a longer fork that does not include tx0 replaces block 1
+	fork1 := nextBlock(t, chain, 0)
+	fork2 := crypto.NewSignedBlockExample(sender, []*crypto.Transaction{}, 2, crypto.BlockHash{}.Hash(fork1.Header))
+	assert.NoError(t, chain.SwitchFork([]*crypto.Block{fork1, fork2}))
+	assert.Equal(t, uint32(2), chain.GetBlockchainHeight())
+
+	// tx0 is pending again
+	assert.Equal(t, []*crypto.Transaction{tx0}, p.GetPendingTx())
+}
+
+// helper function creating a signed transaction paying fee
+func newFeeTx(t *testing.T, sender *crypto.PrivateKey, nonce, fee uint64) *crypto.Transaction {
+	tx := newTransferTx(t, sender, nonce, 0)
+	tx.Fee = fee
+	tx.Sign(sender)
+
+	return tx
+}
+
+func TestTxPool_EvictsLowestFee(t *testing.T) {
+	p := NewMempool(2)
+
+	a, _ := crypto.GeneratePrivateKey()
+	b, _ := crypto.GeneratePrivateKey()
+	c, _ := crypto.GeneratePrivateKey()
+
+	cheap := newFeeTx(t, a, 0, 1)
+	expensive := newFeeTx(t, b, 0, 10)
+	assert.NoError(t, p.Add(cheap))
+	assert.NoError(t, p.Add(expensive))
+
+	// outbids the cheapest transaction which is evicted
+	better := newFeeTx(t, c, 0, 5)
+	assert.NoError(t, p.Add(better))
+	assert.False(t, p.Contains(cheap.Hash(crypto.TxHash{})))
+	assert.True(t, p.Contains(better.Hash(crypto.TxHash{})))
+	assert.Equal(t, 2, p.AllTxCount())
+	assert.Equal(t, 2, p.PendingTxCount())
+
+	// below the current minimum is rejected
+	assert.ErrorIs(t, p.Add(newFeeTx(t, a, 0, 2)), ErrTxUnderpriced)
+	assert.Equal(t, 2, p.AllTxCount())
+}
+
+func TestTxPool_PendingInPriorityOrder(t *testing.T) {
+	p := NewMempool(10)
+
+	a, _ := crypto.GeneratePrivateKey()
+	b, _ := crypto.GeneratePrivateKey()
+
+	// sender a: a cheap transaction unlocking an expensive one
+	a0 := newFeeTx(t, a, 0, 1)
+	a1 := newFeeTx(t, a, 1, 100)
+	// sender b: a single mid priced transaction
+	b0 := newFeeTx(t, b, 0, 50)
+
+	// arrival order does not matter
+	p.Add(a1)
+	p.Add(b0)
+	p.Add(a0)
+
+	// a1 pays the most but cannot be ordered before a0
+	assert.Equal(t, []*crypto.Transaction{b0, a0, a1}, p.GetPendingTx())
+}
+
+func TestTxPool_FutureTransactionsPromotedWhenGapFills(t *testing.T) {
+	p := NewMempool(10)
+	sender, _ := crypto.GeneratePrivateKey()
+
+	tx0 := newFeeTx(t, sender, 0, 1)
+	tx1 := newFeeTx(t, sender, 1, 1)
+	tx2 := newFeeTx(t, sender, 2, 1)
+
+	// nonce 0 is missing so both transactions wait
+	assert.NoError(t, p.Add(tx2))
+	assert.NoError(t, p.Add(tx1))
+	assert.Equal(t, 2, p.AllTxCount())
+	assert.Equal(t, 0, p.PendingTxCount())
+
+	// filling the gap makes all three executable
+	assert.NoError(t, p.Add(tx0))
+	assert.Equal(t, []*crypto.Transaction{tx0, tx1, tx2}, p.GetPendingTx())
+
+	// removing nonce 1 demotes nonce 2 again
+	p.Remove(tx1.Hash(crypto.TxHash{}))
+	assert.Equal(t, []*crypto.Transaction{tx0}, p.GetPendingTx())
+	assert.Equal(t, 2, p.AllTxCount())
+}
+
+func TestTxPool_ReplaceByFee(t *testing.T) {
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 10, PriceBump: 10})
+	sender, _ := crypto.GeneratePrivateKey()
+
+	original := newFeeTx(t, sender, 0, 100)
+	assert.NoError(t, p.Add(original))
+
+	// below the 10% bump
+	assert.ErrorIs(t, p.Add(newFeeTx(t, sender, 0, 109)), ErrReplaceUnderpriced)
+	assert.True(t, p.Contains(original.Hash(crypto.TxHash{})))
+
+	replacement := newFeeTx(t, sender, 0, 110)
+	assert.NoError(t, p.Add(replacement))
+	assert.False(t, p.Contains(original.Hash(crypto.TxHash{})))
+	assert.Equal(t, []*crypto.Transaction{replacement}, p.GetPendingTx())
+	assert.Equal(t, 1, p.AllTxCount())
+}
+
+func TestTxPool_SenderSlotLimit(t *testing.T) {
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 10, MaxSenderSlots: 2})
+	sender, _ := crypto.GeneratePrivateKey()
+	other, _ := crypto.GeneratePrivateKey()
+
+	assert.NoError(t, p.Add(newFeeTx(t, sender, 0, 1)))
+	assert.NoError(t, p.Add(newFeeTx(t, sender, 1, 1)))
+	assert.ErrorIs(t, p.Add(newFeeTx(t, sender, 2, 1)), ErrSenderSlotsFull)
+
+	// replacements do not need a new slot
+	assert.NoError(t, p.Add(newFeeTx(t, sender, 1, 10)))
+
+	// other senders are unaffected
+	assert.NoError(t, p.Add(newFeeTx(t, other, 0, 1)))
+	assert.Equal(t, 3, p.AllTxCount())
+}
+
+func TestTxPool_RejectsUsedNonce(t *testing.T) {
+	sender, _ := crypto.GeneratePrivateKey()
+	chain := newTestChain(t, fundedConfig(100, sender))
+
+	p := NewMempool(10)
+	p.SubscribeChain(chain)
+
+	tx0 := newTransferTx(t, sender, 0, 10)
+	assert.NoError(t, chain.AddBlock(nextBlock(t, chain, 0, tx0)))
+
+	assert.ErrorIs(t, p.Add(newTransferTx(t, sender, 0, 1)), ErrNonceTooLow)
+	assert.NoError(t, p.Add(newTransferTx(t, sender, 1, 1)))
+	assert.Equal(t, 1, p.PendingTxCount())
+}
+
+func TestTxPool_ExpiredTransactions(t *testing.T) {
+	sender, _ := crypto.GeneratePrivateKey()
+	chain := newTestChain(t, fundedConfig(100, sender))
+
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 10})
+	p.SubscribeChain(chain)
+	evicted := events.Subscribe[TxEvicted](p.Bus, 10)
+
+	// the next block is at height 1
+	expiring := newTransferTx(t, sender, 0, 1)
+	expiring.Expiry = 1
+	expiring.Sign(sender)
+	assert.NoError(t, p.Add(expiring))
+
+	// a block without the transaction makes it expire
+	assert.NoError(t, chain.AddBlock(nextBlock(t, chain, 0)))
+	assert.Equal(t, 0, p.AllTxCount())
+
+	ev := <-evicted.Events()
+	assert.Equal(t, expiring, ev.Tx)
+	assert.Equal(t, EvictedExpired, ev.Reason)
+
+	// already expired transactions are not admitted
+	assert.ErrorIs(t, p.Add(expiring), crypto.ErrTxExpired)
+}
+
+func TestTxPool_EvictStale(t *testing.T) {
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 10, TTL: time.Minute})
+	evicted := events.Subscribe[TxEvicted](p.Bus, 10)
+
+	tx1 := crypto.NewTxWithSignature([]byte("hello world"))
+	tx2 := crypto.NewTxWithSignature([]byte("hello world 1"))
+	p.Add(tx1)
+	p.Add(tx2)
+
+	// nothing is stale yet
+	p.evictStale(time.Now())
+	assert.Equal(t, 2, p.AllTxCount())
+
+	p.evictStale(time.Now().Add(2 * time.Minute))
+	assert.Equal(t, 0, p.AllTxCount())
+	assert.Equal(t, TxEvicted{Tx: tx1, Reason: EvictedTTL}, <-evicted.Events())
+	assert.Equal(t, TxEvicted{Tx: tx2, Reason: EvictedTTL}, <-evicted.Events())
+}
+
+func TestTxPool_Janitor(t *testing.T) {
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 10, TTL: time.Millisecond})
+	p.Add(crypto.NewTxWithSignature([]byte("hello world")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.StartJanitor(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return p.AllTxCount() == 0
+	}, time.Second, time.Millisecond)
+
+	// the janitor stops with its context
+	cancel()
+	<-done
+}
+
+func TestTxPool_ReportsReplacement(t *testing.T) {
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 10})
+	evicted := events.Subscribe[TxEvicted](p.Bus, 1)
+	sender, _ := crypto.GeneratePrivateKey()
+
+	original := newFeeTx(t, sender, 0, 10)
+	p.Add(original)
+	p.Add(newFeeTx(t, sender, 0, 20))
+
+	assert.Equal(t, TxEvicted{Tx: original, Reason: EvictedReplaced}, <-evicted.Events())
+}
+
+func TestTxPool_PublishesAdmissions(t *testing.T) {
+	p := NewMempoolWithOpts(MempoolOpts{MaxSize: 10})
+	admitted := events.Subscribe[TxAdmitted](p.Bus, 10)
+	sender, _ := crypto.GeneratePrivateKey()
+
+	tx := newFeeTx(t, sender, 0, 10)
+	assert.NoError(t, p.Add(tx))
+	assert.NoError(t, p.AddLocal(tx))
+	local := newFeeTx(t, sender, 1, 10)
+	assert.NoError(t, p.AddLocal(local))
+	assert.Error(t, p.Add(newFeeTx(t, sender, 0, 10)))
+
+	// duplicates and rejections are not published
+	admitted.Unsubscribe()
+	var got []TxAdmitted
+	for ev := range admitted.Events() {
+		got = append(got, ev)
+	}
+	assert.Equal(t, []TxAdmitted{{Tx: tx}, {Tx: local, Local: true}}, got)
+}
@@ -0,0 +1,821 @@
+package network
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/majorshift/safari-chain/crypto"
+)
+
+// ProtocolVersion is the version of the wire protocol spoken by this node.
+// Messages of any other version are rejected
+const ProtocolVersion uint16 = 1
+
+const (
+	envelopeSize = 2 + 1 // protocol version and message type
+
+	// MaxInventory bounds the number of hashes in announcements and requests
+	MaxInventory = 4096
+	// MaxHeaders bounds the number of headers in a single response
+	MaxHeaders = 2000
+	// MaxBlocks bounds the number of blocks in a single response
+	MaxBlocks = 128
+	// MaxBlockTxs bounds the number of transactions referenced by a compact block
+	MaxBlockTxs = 1 << 16
+	// MaxAddrs bounds the number of addresses in a single peer exchange
+	MaxAddrs = 1000
+	// MaxAddrLen bounds the length of a single node address
+	MaxAddrLen = 256
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	ErrUnknownMessageType = errors.New("unknown message type")
+	ErrMalformedMessage   = errors.New("malformed message")
+)
+
+// MessageType identifies the payload carried by a Message
+type MessageType byte
+
+const (
+	MessageStatus        MessageType = iota + 1 // StatusMessage
+	MessagePing                                 // PingMessage
+	MessagePong                                 // PongMessage
+	MessageTxAnnounce                           // TxAnnounceMessage
+	MessageTxRequest                            // TxRequestMessage
+	MessageTxs                                  // TxsMessage
+	MessageBlockAnnounce                        // BlockAnnounceMessage
+	MessageGetHeaders                           // GetHeadersMessage
+	MessageHeaders                              // HeadersMessage
+	MessageGetBlocks                            // GetBlocksMessage
+	MessageBlocks                               // BlocksMessage
+	MessageCompactBlock                         // CompactBlockMessage
+	MessageGetBlockTxs                          // GetBlockTxsMessage
+	MessageBlockTxs                             // BlockTxsMessage
+	MessageGetAddr                              // GetAddrMessage
+	MessageAddr                                 // AddrMessage
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case MessageStatus:
+		return "status"
+	case MessagePing:
+		return "ping"
+	case MessagePong:
+		return "pong"
+	case MessageTxAnnounce:
+		return "tx announce"
+	case MessageTxRequest:
+		return "tx request"
+	case MessageTxs:
+		return "txs"
+	case MessageBlockAnnounce:
+		return "block announce"
+	case MessageGetHeaders:
+		return "get headers"
+	case MessageHeaders:
+		return "headers"
+	case MessageGetBlocks:
+		return "get blocks"
+	case MessageBlocks:
+		return "blocks"
+	case MessageCompactBlock:
+		return "compact block"
+	case MessageGetBlockTxs:
+		return "get block txs"
+	case MessageBlockTxs:
+		return "block txs"
+	case MessageGetAddr:
+		return "get addr"
+	case MessageAddr:
+		return "addr"
+	default:
+		return fmt.Sprintf("unknown (%d)", byte(t))
+	}
+}
+
+// Payload is the body of a message. Every payload encodes itself
+// canonically with MarshalBinary and UnmarshalBinary
+type Payload interface {
+	Type() MessageType
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+// Message is the envelope of everything sent between nodes: the protocol
+// version, the message type and the encoded payload
+type Message struct {
+	Version uint16
+	Type    MessageType
+	Payload []byte
+}
+
+// NewMessage wraps p in an envelope of the current protocol version
+func NewMessage(p Payload) (*Message, error) {
+	payload, err := p.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Version: ProtocolVersion,
+		Type:    p.Type(),
+		Payload: payload,
+	}, nil
+}
+
+// EncodeMessage returns the wire encoding of the envelope around p
+func EncodeMessage(p Payload) ([]byte, error) {
+	msg, err := NewMessage(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg.Encode(), nil
+}
+
+// Encode returns the wire encoding of the message
+func (m *Message) Encode() []byte {
+	b := make([]byte, envelopeSize, envelopeSize+len(m.Payload))
+	binary.BigEndian.PutUint16(b, m.Version)
+	b[2] = byte(m.Type)
+
+	return append(b, m.Payload...)
+}
+
+// DecodeMessage decodes the envelope in b. The payload is left encoded;
+// see DecodePayload
+func DecodeMessage(b []byte) (*Message, error) {
+	if len(b) < envelopeSize {
+		return nil, fmt.Errorf("%w: short envelope", ErrMalformedMessage)
+	}
+
+	msg := &Message{
+		Version: binary.BigEndian.Uint16(b),
+		Type:    MessageType(b[2]),
+		Payload: b[envelopeSize:],
+	}
+	if msg.Version != ProtocolVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, msg.Version)
+	}
+
+	return msg, nil
+}
+
+// DecodePayload decodes the payload according to the message type
+func (m *Message) DecodePayload() (Payload, error) {
+	var p Payload
+	switch m.Type {
+	case MessageStatus:
+		p = &StatusMessage{}
+	case MessagePing:
+		p = &PingMessage{}
+	case MessagePong:
+		p = &PongMessage{}
+	case MessageTxAnnounce:
+		p = &TxAnnounceMessage{}
+	case MessageTxRequest:
+		p = &TxRequestMessage{}
+	case MessageTxs:
+		p = &TxsMessage{}
+	case MessageBlockAnnounce:
+		p = &BlockAnnounceMessage{}
+	case MessageGetHeaders:
+		p = &GetHeadersMessage{}
+	case MessageHeaders:
+		p = &HeadersMessage{}
+	case MessageGetBlocks:
+		p = &GetBlocksMessage{}
+	case MessageBlocks:
+		p = &BlocksMessage{}
+	case MessageCompactBlock:
+		p = &CompactBlockMessage{}
+	case MessageGetBlockTxs:
+		p = &GetBlockTxsMessage{}
+	case MessageBlockTxs:
+		p = &BlockTxsMessage{}
+	case MessageGetAddr:
+		p = &GetAddrMessage{}
+	case MessageAddr:
+		p = &AddrMessage{}
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnknownMessageType, m.Type)
+	}
+
+	if err := p.UnmarshalBinary(m.Payload); err != nil {
+		return nil, fmt.Errorf("%s message: %w", m.Type, err)
+	}
+
+	return p, nil
+}
+
+// StatusMessage is exchanged when peers connect so each side learns
+// whether the other is on the same chain and how far it got
+type StatusMessage struct {
+	ChainID uint32
+	Genesis crypto.Hash // hash of the genesis header
+	Height  uint32      // height of the chain tip
+	Head    crypto.Hash // hash of the chain tip
+}
+
+// PingMessage asks the peer to answer with a PongMessage carrying the same nonce
+type PingMessage struct {
+	Nonce uint64
+}
+
+// PongMessage answers a PingMessage
+type PongMessage struct {
+	Nonce uint64
+}
+
+// TxAnnounceMessage advertises the hashes of transactions the sender has
+type TxAnnounceMessage struct {
+	Hashes []crypto.Hash
+}
+
+// TxRequestMessage asks for the transactions with the given hashes
+type TxRequestMessage struct {
+	Hashes []crypto.Hash
+}
+
+// TxsMessage carries full transactions
+type TxsMessage struct {
+	Txs []*crypto.Transaction
+}
+
+// BlockAnnounceMessage advertises a new block
+type BlockAnnounceMessage struct {
+	Hash   crypto.Hash
+	Height uint32
+}
+
+// GetHeadersMessage asks for up to Count headers starting at height From
+type GetHeadersMessage struct {
+	From  uint32
+	Count uint32
+}
+
+// HeadersMessage answers a GetHeadersMessage with consecutive headers
+type HeadersMessage struct {
+	Headers []*crypto.Header
+}
+
+// GetBlocksMessage asks for up to Count blocks starting at height From
+type GetBlocksMessage struct {
+	From  uint32
+	Count uint32
+}
+
+// BlocksMessage answers a GetBlocksMessage with consecutive blocks
+type BlocksMessage struct {
+	Blocks []*crypto.Block
+}
+
+// CompactBlockMessage relays a block as its signed header followed by
+// short ids of its transactions, see ShortTxID. Receivers rebuild the
+// block from their mempool and fetch what is missing with GetBlockTxsMessage
+type CompactBlockMessage struct {
+	Header    *crypto.Header
+	Validator *crypto.PublicKey
+	Signature *crypto.Signature
+	ShortIDs  []uint64
+}
+
+// GetBlockTxsMessage asks for the transactions of the block with the
+// given header hash at the given positions
+type GetBlockTxsMessage struct {
+	Hash    crypto.Hash
+	Indexes []uint32
+}
+
+// BlockTxsMessage answers a GetBlockTxsMessage with the requested
+// transactions, in the order they were asked for
+type BlockTxsMessage struct {
+	Hash crypto.Hash
+	Txs  []*crypto.Transaction
+}
+
+// GetAddrMessage asks the peer for the node addresses it knows
+type GetAddrMessage struct{}
+
+// AddrMessage shares node addresses, either answering a GetAddrMessage
+// or advertising the address of the sender
+type AddrMessage struct {
+	Addrs []AddrEntry
+}
+
+// AddrEntry is a node address and when it was last known to be reachable
+type AddrEntry struct {
+	Addr NetAddr
+	Seen int64 // unix time in seconds
+}
+
+func (*StatusMessage) Type() MessageType        { return MessageStatus }
+func (*PingMessage) Type() MessageType          { return MessagePing }
+func (*PongMessage) Type() MessageType          { return MessagePong }
+func (*TxAnnounceMessage) Type() MessageType    { return MessageTxAnnounce }
+func (*TxRequestMessage) Type() MessageType     { return MessageTxRequest }
+func (*TxsMessage) Type() MessageType           { return MessageTxs }
+func (*BlockAnnounceMessage) Type() MessageType { return MessageBlockAnnounce }
+func (*GetHeadersMessage) Type() MessageType    { return MessageGetHeaders }
+func (*HeadersMessage) Type() MessageType       { return MessageHeaders }
+func (*GetBlocksMessage) Type() MessageType     { return MessageGetBlocks }
+func (*BlocksMessage) Type() MessageType        { return MessageBlocks }
+func (*CompactBlockMessage) Type() MessageType  { return MessageCompactBlock }
+func (*GetBlockTxsMessage) Type() MessageType   { return MessageGetBlockTxs }
+func (*BlockTxsMessage) Type() MessageType      { return MessageBlockTxs }
+func (*GetAddrMessage) Type() MessageType       { return MessageGetAddr }
+func (*AddrMessage) Type() MessageType          { return MessageAddr }
+
+func (m *StatusMessage) MarshalBinary() ([]byte, error) {
+	w := &wireWriter{}
+	w.uint32(m.ChainID)
+	w.hash(m.Genesis)
+	w.uint32(m.Height)
+	w.hash(m.Head)
+
+	return w.Bytes(), nil
+}
+
+func (m *StatusMessage) UnmarshalBinary(b []byte) error {
+	r := &wireReader{b: b}
+	m.ChainID = r.uint32()
+	m.Genesis = r.hash()
+	m.Height = r.uint32()
+	m.Head = r.hash()
+
+	return r.finish()
+}
+
+func (m *PingMessage) MarshalBinary() ([]byte, error) {
+	return binary.BigEndian.AppendUint64(nil, m.Nonce), nil
+}
+
+func (m *PingMessage) UnmarshalBinary(b []byte) error {
+	r := &wireReader{b: b}
+	m.Nonce = r.uint64()
+
+	return r.finish()
+}
+
+func (m *PongMessage) MarshalBinary() ([]byte, error) {
+	return binary.BigEndian.AppendUint64(nil, m.Nonce), nil
+}
+
+func (m *PongMessage) UnmarshalBinary(b []byte) error {
+	r := &wireReader{b: b}
+	m.Nonce = r.uint64()
+
+	return r.finish()
+}
+
+func (m *TxAnnounceMessage) MarshalBinary() ([]byte, error) {
+	return marshalHashes(m.Hashes)
+}
+
+func (m *TxAnnounceMessage) UnmarshalBinary(b []byte) (err error) {
+	m.Hashes, err = unmarshalHashes(b)
+	return err
+}
+
+func (m *TxRequestMessage) MarshalBinary() ([]byte, error) {
+	return marshalHashes(m.Hashes)
+}
+
+func (m *TxRequestMessage) UnmarshalBinary(b []byte) (err error) {
+	m.Hashes, err = unmarshalHashes(b)
+	return err
+}
+
+func (m *TxsMessage) MarshalBinary() ([]byte, error) {
+	if len(m.Txs) > MaxInventory {
+		return nil, fmt.Errorf("%w: %d transactions", ErrMalformedMessage, len(m.Txs))
+	}
+
+	w := &wireWriter{}
+	w.uint32(uint32(len(m.Txs)))
+	for _, tx := range m.Txs {
+		if err := w.value(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	return w.Bytes(), nil
+}
+
+func (m *TxsMessage) UnmarshalBinary(b []byte) error {
+	r := &wireReader{b: b}
+	n := r.count(MaxInventory, 4)
+	m.Txs = nil
+	for i := 0; i < n && r.err == nil; i++ {
+		tx := &crypto.Transaction{}
+		r.value(tx)
+		m.Txs = append(m.Txs, tx)
+	}
+
+	return r.finish()
+}
+
+func (m *BlockAnnounceMessage) MarshalBinary() ([]byte, error) {
+	w := &wireWriter{}
+	w.hash(m.Hash)
+	w.uint32(m.Height)
+
+	return w.Bytes(), nil
+}
+
+func (m *BlockAnnounceMessage) UnmarshalBinary(b []byte) error {
+	r := &wireReader{b: b}
+	m.Hash = r.hash()
+	m.Height = r.uint32()
+
+	return r.finish()
+}
+
+func (m *GetHeadersMessage) MarshalBinary() ([]byte, error) {
+	return marshalRange(m.From, m.Count), nil
+}
+
+func (m *GetHeadersMessage) UnmarshalBinary(b []byte) (err error) {
+	m.From, m.Count, err = unmarshalRange(b)
+	return err
+}
+
+func (m *HeadersMessage) MarshalBinary() ([]byte, error) {
+	if len(m.Headers) > MaxHeaders {
+		return nil, fmt.Errorf("%w: %d headers", ErrMalformedMessage, len(m.Headers))
+	}
+
+	w := &wireWriter{}
+	w.uint32(uint32(len(m.Headers)))
+	for _, h := range m.Headers {
+		w.Write(h.ToBytes())
+	}
+
+	return w.Bytes(), nil
+}
+
+func (m *HeadersMessage) UnmarshalBinary(b []byte) error {
+	r := &wireReader{b: b}
+	n := r.count(MaxHeaders, headerLen)
+	m.Headers = nil
+	for i := 0; i < n && r.err == nil; i++ {
+		h := &crypto.Header{}
+		if enc := r.next(headerLen); enc != nil {
+			r.check(h.UnmarshalBinary(enc))
+		}
+		m.Headers = append(m.Headers, h)
+	}
+
+	return r.finish()
+}
+
+func (m *GetBlocksMessage) MarshalBinary() ([]byte, error) {
+	return marshalRange(m.From, m.Count), nil
+}
+
+func (m *GetBlocksMessage) UnmarshalBinary(b []byte) (err error) {
+	m.From, m.Count, err = unmarshalRange(b)
+	return err
+}
+
+func (m *BlocksMessage) MarshalBinary() ([]byte, error) {
+	if len(m.Blocks) > MaxBlocks {
+		return nil, fmt.Errorf("%w: %d blocks", ErrMalformedMessage, len(m.Blocks))
+	}
+
+	w := &wireWriter{}
+	w.uint32(uint32(len(m.Blocks)))
+	for _, b := range m.Blocks {
+		if err := w.value(b); err != nil {
+			return nil, err
+		}
+	}
+
+	return w.Bytes(), nil
+}
+
+func (m *BlocksMessage) UnmarshalBinary(b []byte) error {
+	r := &wireReader{b: b}
+	n := r.count(MaxBlocks, 4)
+	m.Blocks = nil
+	for i := 0; i < n && r.err == nil; i++ {
+		block := &crypto.Block{}
+		r.value(block)
+		m.Blocks = append(m.Blocks, block)
+	}
+
+	return r.finish()
+}
+
+func (m *CompactBlockMessage) MarshalBinary() ([]byte, error) {
+	if len(m.ShortIDs) > MaxBlockTxs {
+		return nil, fmt.Errorf("%w: %d short ids", ErrMalformedMessage, len(m.ShortIDs))
+	}
+
+	// the signed header travels as a block without transactions
+	w := &wireWriter{}
+	if err := w.value(&crypto.Block{Header: m.Header, Validator: m.Validator, Signature: m.Signature}); err != nil {
+		return nil, err
+	}
+	w.uint32(uint32(len(m.ShortIDs)))
+	for _, id := range m.ShortIDs {
+		binary.Write(w, binary.BigEndian, id)
+	}
+
+	return w.Bytes(), nil
+}
+
+func (m *CompactBlockMessage) UnmarshalBinary(b []byte) error {
+	r := &wireReader{b: b}
+	shell := &crypto.Block{}
+	r.value(shell)
+	if r.err == nil && len(shell.Transactions) > 0 {
+		r.fail("compact block carries transactions")
+	}
+	m.Header, m.Validator, m.Signature = shell.Header, shell.Validator, shell.Signature
+
+	n := r.count(MaxBlockTxs, 8)
+	m.ShortIDs = nil
+	for i := 0; i < n && r.err == nil; i++ {
+		m.ShortIDs = append(m.ShortIDs, r.uint64())
+	}
+
+	return r.finish()
+}
+
+func (m *GetBlockTxsMessage) MarshalBinary() ([]byte, error) {
+	if len(m.Indexes) > MaxBlockTxs {
+		return nil, fmt.Errorf("%w: %d indexes", ErrMalformedMessage, len(m.Indexes))
+	}
+
+	w := &wireWriter{}
+	w.hash(m.Hash)
+	w.uint32(uint32(len(m.Indexes)))
+	for _, i := range m.Indexes {
+		w.uint32(i)
+	}
+
+	return w.Bytes(), nil
+}
+
+func (m *GetBlockTxsMessage) UnmarshalBinary(b []byte) error {
+	r := &wireReader{b: b}
+	m.Hash = r.hash()
+	n := r.count(MaxBlockTxs, 4)
+	m.Indexes = nil
+	for i := 0; i < n && r.err == nil; i++ {
+		m.Indexes = append(m.Indexes, r.uint32())
+	}
+
+	return r.finish()
+}
+
+func (m *BlockTxsMessage) MarshalBinary() ([]byte, error) {
+	if len(m.Txs) > MaxBlockTxs {
+		return nil, fmt.Errorf("%w: %d transactions", ErrMalformedMessage, len(m.Txs))
+	}
+
+	w := &wireWriter{}
+	w.hash(m.Hash)
+	w.uint32(uint32(len(m.Txs)))
+	for _, tx := range m.Txs {
+		if err := w.value(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	return w.Bytes(), nil
+}
+
+func (m *BlockTxsMessage) UnmarshalBinary(b []byte) error {
+	r := &wireReader{b: b}
+	m.Hash = r.hash()
+	n := r.count(MaxBlockTxs, 4)
+	m.Txs = nil
+	for i := 0; i < n && r.err == nil; i++ {
+		tx := &crypto.Transaction{}
+		r.value(tx)
+		m.Txs = append(m.Txs, tx)
+	}
+
+	return r.finish()
+}
+
+func (m *GetAddrMessage) MarshalBinary() ([]byte, error) {
+	return nil, nil
+}
+
+func (m *GetAddrMessage) UnmarshalBinary(b []byte) error {
+	r := &wireReader{b: b}
+
+	return r.finish()
+}
+
+func (m *AddrMessage) MarshalBinary() ([]byte, error) {
+	if len(m.Addrs) > MaxAddrs {
+		return nil, fmt.Errorf("%w: %d addresses", ErrMalformedMessage, len(m.Addrs))
+	}
+
+	w := &wireWriter{}
+	w.uint32(uint32(len(m.Addrs)))
+	for _, a := range m.Addrs {
+		if len(a.Addr) > MaxAddrLen {
+			return nil, fmt.Errorf("%w: address of %d bytes", ErrMalformedMessage, len(a.Addr))
+		}
+		w.uint32(uint32(len(a.Addr)))
+		w.WriteString(string(a.Addr))
+		binary.Write(w, binary.BigEndian, a.Seen)
+	}
+
+	return w.Bytes(), nil
+}
+
+func (m *AddrMessage) UnmarshalBinary(b []byte) error {
+	r := &wireReader{b: b}
+	n := r.count(MaxAddrs, 4+8)
+	m.Addrs = nil
+	for i := 0; i < n && r.err == nil; i++ {
+		size := r.uint32()
+		if size > MaxAddrLen {
+			r.fail("address of %d bytes", size)
+			break
+		}
+		addr := NetAddr(r.next(int(size)))
+		m.Addrs = append(m.Addrs, AddrEntry{Addr: addr, Seen: int64(r.uint64())})
+	}
+
+	return r.finish()
+}
+
+// headerLen is the size of an encoded header
+var headerLen = len((&crypto.Header{}).ToBytes())
+
+func marshalHashes(hashes []crypto.Hash) ([]byte, error) {
+	if len(hashes) > MaxInventory {
+		return nil, fmt.Errorf("%w: %d hashes", ErrMalformedMessage, len(hashes))
+	}
+
+	w := &wireWriter{}
+	w.uint32(uint32(len(hashes)))
+	for _, h := range hashes {
+		w.hash(h)
+	}
+
+	return w.Bytes(), nil
+}
+
+func unmarshalHashes(b []byte) ([]crypto.Hash, error) {
+	r := &wireReader{b: b}
+	n := r.count(MaxInventory, len(crypto.Hash{}))
+
+	var hashes []crypto.Hash
+	for i := 0; i < n && r.err == nil; i++ {
+		hashes = append(hashes, r.hash())
+	}
+
+	return hashes, r.finish()
+}
+
+func marshalRange(from, count uint32) []byte {
+	w := &wireWriter{}
+	w.uint32(from)
+	w.uint32(count)
+
+	return w.Bytes()
+}
+
+func unmarshalRange(b []byte) (uint32, uint32, error) {
+	r := &wireReader{b: b}
+	from, count := r.uint32(), r.uint32()
+
+	return from, count, r.finish()
+}
+
+// wireWriter builds message payloads
+type wireWriter struct {
+	bytes.Buffer
+}
+
+func (w *wireWriter) uint32(v uint32) {
+	binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *wireWriter) hash(h crypto.Hash) {
+	w.Write(h[:])
+}
+
+// value writes the canonical encoding of v prefixed with its length
+func (w *wireWriter) value(v encoding.BinaryMarshaler) error {
+	b, err := v.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	w.uint32(uint32(len(b)))
+	w.Write(b)
+
+	return nil
+}
+
+// wireReader decodes message payloads. The first error is kept and
+// every later read returns zero values
+type wireReader struct {
+	b   []byte
+	err error
+}
+
+func (r *wireReader) fail(format string, args ...any) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: "+format, append([]any{ErrMalformedMessage}, args...)...)
+	}
+}
+
+func (r *wireReader) check(err error) {
+	if err != nil && r.err == nil {
+		r.err = fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+}
+
+// next consumes n bytes, or returns nil if fewer are left
+func (r *wireReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.b) {
+		r.fail("unexpected end of payload")
+		return nil
+	}
+
+	v := r.b[:n]
+	r.b = r.b[n:]
+
+	return v
+}
+
+func (r *wireReader) uint32() uint32 {
+	if v := r.next(4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+
+	return 0
+}
+
+func (r *wireReader) uint64() uint64 {
+	if v := r.next(8); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+
+	return 0
+}
+
+func (r *wireReader) hash() crypto.Hash {
+	var h crypto.Hash
+	copy(h[:], r.next(len(h)))
+
+	return h
+}
+
+// count reads an element count of at most max elements that each take
+// at least minSize bytes of the remaining payload
+func (r *wireReader) count(max, minSize int) int {
+	n := r.uint32()
+	switch {
+	case r.err != nil:
+		return 0
+	case n > uint32(max):
+		r.fail("%d elements exceed the limit of %d", n, max)
+		return 0
+	case int(n)*minSize > len(r.b):
+		r.fail("%d elements exceed the payload", n)
+		return 0
+	}
+
+	return int(n)
+}
+
+// value decodes a length prefixed canonical encoding into v
+func (r *wireReader) value(v encoding.BinaryUnmarshaler) {
+	n := r.uint32()
+	if r.err != nil {
+		return
+	}
+	if uint64(n) > uint64(len(r.b)) {
+		r.fail("length %d exceeds payload", n)
+		return
+	}
+
+	r.check(v.UnmarshalBinary(r.next(int(n))))
+}
+
+// finish returns the first error, or an error if payload is left over
+func (r *wireReader) finish() error {
+	if len(r.b) > 0 {
+		r.fail("%d trailing bytes", len(r.b))
+	}
+
+	return r.err
+}
@@ -0,0 +1,138 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// helper function returning one payload of every message type
+func examplePayloads() []Payload {
+	validator, _ := crypto.GeneratePrivateKey()
+	tx := crypto.NewTxWithSignature([]byte("Hello, World"))
+	block := crypto.NewSignedBlockExample(validator, []*crypto.Transaction{tx}, 1, crypto.Hash{7})
+
+	return []Payload{
+		&StatusMessage{ChainID: 1, Genesis: crypto.Hash{1}, Height: 12, Head: crypto.Hash{2}},
+		&PingMessage{Nonce: 42},
+		&PongMessage{Nonce: 42},
+		&TxAnnounceMessage{Hashes: []crypto.Hash{{1}, {2}}},
+		&TxRequestMessage{Hashes: []crypto.Hash{{3}}},
+		&TxsMessage{Txs: []*crypto.Transaction{tx}},
+		&BlockAnnounceMessage{Hash: crypto.Hash{4}, Height: 9},
+		&GetHeadersMessage{From: 1, Count: 100},
+		&HeadersMessage{Headers: []*crypto.Header{block.Header}},
+		&GetBlocksMessage{From: 5, Count: 2},
+		&BlocksMessage{Blocks: []*crypto.Block{block}},
+		&CompactBlockMessage{
+			Header:    block.Header,
+			Validator: block.Validator,
+			Signature: block.Signature,
+			ShortIDs:  []uint64{ShortTxID(block.Hash(crypto.BlockHash{}), tx.Hash(crypto.TxHash{}))},
+		},
+		&GetBlockTxsMessage{Hash: crypto.Hash{5}, Indexes: []uint32{0, 3}},
+		&BlockTxsMessage{Hash: crypto.Hash{5}, Txs: []*crypto.Transaction{tx}},
+		&GetAddrMessage{},
+		&AddrMessage{Addrs: []AddrEntry{{Addr: "a@127.0.0.1:3000", Seen: 1700000000}, {Addr: "b", Seen: 0}}},
+	}
+}
+
+func TestMessage_RoundTrip(t *testing.T) {
+	for _, p := range examplePayloads() {
+		t.Run(p.Type().String(), func(t *testing.T) {
+			enc, err := EncodeMessage(p)
+			assert.NoError(t, err)
+
+			msg, err := DecodeMessage(enc)
+			assert.NoError(t, err)
+			assert.Equal(t, ProtocolVersion, msg.Version)
+			assert.Equal(t, p.Type(), msg.Type)
+
+			decoded, err := msg.DecodePayload()
+			assert.NoError(t, err)
+
+			// compare encodings since decoded blocks drop cached fields
+			want, _ := p.MarshalBinary()
+			got, _ := decoded.MarshalBinary()
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestMessage_RejectsUnknownVersionAndType(t *testing.T) {
+	enc, err := EncodeMessage(&PingMessage{Nonce: 1})
+	assert.NoError(t, err)
+
+	enc[1]++ // bump the protocol version
+	_, err = DecodeMessage(enc)
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	_, err = DecodeMessage([]byte{0})
+	assert.ErrorIs(t, err, ErrMalformedMessage)
+
+	msg := &Message{Version: ProtocolVersion, Type: MessageType(200)}
+	_, err = msg.DecodePayload()
+	assert.ErrorIs(t, err, ErrUnknownMessageType)
+}
+
+func TestMessage_RejectsMalformedPayloads(t *testing.T) {
+	// trailing bytes
+	msg := &Message{Version: ProtocolVersion, Type: MessagePing, Payload: make([]byte, 9)}
+	_, err := msg.DecodePayload()
+	assert.ErrorIs(t, err, ErrMalformedMessage)
+
+	// more hashes than allowed
+	w := &wireWriter{}
+	w.uint32(MaxInventory + 1)
+	msg = &Message{Version: ProtocolVersion, Type: MessageTxAnnounce, Payload: w.Bytes()}
+	_, err = msg.DecodePayload()
+	assert.ErrorIs(t, err, ErrMalformedMessage)
+
+	// a transaction that is not canonically encoded
+	w = &wireWriter{}
+	w.uint32(1)
+	w.uint32(3)
+	w.Write([]byte{1, 2, 3})
+	msg = &Message{Version: ProtocolVersion, Type: MessageTxs, Payload: w.Bytes()}
+	_, err = msg.DecodePayload()
+	assert.ErrorIs(t, err, ErrMalformedMessage)
+	assert.ErrorIs(t, err, crypto.ErrInvalidEncoding)
+
+	// an address above the length limit
+	w = &wireWriter{}
+	w.uint32(1)
+	w.uint32(MaxAddrLen + 1)
+	w.Write(make([]byte, MaxAddrLen+1+8))
+	msg = &Message{Version: ProtocolVersion, Type: MessageAddr, Payload: w.Bytes()}
+	_, err = msg.DecodePayload()
+	assert.ErrorIs(t, err, ErrMalformedMessage)
+
+	_, err = EncodeMessage(&TxAnnounceMessage{Hashes: make([]crypto.Hash, MaxInventory+1)})
+	assert.ErrorIs(t, err, ErrMalformedMessage)
+}
+
+func FuzzDecodeMessage(f *testing.F) {
+	for _, p := range examplePayloads() {
+		enc, _ := EncodeMessage(p)
+		f.Add(enc)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := DecodeMessage(data)
+		if err != nil {
+			return
+		}
+		p, err := msg.DecodePayload()
+		if err != nil {
+			return
+		}
+
+		// anything accepted must be in canonical form
+		enc, err := EncodeMessage(p)
+		if err != nil || !bytes.Equal(enc, data) {
+			t.Fatalf("decoded %s message re-encodes differently", msg.Type)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package network
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/majorshift/safari-chain/events"
+)
+
+// Metrics counts what happens to the chain and the mempool by consuming
+// the events they publish, so the components need no hooks of their own.
+// Transactions included in blocks are counted from the BlockAdded events,
+// which keeps a full block a single event
+type Metrics struct {
+	chain       *crypto.Blockchain
+	mempool     *MemPool
+	added       *events.Subscription[crypto.BlockAdded]
+	reorgs      *events.Subscription[crypto.ChainReorg]
+	admitted    *events.Subscription[TxAdmitted]
+	evicted     *events.Subscription[TxEvicted]
+	blocks      atomic.Uint64
+	txsIncluded atomic.Uint64
+	reorgCount  atomic.Uint64
+	detached    atomic.Uint64
+	txsAdmitted atomic.Uint64
+	txsEvicted  atomic.Uint64
+}
+
+// MetricsSnapshot holds the metrics of a node at one point in time. The
+// counters start at zero when the node starts
+type MetricsSnapshot struct {
+	Height         uint32 `json:"height"`
+	Blocks         uint64 `json:"blocks"`         // blocks added, including those of branches switched to
+	TxsIncluded    uint64 `json:"txsIncluded"`    // transactions of the added blocks
+	Reorgs         uint64 `json:"reorgs"`         // switches to another branch
+	DetachedBlocks uint64 `json:"detachedBlocks"` // blocks removed from the chain by reorgs
+	TxsAdmitted    uint64 `json:"txsAdmitted"`    // transactions entering the mempool
+	TxsEvicted     uint64 `json:"txsEvicted"`     // transactions evicted from the mempool
+	PooledTxs      int    `json:"pooledTxs"`
+	EventsDropped  uint64 `json:"eventsDropped"` // events missed while the counting fell behind
+}
+
+// NewMetrics subscribes to the events of chain and mempool. The
+// subscriptions are released when Start returns
+func NewMetrics(chain *crypto.Blockchain, mempool *MemPool) *Metrics {
+	return &Metrics{
+		chain:    chain,
+		mempool:  mempool,
+		added:    events.Subscribe[crypto.BlockAdded](chain.Events(), 0),
+		reorgs:   events.Subscribe[crypto.ChainReorg](chain.Events(), 0),
+		admitted: events.Subscribe[TxAdmitted](mempool.Bus, 0),
+		evicted:  events.Subscribe[TxEvicted](mempool.Bus, 0),
+	}
+}
+
+// Start counts the events until ctx is cancelled
+func (m *Metrics) Start(ctx context.Context) {
+	defer m.added.Unsubscribe()
+	defer m.reorgs.Unsubscribe()
+	defer m.admitted.Unsubscribe()
+	defer m.evicted.Unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-m.added.Events():
+			m.blocks.Add(1)
+			m.txsIncluded.Add(uint64(len(ev.Block.Transactions)))
+		case ev := <-m.reorgs.Events():
+			m.reorgCount.Add(1)
+			m.detached.Add(uint64(len(ev.Detached)))
+		case <-m.admitted.Events():
+			m.txsAdmitted.Add(1)
+		case <-m.evicted.Events():
+			m.txsEvicted.Add(1)
+		}
+	}
+}
+
+// Snapshot returns the current metrics
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	return MetricsSnapshot{
+		Height:         m.chain.GetBlockchainHeight(),
+		Blocks:         m.blocks.Load(),
+		TxsIncluded:    m.txsIncluded.Load(),
+		Reorgs:         m.reorgCount.Load(),
+		DetachedBlocks: m.detached.Load(),
+		TxsAdmitted:    m.txsAdmitted.Load(),
+		TxsEvicted:     m.txsEvicted.Load(),
+		PooledTxs:      m.mempool.AllTxCount(),
+		EventsDropped:  m.added.Dropped() + m.reorgs.Dropped() + m.admitted.Dropped() + m.evicted.Dropped(),
+	}
+}
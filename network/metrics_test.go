@@ -0,0 +1,42 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics_CountsChainAndMempoolEvents(t *testing.T) {
+	sender, _ := crypto.GeneratePrivateKey()
+	chain := newTestChain(t, fundedConfig(100, sender))
+	p := NewMempool(10)
+	p.SubscribeChain(chain)
+
+	m := NewMetrics(chain, p)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Start(ctx)
+
+	tx0 := newTransferTx(t, sender, 0, 10)
+	assert.NoError(t, p.Add(tx0))
+	assert.NoError(t, chain.AddBlock(nextBlock(t, chain, 0, tx0)))
+
+	// a longer fork without tx0 replaces block 1 and returns tx0 to the pool
+	fork1 := nextBlock(t, chain, 0)
+	fork2 := crypto.NewSignedBlockExample(sender, []*crypto.Transaction{}, 2, crypto.BlockHash{}.Hash(fork1.Header))
+	assert.NoError(t, chain.SwitchFork([]*crypto.Block{fork1, fork2}))
+
+	expected := MetricsSnapshot{
+		Height:         2,
+		Blocks:         3,
+		TxsIncluded:    1,
+		Reorgs:         1,
+		DetachedBlocks: 1,
+		TxsAdmitted:    2,
+		PooledTxs:      1,
+	}
+	assert.Eventually(t, func() bool { return m.Snapshot() == expected }, time.Second, 5*time.Millisecond, "%+v", m.Snapshot())
+}
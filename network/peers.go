@@ -0,0 +1,481 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultMaxInbound    = 32
+	defaultMaxOutbound   = 8
+	defaultBanThreshold  = -100
+	defaultBanDuration   = 24 * time.Hour
+	defaultScoreRecovery = time.Minute
+	defaultMessageRate   = 256 // messages per second
+)
+
+// Penalties subtracted from the score of a misbehaving peer
+const (
+	PenaltyInvalidBlock = 100 // sent a block failing validation
+	PenaltyIncompatible = 100 // runs another chain
+	PenaltyMalformed    = 50  // sent a message that cannot be decoded
+	PenaltyInvalidTx    = 20  // sent a transaction that can never be valid
+	PenaltyTimeout      = 10  // left a request unanswered
+	PenaltyConflict     = 10  // sent a block conflicting with the chain at a height it already has
+	PenaltyUnexpected   = 10  // sent a message breaking the protocol in another way
+	PenaltySpam         = 5   // sent a message above the rate limit
+)
+
+var (
+	ErrPeerBanned  = errors.New("peer is banned")
+	ErrNoPeerSlots = errors.New("no free peer slot")
+	ErrPeerTimeout = errors.New("peer did not answer in time")
+)
+
+// PeerReporter is told about peers that misbehave
+type PeerReporter interface {
+	// ReportError reports that err was caused by the peer at addr
+	ReportError(addr NetAddr, err error)
+}
+
+// ConnectionGater decides which authenticated peers a transport keeps
+type ConnectionGater interface {
+	// AllowPeer is called once a peer authenticated; the connection is
+	// dropped when it returns an error
+	AllowPeer(id NetAddr, outbound bool) error
+	// PeerClosed is called when the connection to an allowed peer is gone
+	PeerClosed(id NetAddr)
+}
+
+// PeerManagerOpts configures a PeerManager
+type PeerManagerOpts struct {
+	MaxInbound    int           // peers that dialled us; defaults to 32, negative refuses them all
+	MaxOutbound   int           // peers we dialled; defaults to 8, negative refuses them all
+	BanThreshold  int           // score at or below which a peer is banned; defaults to -100
+	BanDuration   time.Duration // defaults to 24h
+	ScoreRecovery time.Duration // time for a penalised peer to regain a point; defaults to 1m
+	MessageRate   int           // messages accepted from a peer per second; defaults to 256
+	// BanFile is the file bans are saved to so they survive restarts;
+	// empty keeps them in memory only
+	BanFile string
+	Logger  *logrus.Logger
+}
+
+// PeerManager keeps the peers of a node in check. It limits the inbound
+// and outbound connections, scores every peer and bans the ones whose
+// score drops to BanThreshold. Scores drop on the errors reported for a
+// peer, weighted by Penalty, and slowly recover over time. It is meant to
+// be used as the ConnectionGater of the transport
+type PeerManager struct {
+	PeerManagerOpts
+	transport Transport
+	lock      sync.Mutex
+	saveLock  sync.Mutex       // serialises writes of BanFile; taken before lock
+	slots     map[NetAddr]bool // connected peers, true for outbound ones
+	peers     map[NetAddr]*peerState
+	bans      map[NetAddr]*Ban
+}
+
+// peerState is the score and message allowance of a peer. It is forgotten
+// once the peer is disconnected and its score recovered
+type peerState struct {
+	score    int
+	updated  time.Time // when the score last recovered
+	tokens   float64   // messages the peer may send right now
+	refilled time.Time // when tokens were last topped up
+}
+
+// Ban keeps a peer away until it expires
+type Ban struct {
+	Peer   NetAddr   `json:"peer"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+func NewPeerManager(transport Transport, opts PeerManagerOpts) *PeerManager {
+	if opts.MaxInbound == 0 {
+		opts.MaxInbound = defaultMaxInbound
+	}
+	if opts.MaxOutbound == 0 {
+		opts.MaxOutbound = defaultMaxOutbound
+	}
+	if opts.BanThreshold == 0 {
+		opts.BanThreshold = defaultBanThreshold
+	}
+	if opts.BanDuration == 0 {
+		opts.BanDuration = defaultBanDuration
+	}
+	if opts.ScoreRecovery == 0 {
+		opts.ScoreRecovery = defaultScoreRecovery
+	}
+	if opts.MessageRate == 0 {
+		opts.MessageRate = defaultMessageRate
+	}
+	if opts.Logger == nil {
+		opts.Logger = logrus.New()
+	}
+
+	return &PeerManager{
+		PeerManagerOpts: opts,
+		transport:       transport,
+		slots:           make(map[NetAddr]bool),
+		peers:           make(map[NetAddr]*peerState),
+		bans:            make(map[NetAddr]*Ban),
+	}
+}
+
+// Penalty returns how much err, caused by a peer, lowers its score.
+// Errors that honest peers cause as well, such as a known block, a block
+// on another branch or a transaction the local pool has no room for, cost
+// nothing
+func Penalty(err error) int {
+	var reject *RejectError
+	var blockErr *crypto.BlockError
+
+	switch {
+	case err == nil || crypto.IsBenign(err):
+		return 0
+	case errors.Is(err, ErrPeerTimeout):
+		return PenaltyTimeout
+	case errors.Is(err, ErrIncompatiblePeer):
+		return PenaltyIncompatible
+	case errors.Is(err, ErrMalformedMessage), errors.Is(err, ErrUnknownMessageType),
+		errors.Is(err, ErrUnsupportedVersion), errors.Is(err, crypto.ErrInvalidEncoding):
+		return PenaltyMalformed
+	case errors.As(err, &reject):
+		switch reject.Reason {
+		case RejectMalformed, RejectTooLarge, RejectInvalidSignature, RejectWrongChain:
+			return PenaltyInvalidTx
+		default:
+			// depends on the state of the local pool
+			return 0
+		}
+	case errors.Is(err, crypto.ErrBlockConflict):
+		// the competing blocks of honest validators are dropped before
+		// reaching here, see BlockRelay
+		return PenaltyConflict
+	case errors.Is(err, crypto.ErrBlockTooHigh), errors.Is(err, crypto.ErrPrevHashMismatch),
+		errors.Is(err, crypto.ErrUnknownBlock), errors.Is(err, crypto.ErrForkTooShort):
+		// the peer is ahead of us or on another branch
+		return 0
+	case errors.As(err, &blockErr):
+		return PenaltyInvalidBlock
+	default:
+		return PenaltyUnexpected
+	}
+}
+
+// LoadBans restores the bans saved to BanFile, dropping expired ones.
+// A missing file is not an error
+func (m *PeerManager) LoadBans() error {
+	if m.BanFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(m.BanFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var bans []*Ban
+	if err := json.Unmarshal(data, &bans); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	for _, ban := range bans {
+		if ban.Until.After(now) {
+			m.bans[ban.Peer] = ban
+		}
+	}
+
+	return nil
+}
+
+// AllowPeer admits the peer id unless it is banned or every slot in its
+// direction is taken
+func (m *PeerManager) AllowPeer(id NetAddr, outbound bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.banned(id, time.Now()) {
+		return ErrPeerBanned
+	}
+	if _, ok := m.slots[id]; ok {
+		return nil
+	}
+
+	inbound, out := m.count()
+	if outbound && out >= m.MaxOutbound || !outbound && inbound >= m.MaxInbound {
+		return ErrNoPeerSlots
+	}
+
+	m.slots[id] = outbound
+
+	return nil
+}
+
+// PeerClosed frees the slot of the peer id
+func (m *PeerManager) PeerClosed(id NetAddr) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.slots, id)
+}
+
+// Slots returns the number of inbound and outbound peers
+func (m *PeerManager) Slots() (inbound, outbound int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.count()
+}
+
+// ReportError lowers the score of the peer at addr by Penalty(err)
+func (m *PeerManager) ReportError(addr NetAddr, err error) {
+	if penalty := Penalty(err); penalty > 0 {
+		m.Penalize(addr, penalty, err.Error())
+	}
+}
+
+// Penalize lowers the score of the peer at addr by penalty,
+// banning it once the score reaches BanThreshold
+func (m *PeerManager) Penalize(addr NetAddr, penalty int, reason string) {
+	now := time.Now()
+
+	m.lock.Lock()
+	peer := m.peer(addr, now)
+	peer.score -= penalty
+	ban := peer.score <= m.BanThreshold
+	m.lock.Unlock()
+
+	m.Logger.WithFields(logrus.Fields{
+		"peer":    addr,
+		"penalty": penalty,
+		"reason":  reason,
+	}).Debug("peer penalised")
+
+	if ban {
+		m.Ban(addr, m.BanDuration, reason)
+	}
+}
+
+// Score returns the current score of the peer at addr; 0 is neutral
+func (m *PeerManager) Score(addr NetAddr) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	peer, ok := m.peers[addr]
+	if !ok {
+		return 0
+	}
+	m.recover(peer, time.Now())
+
+	return peer.score
+}
+
+// AllowMessage reports whether the peer at addr stays within MessageRate.
+// Messages above it should be dropped; each of them is penalised
+func (m *PeerManager) AllowMessage(addr NetAddr) bool {
+	now := time.Now()
+
+	m.lock.Lock()
+	peer := m.peer(addr, now)
+	if elapsed := now.Sub(peer.refilled).Seconds(); elapsed > 0 {
+		peer.tokens = min(peer.tokens+elapsed*float64(m.MessageRate), float64(m.MessageRate))
+		peer.refilled = now
+	}
+	allowed := peer.tokens >= 1
+	if allowed {
+		peer.tokens--
+	}
+	m.lock.Unlock()
+
+	if !allowed {
+		m.Penalize(addr, PenaltySpam, "message rate exceeded")
+	}
+
+	return allowed
+}
+
+// Ban disconnects the peer at addr and refuses it for d
+func (m *PeerManager) Ban(addr NetAddr, d time.Duration, reason string) {
+	until := time.Now().Add(d)
+
+	m.lock.Lock()
+	m.bans[addr] = &Ban{Peer: addr, Until: until, Reason: reason}
+	delete(m.peers, addr)
+	m.lock.Unlock()
+	err := m.save()
+
+	m.Logger.WithFields(logrus.Fields{
+		"peer":   addr,
+		"until":  until.Format(time.RFC3339),
+		"reason": reason,
+	}).Warn("peer banned")
+	if err != nil {
+		m.Logger.WithError(err).Error("failed to save bans")
+	}
+
+	// the transport may not know the peer, e.g. when it is banned while connecting
+	m.transport.Disconnect(addr)
+}
+
+// Unban lifts the ban of the peer at addr
+func (m *PeerManager) Unban(addr NetAddr) error {
+	m.lock.Lock()
+	delete(m.bans, addr)
+	m.lock.Unlock()
+
+	return m.save()
+}
+
+// IsBanned reports whether the peer at addr is banned
+func (m *PeerManager) IsBanned(addr NetAddr) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.banned(addr, time.Now())
+}
+
+// Bans returns the bans in force ordered by peer
+func (m *PeerManager) Bans() []Ban {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	bans := make([]Ban, 0, len(m.bans))
+	for addr, ban := range m.bans {
+		if m.banned(addr, now) {
+			bans = append(bans, *ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Peer < bans[j].Peer
+	})
+
+	return bans
+}
+
+// banned reports whether addr is banned at now, forgetting expired bans.
+// Callers hold the lock
+func (m *PeerManager) banned(addr NetAddr, now time.Time) bool {
+	ban, ok := m.bans[addr]
+	if !ok {
+		return false
+	}
+	if !ban.Until.After(now) {
+		delete(m.bans, addr)
+		return false
+	}
+
+	return true
+}
+
+// count returns the number of inbound and outbound slots taken.
+// Callers hold the lock
+func (m *PeerManager) count() (inbound, outbound int) {
+	for _, out := range m.slots {
+		if out {
+			outbound++
+		} else {
+			inbound++
+		}
+	}
+
+	return inbound, outbound
+}
+
+// peer returns the up to date state of addr, creating it if needed. The
+// recovered states of disconnected peers are forgotten. Callers hold the lock
+func (m *PeerManager) peer(addr NetAddr, now time.Time) *peerState {
+	for other, peer := range m.peers {
+		if _, connected := m.slots[other]; !connected && other != addr {
+			if m.recover(peer, now); peer.score >= 0 {
+				delete(m.peers, other)
+			}
+		}
+	}
+
+	peer, ok := m.peers[addr]
+	if !ok {
+		peer = &peerState{
+			updated:  now,
+			tokens:   float64(m.MessageRate),
+			refilled: now,
+		}
+		m.peers[addr] = peer
+	}
+	m.recover(peer, now)
+
+	return peer
+}
+
+// recover gives back a point for every ScoreRecovery elapsed since the
+// last update, up to the neutral score of 0
+func (m *PeerManager) recover(peer *peerState, now time.Time) {
+	points := now.Sub(peer.updated) / m.ScoreRecovery
+	if points <= 0 {
+		return
+	}
+
+	peer.score = min(peer.score+int(points), 0)
+	peer.updated = peer.updated.Add(points * m.ScoreRecovery)
+}
+
+// save writes the bans to BanFile, replacing it atomically. The bans
+// are copied under the lock and written once it is released, so that
+// peers are not held up by the disk
+func (m *PeerManager) save() error {
+	if m.BanFile == "" {
+		return nil
+	}
+
+	m.saveLock.Lock()
+	defer m.saveLock.Unlock()
+
+	now := time.Now()
+	m.lock.Lock()
+	bans := make([]Ban, 0, len(m.bans))
+	for addr, ban := range m.bans {
+		if m.banned(addr, now) {
+			bans = append(bans, *ban)
+		}
+	}
+	m.lock.Unlock()
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Peer < bans[j].Peer
+	})
+
+	data, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := m.BanFile + ".new"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, m.BanFile)
+}
+
+// interface guards
+var (
+	_ ConnectionGater = (*PeerManager)(nil)
+	_ PeerReporter    = (*PeerManager)(nil)
+)
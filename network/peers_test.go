@@ -0,0 +1,183 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPenalty(t *testing.T) {
+	cases := []struct {
+		err     error
+		penalty int
+	}{
+		{nil, 0},
+		{&crypto.BlockError{Err: crypto.ErrBlockKnown}, 0},
+		{&crypto.BlockError{Err: crypto.ErrPrevHashMismatch}, 0},
+		{&crypto.BlockError{Err: crypto.ErrBlockTooHigh}, 0},
+		{&crypto.BlockError{Err: crypto.ErrBlockConflict}, PenaltyConflict},
+		{&crypto.BlockError{Err: crypto.ErrBlockInvalidSignature}, PenaltyInvalidBlock},
+		{&crypto.BlockError{Err: &crypto.TxError{Err: crypto.ErrInsufficientBalance}}, PenaltyInvalidBlock},
+		{&RejectError{Reason: RejectInvalidSignature}, PenaltyInvalidTx},
+		{&RejectError{Reason: RejectUnderpriced}, 0},
+		{&RejectError{Reason: RejectNonceTooLow}, 0},
+		{fmt.Errorf("%w: short payload", ErrMalformedMessage), PenaltyMalformed},
+		{fmt.Errorf("%w: blocks from 3", ErrPeerTimeout), PenaltyTimeout},
+		{fmt.Errorf("%w: genesis", ErrIncompatiblePeer), PenaltyIncompatible},
+		{errors.New("unsolicited"), PenaltyUnexpected},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.penalty, Penalty(c.err), "%v", c.err)
+	}
+}
+
+func TestPeerManager_BansBelowThreshold(t *testing.T) {
+	a := NewLocalTransport("a")
+	b := NewLocalTransport("b")
+	assert.NoError(t, a.Connect(b))
+	m := NewPeerManager(a, PeerManagerOpts{})
+
+	invalid := &RejectError{Reason: RejectInvalidSignature}
+	for i := 0; i < 4; i++ {
+		m.ReportError("b", invalid)
+	}
+	assert.Equal(t, -4*PenaltyInvalidTx, m.Score("b"))
+	assert.False(t, m.IsBanned("b"))
+	assert.Equal(t, []NetAddr{"b"}, a.Peers())
+
+	m.ReportError("b", invalid)
+	assert.True(t, m.IsBanned("b"))
+	assert.Empty(t, a.Peers())
+	assert.ErrorIs(t, m.AllowPeer("b", false), ErrPeerBanned)
+
+	// benign errors cost nothing
+	m.ReportError("c", &crypto.BlockError{Err: crypto.ErrBlockKnown})
+	assert.Equal(t, 0, m.Score("c"))
+}
+
+func TestPeerManager_ConnectionSlots(t *testing.T) {
+	m := NewPeerManager(NewLocalTransport("a"), PeerManagerOpts{MaxInbound: 1, MaxOutbound: 1})
+
+	assert.NoError(t, m.AllowPeer("in1", false))
+	assert.ErrorIs(t, m.AllowPeer("in2", false), ErrNoPeerSlots)
+	assert.NoError(t, m.AllowPeer("out1", true))
+	assert.ErrorIs(t, m.AllowPeer("out2", true), ErrNoPeerSlots)
+
+	// a connected peer is allowed again
+	assert.NoError(t, m.AllowPeer("in1", false))
+
+	m.PeerClosed("in1")
+	assert.NoError(t, m.AllowPeer("in2", false))
+
+	inbound, outbound := m.Slots()
+	assert.Equal(t, 1, inbound)
+	assert.Equal(t, 1, outbound)
+
+	// a negative limit refuses every peer of that direction
+	closed := NewPeerManager(NewLocalTransport("a"), PeerManagerOpts{MaxInbound: -1})
+	assert.ErrorIs(t, closed.AllowPeer("in1", false), ErrNoPeerSlots)
+	assert.NoError(t, closed.AllowPeer("out1", true))
+}
+
+func TestPeerManager_ScoreRecovers(t *testing.T) {
+	m := NewPeerManager(NewLocalTransport("a"), PeerManagerOpts{ScoreRecovery: time.Millisecond})
+
+	m.Penalize("b", 5, "test")
+	assert.Less(t, m.Score("b"), 0)
+	assert.Eventually(t, func() bool { return m.Score("b") == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestPeerManager_RateLimitsMessages(t *testing.T) {
+	m := NewPeerManager(NewLocalTransport("a"), PeerManagerOpts{MessageRate: 10})
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, m.AllowMessage("b"))
+	}
+	assert.False(t, m.AllowMessage("b"))
+	assert.Equal(t, -PenaltySpam, m.Score("b"))
+
+	// other peers have their own allowance
+	assert.True(t, m.AllowMessage("c"))
+}
+
+func TestPeerManager_BansPersist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bans.json")
+
+	m := NewPeerManager(NewLocalTransport("a"), PeerManagerOpts{BanFile: file})
+	m.Ban("b", time.Hour, "test")
+	m.Ban("c", time.Hour, "test")
+	m.Ban("d", -time.Second, "expired")
+	assert.NoError(t, m.Unban("c"))
+
+	restarted := NewPeerManager(NewLocalTransport("a"), PeerManagerOpts{BanFile: file})
+	assert.NoError(t, restarted.LoadBans())
+	assert.True(t, restarted.IsBanned("b"))
+	assert.False(t, restarted.IsBanned("c"))
+	assert.False(t, restarted.IsBanned("d"))
+
+	bans := restarted.Bans()
+	assert.Len(t, bans, 1)
+	assert.Equal(t, NetAddr("b"), bans[0].Peer)
+	assert.Equal(t, "test", bans[0].Reason)
+
+	// a missing file is not an error
+	empty := NewPeerManager(NewLocalTransport("a"), PeerManagerOpts{BanFile: filepath.Join(t.TempDir(), "none.json")})
+	assert.NoError(t, empty.LoadBans())
+}
+
+func TestPeerManager_ConcurrentBansPersist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bans.json")
+	m := NewPeerManager(NewLocalTransport("a"), PeerManagerOpts{BanFile: file})
+
+	// the file written last holds every ban, whichever order the writes run in
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.Ban(NetAddr(fmt.Sprintf("peer%d", i)), time.Hour, "test")
+		}(i)
+	}
+	wg.Wait()
+
+	restarted := NewPeerManager(NewLocalTransport("a"), PeerManagerOpts{BanFile: file})
+	assert.NoError(t, restarted.LoadBans())
+	assert.Len(t, restarted.Bans(), 10)
+}
+
+func TestPeerManager_GatesTCPTransport(t *testing.T) {
+	server := NewTCPTransport(TCPTransportOpts{ListenAddr: "127.0.0.1:0"})
+	m := NewPeerManager(server, PeerManagerOpts{MaxInbound: 1})
+	server.Gater = m
+	assert.NoError(t, server.Start(context.Background()))
+	t.Cleanup(func() { server.Close() })
+
+	a := startTCPTransport(t, TCPTransportOpts{})
+	b := startTCPTransport(t, TCPTransportOpts{})
+
+	assert.NoError(t, a.Connect(server))
+	assert.Eventually(t, func() bool { return len(server.Peers()) == 1 }, time.Second, 5*time.Millisecond)
+
+	// no inbound slot left for b
+	b.Connect(server)
+	assert.Eventually(t, func() bool { return len(b.Peers()) == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []NetAddr{a.ID()}, server.Peers())
+
+	// banning a frees its slot and keeps it out
+	m.Ban(a.ID(), time.Hour, "test")
+	assert.Eventually(t, func() bool { return len(server.Peers()) == 0 }, time.Second, 5*time.Millisecond)
+	inbound, _ := m.Slots()
+	assert.Equal(t, 0, inbound)
+
+	a.Connect(server)
+	assert.Eventually(t, func() bool { return len(a.Peers()) == 0 }, time.Second, 5*time.Millisecond)
+	assert.Empty(t, server.Peers())
+}
@@ -0,0 +1,141 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultBlockTime = 5 * time.Second
+
+// ProducerOpts configures a BlockProducer
+type ProducerOpts struct {
+	PrivateKey *crypto.PrivateKey // validator key used to sign produced blocks
+	BlockTime  time.Duration      // interval between blocks; defaults to 5s
+	Version    uint32             // header version of produced blocks; defaults to the tip's version, raised by upgrades
+	Logger     *logrus.Logger
+}
+
+// BlockProducer periodically assembles a block from the pending
+// transactions in the mempool and adds it to the blockchain
+type BlockProducer struct {
+	ProducerOpts
+	chain   *crypto.Blockchain
+	mempool *MemPool
+	trigger chan struct{} // buffered so that Trigger never blocks
+}
+
+func NewBlockProducer(chain *crypto.Blockchain, mempool *MemPool, opts ProducerOpts) *BlockProducer {
+	if opts.BlockTime == 0 {
+		opts.BlockTime = defaultBlockTime
+	}
+	if opts.Logger == nil {
+		opts.Logger = logrus.New()
+	}
+
+	return &BlockProducer{
+		ProducerOpts: opts,
+		chain:        chain,
+		mempool:      mempool,
+		trigger:      make(chan struct{}, 1),
+	}
+}
+
+// Start produces a block every BlockTime, or earlier when triggered,
+// until ctx is cancelled
+func (p *BlockProducer) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.BlockTime)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.trigger:
+		}
+
+		if _, err := p.ProduceBlock(); err != nil {
+			p.Logger.WithError(err).Error("failed to produce block")
+		}
+	}
+}
+
+// Trigger asks the producer to build a block without waiting for the next tick.
+// Triggers that arrive while one is already queued are coalesced
+func (p *BlockProducer) Trigger() {
+	select {
+	case p.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// ProduceBlock builds a block on top of the current chain tip from the
+// pending transactions, signs it and adds it to the blockchain.
+// When a block imported from the network takes the tip first, the block
+// is rebuilt on the new tip. Only the transactions included in the block
+// are removed from the mempool
+func (p *BlockProducer) ProduceBlock() (*crypto.Block, error) {
+	for {
+		b, err := p.buildBlock()
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.chain.AddBlock(b)
+		if err == nil {
+			hashes := make([]crypto.Hash, len(b.Transactions))
+			for i, tx := range b.Transactions {
+				hashes[i] = tx.Hash(crypto.TxHash{})
+			}
+			p.mempool.Remove(hashes...)
+
+			return b, nil
+		}
+		if !tipMoved(err) {
+			return nil, err
+		}
+
+		p.Logger.WithField("height", b.Header.Height).Debug("chain tip moved while producing, rebuilding block")
+	}
+}
+
+// buildBlock assembles and signs a block on top of the current chain tip
+func (p *BlockProducer) buildBlock() (*crypto.Block, error) {
+	height := p.chain.GetBlockchainHeight()
+	tip, err := p.chain.GetHeaderByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+
+	version := p.Version
+	if version == 0 {
+		version = tip.Version
+	}
+	config := p.chain.Config()
+	if required := config.VersionAt(height + 1); version < required {
+		version = required
+	}
+
+	header := &crypto.Header{
+		Version:       version,
+		PrevBlockHash: crypto.BlockHash{}.Hash(tip),
+		Height:        height + 1,
+		Timestamp:     time.Now().UnixNano(),
+	}
+
+	params := config.ParamsAt(height + 1)
+	b := crypto.BuildBlock(params, header, p.mempool.GetPendingTx())
+	b.Sign(p.PrivateKey)
+
+	return b, nil
+}
+
+// tipMoved tells whether err rejected a block because another block was
+// added on the tip it was built on
+func tipMoved(err error) bool {
+	return errors.Is(err, crypto.ErrBlockConflict) || errors.Is(err, crypto.ErrPrevHashMismatch)
+}
@@ -0,0 +1,125 @@
+package network
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// helper function creating a blockchain with a signed genesis block
+func newTestChain(t *testing.T, config *crypto.ChainConfig) *crypto.Blockchain {
+	validator, err := crypto.GeneratePrivateKey()
+	assert.NoError(t, err)
+
+	genesis := crypto.NewSignedBlockExample(validator, []*crypto.Transaction{}, 0, crypto.Hash{})
+	return crypto.NewBlockchainWithConfig(logrus.New(), config, genesis)
+}
+
+func TestBlockProducer_ProduceBlock(t *testing.T) {
+	chain := newTestChain(t, crypto.DefaultChainConfig())
+	pool := NewMempool(10)
+	for i := 0; i < 3; i++ {
+		pool.Add(crypto.NewTxWithSignature([]byte("hello world" + strconv.Itoa(i))))
+	}
+
+	validator, _ := crypto.GeneratePrivateKey()
+	producer := NewBlockProducer(chain, pool, ProducerOpts{PrivateKey: validator})
+
+	b, err := producer.ProduceBlock()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), chain.GetBlockchainHeight())
+	assert.Len(t, b.Transactions, 3)
+	assert.Equal(t, validator.PublicKey(), b.Validator)
+
+	// included transactions leave the pending list
+	assert.Equal(t, 0, pool.PendingTxCount())
+}
+
+func TestBlockProducer_LeavesExcludedTransactions(t *testing.T) {
+	config := crypto.DefaultChainConfig()
+	config.Params.MaxTxsPerBlock = 2
+	chain := newTestChain(t, config)
+
+	pool := NewMempool(10)
+	for i := 0; i < 3; i++ {
+		pool.Add(crypto.NewTxWithSignature([]byte("hello world" + strconv.Itoa(i))))
+	}
+	last := pool.GetPendingTx()[2]
+
+	validator, _ := crypto.GeneratePrivateKey()
+	producer := NewBlockProducer(chain, pool, ProducerOpts{PrivateKey: validator})
+
+	b, err := producer.ProduceBlock()
+	assert.NoError(t, err)
+	assert.Len(t, b.Transactions, 2)
+
+	// the transaction that did not fit is still pending
+	assert.Equal(t, []*crypto.Transaction{last}, pool.GetPendingTx())
+}
+
+func TestBlockProducer_Trigger(t *testing.T) {
+	chain := newTestChain(t, crypto.DefaultChainConfig())
+	pool := NewMempool(10)
+	pool.Add(crypto.NewTxWithSignature([]byte("hello world")))
+
+	validator, _ := crypto.GeneratePrivateKey()
+	producer := NewBlockProducer(chain, pool, ProducerOpts{
+		PrivateKey: validator,
+		BlockTime:  time.Hour,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go producer.Start(ctx)
+
+	producer.Trigger()
+	assert.Eventually(t, func() bool {
+		return chain.GetBlockchainHeight() == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBlockProducer_RebuildsOnNewTip(t *testing.T) {
+	chain := newTestChain(t, crypto.DefaultChainConfig())
+
+	// two producers race for every height, as block production does with
+	// the blocks imported from the network
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		validator, _ := crypto.GeneratePrivateKey()
+		producer := NewBlockProducer(chain, NewMempool(10), ProducerOpts{PrivateKey: validator})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				_, err := producer.ProduceBlock()
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, uint32(40), chain.GetBlockchainHeight())
+}
+
+func TestBlockProducer_FollowsUpgrades(t *testing.T) {
+	params := crypto.DefaultParams()
+	params.MaxTxsPerBlock = 1
+	chain := newTestChain(t, crypto.NewChainConfig(0, crypto.DefaultParams(), crypto.Upgrade{Height: 1, Version: 2, Params: params}))
+	pool := NewMempool(10)
+	for i := 0; i < 2; i++ {
+		pool.Add(crypto.NewTxWithSignature([]byte("hello world" + strconv.Itoa(i))))
+	}
+
+	// the version of the tip is raised to the one required by the upgrade
+	validator, _ := crypto.GeneratePrivateKey()
+	b, err := NewBlockProducer(chain, pool, ProducerOpts{PrivateKey: validator}).ProduceBlock()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), b.Header.Version)
+	assert.Len(t, b.Transactions, 1)
+}
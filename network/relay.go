@@ -0,0 +1,375 @@
+package network
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/majorshift/safari-chain/events"
+	"github.com/majorshift/safari-chain/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRecentBlocks  = 64
+	defaultPendingBlocks = 16
+	defaultKnownBlocks   = 1024
+	defaultRelayQueue    = 64
+)
+
+// BlockRelayOpts configures a BlockRelay
+type BlockRelayOpts struct {
+	RecentBlocks  int // relayed blocks kept to answer requests for their transactions; defaults to 64
+	PendingBlocks int // compact blocks waiting for missing transactions; defaults to 16
+	KnownBlocks   int // block hashes remembered per peer to avoid echoing them back; defaults to 1024
+	Queue         int // blocks added to the chain waiting to be relayed; defaults to 64
+	// Tracker is told about peers relaying blocks above the tip, so that
+	// the blocks in between are synced; optional
+	Tracker HeightTracker
+	Logger  *logrus.Logger
+}
+
+// HeightTracker keeps track of the heights of the peers' chains
+type HeightTracker interface {
+	// UpdatePeerHeight records that the peer at addr has a block at height
+	UpdatePeerHeight(addr NetAddr, height uint32)
+}
+
+// BlockRelay propagates the blocks added to the chain as compact blocks:
+// the signed header followed by short ids of the transactions. Receivers
+// check the header signature first, rebuild the block from the transactions
+// already in their mempool and fetch only the missing ones from the sender
+type BlockRelay struct {
+	BlockRelayOpts
+	transport Transport
+	chain     *crypto.Blockchain
+	mempool   *MemPool
+	lock      sync.Mutex
+	known     map[NetAddr]*knownCache
+	recent    *types.OrderedMap[crypto.Hash, *crypto.Block]   // relayed blocks by header hash
+	pending   *types.OrderedMap[crypto.Hash, *compactPending] // blocks waiting for transactions
+	added     *events.Subscription[crypto.BlockAdded]         // blocks added to the chain, relayed by Start
+}
+
+// compactPending is a compact block waiting for its missing transactions
+type compactPending struct {
+	from    NetAddr
+	block   *crypto.Block // has nil transactions at the missing indexes
+	missing []uint32
+	full    bool // every transaction was requested after the short ids failed
+}
+
+// NewBlockRelay creates a relay and subscribes it to the events of chain,
+// so that every block added to the chain is relayed to the peers once
+// Start runs. Blocks added while Queue blocks already wait are not relayed
+func NewBlockRelay(transport Transport, chain *crypto.Blockchain, mempool *MemPool, opts BlockRelayOpts) *BlockRelay {
+	if opts.RecentBlocks == 0 {
+		opts.RecentBlocks = defaultRecentBlocks
+	}
+	if opts.PendingBlocks == 0 {
+		opts.PendingBlocks = defaultPendingBlocks
+	}
+	if opts.KnownBlocks == 0 {
+		opts.KnownBlocks = defaultKnownBlocks
+	}
+	if opts.Queue == 0 {
+		opts.Queue = defaultRelayQueue
+	}
+	if opts.Logger == nil {
+		opts.Logger = logrus.New()
+	}
+
+	return &BlockRelay{
+		BlockRelayOpts: opts,
+		transport:      transport,
+		chain:          chain,
+		mempool:        mempool,
+		known:          make(map[NetAddr]*knownCache),
+		recent:         types.NewOrderedMap[crypto.Hash, *crypto.Block](),
+		pending:        types.NewOrderedMap[crypto.Hash, *compactPending](),
+		added:          events.Subscribe[crypto.BlockAdded](chain.Events(), opts.Queue),
+	}
+}
+
+// Start relays the blocks added to the chain until ctx is cancelled.
+// Sending runs apart from the chain, so a slow peer never holds up AddBlock
+func (r *BlockRelay) Start(ctx context.Context) {
+	defer r.added.Unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-r.added.Events():
+			r.Relay(ev.Block)
+		}
+	}
+}
+
+// ShortTxID returns the short id identifying the transaction with hash
+// txHash within the block with header hash blockHash. Keying the id by
+// block makes collisions differ from one block to the next
+func ShortTxID(blockHash, txHash crypto.Hash) uint64 {
+	h := sha256.New()
+	h.Write(blockHash[:])
+	h.Write(txHash[:])
+
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// Relay sends b as a compact block to every peer not known to have it
+func (r *BlockRelay) Relay(b *crypto.Block) {
+	hash := b.Hash(crypto.BlockHash{})
+
+	r.lock.Lock()
+	r.recent.Set(hash, b)
+	for r.recent.Len() > r.RecentBlocks {
+		oldest, _, _ := r.recent.Oldest()
+		r.recent.Delete(oldest)
+	}
+
+	r.syncPeers()
+	var targets []NetAddr
+	for addr, known := range r.known {
+		if !known.contains(hash) {
+			known.add(hash)
+			targets = append(targets, addr)
+		}
+	}
+	r.lock.Unlock()
+
+	if len(targets) == 0 {
+		return
+	}
+
+	msg := &CompactBlockMessage{
+		Header:    b.Header,
+		Validator: b.Validator,
+		Signature: b.Signature,
+		ShortIDs:  make([]uint64, len(b.Transactions)),
+	}
+	for i, tx := range b.Transactions {
+		msg.ShortIDs[i] = ShortTxID(hash, tx.Hash(crypto.TxHash{}))
+	}
+	payload, err := EncodeMessage(msg)
+	if err != nil {
+		r.Logger.WithError(err).Error("failed to encode compact block")
+		return
+	}
+
+	for _, addr := range targets {
+		if err := r.transport.SendMessage(addr, payload); err != nil {
+			r.Logger.WithField("peer", addr).WithError(err).Debug("failed to relay block")
+		}
+	}
+}
+
+// HandleMessage processes a block relay message received from the peer from.
+// Only compact block, get block txs and block txs messages are accepted
+func (r *BlockRelay) HandleMessage(from NetAddr, msg *Message) error {
+	p, err := msg.DecodePayload()
+	if err != nil {
+		return err
+	}
+
+	switch p := p.(type) {
+	case *CompactBlockMessage:
+		return r.handleCompactBlock(from, p)
+	case *GetBlockTxsMessage:
+		return r.handleGetBlockTxs(from, p)
+	case *BlockTxsMessage:
+		return r.handleBlockTxs(from, p)
+	default:
+		return fmt.Errorf("block relay cannot handle %s message", msg.Type)
+	}
+}
+
+// handleCompactBlock rebuilds the announced block from the mempool,
+// requesting the transactions it lacks from the sender
+func (r *BlockRelay) handleCompactBlock(from NetAddr, m *CompactBlockMessage) error {
+	hash := crypto.BlockHash{}.Hash(m.Header)
+
+	r.lock.Lock()
+	r.peerKnown(from).add(hash)
+	_, waiting := r.pending.Get(hash)
+	r.lock.Unlock()
+
+	if waiting || r.haveBlock(hash, m.Header.Height) {
+		return nil
+	}
+
+	// check the header before spending any effort on the body
+	if m.Signature == nil || m.Validator == nil || !m.Signature.Verify(m.Validator, m.Header.ToBytes()) {
+		return &crypto.BlockError{Height: m.Header.Height, Hash: hash, Err: crypto.ErrBlockInvalidSignature}
+	}
+
+	// index the pool by short id; ids shared by several transactions are ambiguous
+	byID := make(map[uint64]*crypto.Transaction)
+	for _, tx := range r.mempool.GetAllTx() {
+		id := ShortTxID(hash, tx.Hash(crypto.TxHash{}))
+		if _, ok := byID[id]; ok {
+			byID[id] = nil
+			continue
+		}
+		byID[id] = tx
+	}
+
+	block := &crypto.Block{
+		Header:       m.Header,
+		Validator:    m.Validator,
+		Signature:    m.Signature,
+		Transactions: make([]*crypto.Transaction, len(m.ShortIDs)),
+	}
+	var missing []uint32
+	for i, id := range m.ShortIDs {
+		if tx := byID[id]; tx != nil {
+			block.Transactions[i] = tx
+		} else {
+			missing = append(missing, uint32(i))
+		}
+	}
+
+	if len(missing) == 0 {
+		return r.complete(from, block, false)
+	}
+
+	return r.request(from, block, missing, false)
+}
+
+// request asks from for the transactions of block at the missing indexes
+func (r *BlockRelay) request(from NetAddr, block *crypto.Block, missing []uint32, full bool) error {
+	hash := block.Hash(crypto.BlockHash{})
+
+	r.lock.Lock()
+	r.pending.Set(hash, &compactPending{from: from, block: block, missing: missing, full: full})
+	for r.pending.Len() > r.PendingBlocks {
+		oldest, _, _ := r.pending.Oldest()
+		r.pending.Delete(oldest)
+	}
+	r.lock.Unlock()
+
+	payload, err := EncodeMessage(&GetBlockTxsMessage{Hash: hash, Indexes: missing})
+	if err != nil {
+		return err
+	}
+
+	return r.transport.SendMessage(from, payload)
+}
+
+// complete adds a rebuilt block to the chain. A merkle root mismatch
+// means a short id matched the wrong transaction, so the whole body is
+// requested once before giving up on the block. A block above the tip or
+// on another branch means the chain of the peer is ahead, which the
+// Tracker is told about
+func (r *BlockRelay) complete(from NetAddr, block *crypto.Block, full bool) error {
+	err := r.chain.AddBlock(block)
+	switch {
+	case err == nil || crypto.IsBenign(err):
+		return nil
+	case errors.Is(err, crypto.ErrBlockTooHigh), errors.Is(err, crypto.ErrPrevHashMismatch):
+		if r.Tracker != nil {
+			r.Tracker.UpdatePeerHeight(from, block.Header.Height)
+		}
+		return nil
+	case errors.Is(err, crypto.ErrBlockConflict) && block.Header.Height == r.chain.GetBlockchainHeight():
+		// a competing block for our tip, produced at the same time as it
+		return nil
+	case errors.Is(err, crypto.ErrMerkleRootMismatch) && !full:
+		all := make([]uint32, len(block.Transactions))
+		for i := range all {
+			all[i] = uint32(i)
+		}
+		return r.request(from, block, all, true)
+	default:
+		return err
+	}
+}
+
+// handleGetBlockTxs answers with the requested transactions of a relayed block
+func (r *BlockRelay) handleGetBlockTxs(from NetAddr, m *GetBlockTxsMessage) error {
+	r.lock.Lock()
+	block, ok := r.recent.Get(m.Hash)
+	r.lock.Unlock()
+
+	if !ok {
+		return fmt.Errorf("transactions requested for block %s: %w", m.Hash.ToString(), crypto.ErrUnknownBlock)
+	}
+
+	txs := make([]*crypto.Transaction, len(m.Indexes))
+	for i, index := range m.Indexes {
+		if int(index) >= len(block.Transactions) {
+			return fmt.Errorf("%w: transaction index %d out of range", ErrMalformedMessage, index)
+		}
+		txs[i] = block.Transactions[index]
+	}
+
+	payload, err := EncodeMessage(&BlockTxsMessage{Hash: m.Hash, Txs: txs})
+	if err != nil {
+		return err
+	}
+
+	return r.transport.SendMessage(from, payload)
+}
+
+// handleBlockTxs fills in the transactions missing from a pending compact block
+func (r *BlockRelay) handleBlockTxs(from NetAddr, m *BlockTxsMessage) error {
+	r.lock.Lock()
+	pending, ok := r.pending.Get(m.Hash)
+	if !ok || pending.from != from {
+		// unsolicited or already given up on
+		r.lock.Unlock()
+		return nil
+	}
+	r.pending.Delete(m.Hash)
+	r.lock.Unlock()
+
+	if len(m.Txs) != len(pending.missing) {
+		return fmt.Errorf("%w: got %d of %d requested transactions", ErrMalformedMessage, len(m.Txs), len(pending.missing))
+	}
+	for i, index := range pending.missing {
+		pending.block.Transactions[index] = m.Txs[i]
+	}
+
+	return r.complete(from, pending.block, pending.full)
+}
+
+// haveBlock reports whether the chain holds the block with the given hash
+func (r *BlockRelay) haveBlock(hash crypto.Hash, height uint32) bool {
+	header, err := r.chain.GetHeaderByHeight(height)
+	if err != nil {
+		return false
+	}
+
+	return crypto.BlockHash{}.Hash(header) == hash
+}
+
+// syncPeers starts tracking newly connected peers and forgets the
+// ones that went away. Callers hold the lock
+func (r *BlockRelay) syncPeers() {
+	connected := make(map[NetAddr]bool)
+	for _, addr := range r.transport.Peers() {
+		connected[addr] = true
+		r.peerKnown(addr)
+	}
+	for addr := range r.known {
+		if !connected[addr] {
+			delete(r.known, addr)
+		}
+	}
+}
+
+// peerKnown returns the block hashes known to the peer at addr.
+// Callers hold the lock
+func (r *BlockRelay) peerKnown(addr NetAddr) *knownCache {
+	known, ok := r.known[addr]
+	if !ok {
+		known = newKnownCache(r.KnownBlocks)
+		r.known[addr] = known
+	}
+
+	return known
+}
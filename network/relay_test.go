@@ -0,0 +1,218 @@
+package network
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type relayNode struct {
+	transport *LocalTransport
+	chain     *crypto.Blockchain
+	pool      *MemPool
+	relay     *BlockRelay
+	received  chan MessageType // types of the messages delivered to the node
+}
+
+// helper function creating relay nodes sharing the same genesis block
+func newRelayNodes(t *testing.T, addrs ...NetAddr) []*relayNode {
+	validator, _ := crypto.GeneratePrivateKey()
+	genesis := crypto.NewSignedBlockExample(validator, []*crypto.Transaction{}, 0, crypto.Hash{})
+
+	var nodes []*relayNode
+	for _, addr := range addrs {
+		n := &relayNode{
+			transport: NewLocalTransport(addr),
+			chain:     crypto.NewBlockchain(logrus.New(), genesis),
+			pool:      NewMempool(100),
+			received:  make(chan MessageType, 100),
+		}
+		n.relay = NewBlockRelay(n.transport, n.chain, n.pool, BlockRelayOpts{})
+		pumpRelay(t, n)
+		nodes = append(nodes, n)
+	}
+
+	return nodes
+}
+
+// helper function running the relay of n and delivering the messages it
+// receives to it until the test ends
+func pumpRelay(t *testing.T, n *relayNode) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go n.relay.Start(ctx)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case rpc := <-n.transport.Consume():
+				msg, err := DecodeMessage(rpc.Payload)
+				if err != nil {
+					continue
+				}
+				n.received <- msg.Type
+				n.relay.HandleMessage(rpc.From, msg)
+			}
+		}
+	}()
+}
+
+// helper function building a block of txs on top of the tip of chain
+func relayBlock(t *testing.T, chain *crypto.Blockchain, txs []*crypto.Transaction) *crypto.Block {
+	validator, _ := crypto.GeneratePrivateKey()
+	tip, err := chain.GetHeaderByHeight(chain.GetBlockchainHeight())
+	assert.NoError(t, err)
+
+	return crypto.NewSignedBlockExample(validator, txs, tip.Height+1, crypto.BlockHash{}.Hash(tip))
+}
+
+func exampleTxs(n int) []*crypto.Transaction {
+	var txs []*crypto.Transaction
+	for i := 0; i < n; i++ {
+		txs = append(txs, crypto.NewTxWithSignature([]byte("relay"+strconv.Itoa(i))))
+	}
+
+	return txs
+}
+
+func TestBlockRelay_RebuildsFromMempool(t *testing.T) {
+	nodes := newRelayNodes(t, "a", "b")
+	a, b := nodes[0], nodes[1]
+	assert.NoError(t, a.transport.Connect(b.transport))
+
+	// b already has every transaction through gossip
+	txs := exampleTxs(3)
+	for _, tx := range txs {
+		assert.NoError(t, b.pool.Add(tx))
+	}
+
+	assert.NoError(t, a.chain.AddBlock(relayBlock(t, a.chain, txs)))
+	assert.Eventually(t, func() bool { return b.chain.GetBlockchainHeight() == 1 }, time.Second, 5*time.Millisecond)
+
+	// only the compact block travelled; nothing was requested or echoed back
+	assert.Equal(t, MessageCompactBlock, <-b.received)
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, a.received)
+	assert.Empty(t, b.received)
+}
+
+func TestBlockRelay_FetchesMissingTransactions(t *testing.T) {
+	nodes := newRelayNodes(t, "a", "b")
+	a, b := nodes[0], nodes[1]
+	assert.NoError(t, a.transport.Connect(b.transport))
+
+	txs := exampleTxs(4)
+	assert.NoError(t, b.pool.Add(txs[0]))
+	assert.NoError(t, b.pool.Add(txs[2]))
+
+	block := relayBlock(t, a.chain, txs)
+	assert.NoError(t, a.chain.AddBlock(block))
+	assert.Eventually(t, func() bool { return b.chain.GetBlockchainHeight() == 1 }, time.Second, 5*time.Millisecond)
+
+	got, err := b.chain.GetBlockByHeight(1)
+	assert.NoError(t, err)
+	assert.Equal(t, block.Hash(crypto.BlockHash{}), got.Hash(crypto.BlockHash{}))
+	assert.Equal(t, MessageGetBlockTxs, <-a.received)
+}
+
+func TestBlockRelay_PropagatesAcrossNodes(t *testing.T) {
+	nodes := newRelayNodes(t, "a", "b", "c")
+	assert.NoError(t, nodes[0].transport.Connect(nodes[1].transport))
+	assert.NoError(t, nodes[1].transport.Connect(nodes[2].transport))
+
+	a := nodes[0]
+	assert.NoError(t, a.chain.AddBlock(relayBlock(t, a.chain, exampleTxs(2))))
+	assert.NoError(t, a.chain.AddBlock(relayBlock(t, a.chain, exampleTxs(1))))
+
+	assert.Eventually(t, func() bool {
+		return nodes[1].chain.GetBlockchainHeight() == 2 && nodes[2].chain.GetBlockchainHeight() == 2
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestBlockRelay_RejectsForgedHeader(t *testing.T) {
+	nodes := newRelayNodes(t, "a")
+	a := nodes[0]
+
+	block := relayBlock(t, a.chain, exampleTxs(1))
+	block.Header.Timestamp++ // no longer matches the signature
+
+	err := a.relay.handleCompactBlock("b", &CompactBlockMessage{
+		Header:    block.Header,
+		Validator: block.Validator,
+		Signature: block.Signature,
+		ShortIDs:  []uint64{1},
+	})
+	assert.ErrorIs(t, err, crypto.ErrBlockInvalidSignature)
+}
+
+func TestBlockRelay_RequestsWholeBodyOnShortIDMismatch(t *testing.T) {
+	nodes := newRelayNodes(t, "a")
+	a := nodes[0]
+	peer := NewLocalTransport("b")
+	assert.NoError(t, a.transport.Connect(peer))
+
+	txs := exampleTxs(2)
+	block := relayBlock(t, a.chain, txs)
+	hash := block.Hash(crypto.BlockHash{})
+
+	// the pool holds a transaction matching the first short id but not the block
+	impostor := crypto.NewTxWithSignature([]byte("impostor"))
+	assert.NoError(t, a.pool.Add(impostor))
+	assert.NoError(t, a.pool.Add(txs[1]))
+
+	err := a.relay.handleCompactBlock("b", &CompactBlockMessage{
+		Header:    block.Header,
+		Validator: block.Validator,
+		Signature: block.Signature,
+		ShortIDs: []uint64{
+			ShortTxID(hash, impostor.Hash(crypto.TxHash{})),
+			ShortTxID(hash, txs[1].Hash(crypto.TxHash{})),
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &GetBlockTxsMessage{Hash: hash, Indexes: []uint32{0, 1}}, nextPayload(t, peer))
+
+	assert.NoError(t, a.relay.handleBlockTxs("b", &BlockTxsMessage{Hash: hash, Txs: txs}))
+	assert.Equal(t, uint32(1), a.chain.GetBlockchainHeight())
+}
+
+// stuckTransport is a transport whose sends wait until release is closed
+type stuckTransport struct {
+	*LocalTransport
+	release chan struct{}
+}
+
+func (tr *stuckTransport) SendMessage(addr NetAddr, payload []byte) error {
+	<-tr.release
+	return tr.LocalTransport.SendMessage(addr, payload)
+}
+
+func TestBlockRelay_SlowPeerDoesNotHoldUpChain(t *testing.T) {
+	validator, _ := crypto.GeneratePrivateKey()
+	genesis := crypto.NewSignedBlockExample(validator, []*crypto.Transaction{}, 0, crypto.Hash{})
+	chain := crypto.NewBlockchain(logrus.New(), genesis)
+
+	tr := &stuckTransport{LocalTransport: NewLocalTransport("a"), release: make(chan struct{})}
+	peer := NewLocalTransport("b")
+	assert.NoError(t, tr.Connect(peer))
+	relay := NewBlockRelay(tr, chain, NewMempool(10), BlockRelayOpts{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go relay.Start(ctx)
+
+	// blocks are added while the peer is not reading, then relayed
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, chain.AddBlock(relayBlock(t, chain, exampleTxs(1))))
+	}
+	close(tr.release)
+	for i := 0; i < 3; i++ {
+		assert.IsType(t, &CompactBlockMessage{}, nextPayload(t, peer))
+	}
+}
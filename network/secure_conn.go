@@ -0,0 +1,236 @@
+package network
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/majorshift/safari-chain/crypto"
+)
+
+const (
+	handshakeProtocol = "safari-chain/handshake/1"
+	helloSize         = 32 + 32 // ephemeral X25519 key followed by the static ed25519 key
+	sessionKeySize    = 32      // AES-256
+)
+
+var (
+	// ErrHandshakeFailed is returned when a peer does not complete the handshake correctly
+	ErrHandshakeFailed = errors.New("handshake failed")
+	// ErrUnexpectedPeer is returned when a peer authenticates with another identity than expected
+	ErrUnexpectedPeer = errors.New("unexpected peer identity")
+)
+
+// secureConn exchanges encrypted frames over a connection once the
+// handshake has authenticated the remote node.
+//
+// The handshake follows a Noise-like pattern: both sides send an ephemeral
+// X25519 key together with their static ed25519 identity key, derive a pair
+// of directional AES-GCM keys from the X25519 secret with HKDF-SHA256, then
+// prove ownership of the identity key by sending a signature over the
+// transcript as their first encrypted message.
+//
+// writeMsg and readMsg are each not safe for concurrent use; the transport
+// serialises writes with the peer write lock and reads from a single goroutine
+type secureConn struct {
+	conn      io.ReadWriter
+	remote    *crypto.PublicKey
+	send      cipher.AEAD
+	recv      cipher.AEAD
+	sendNonce uint64
+	recvNonce uint64
+	maxFrame  int // largest plaintext accepted by readMsg
+}
+
+// handshake authenticates the remote end of conn using the node key priv.
+// The initiator is the side that dialled. When expected is not nil the
+// remote node must authenticate with that key
+func handshake(conn io.ReadWriter, priv *crypto.PrivateKey, initiator bool, expected *crypto.PublicKey, maxFrame int) (*secureConn, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	local := make([]byte, 0, helloSize)
+	local = append(local, ephemeral.PublicKey().Bytes()...)
+	local = append(local, priv.PublicKey().ToBytes()...)
+
+	// the initiator speaks first so the exchange also works over
+	// unbuffered connections
+	var remote []byte
+	if initiator {
+		if err := writeFrame(conn, local); err != nil {
+			return nil, err
+		}
+	}
+	if remote, err = readFrame(conn, helloSize); err != nil {
+		return nil, err
+	}
+	if len(remote) != helloSize {
+		return nil, fmt.Errorf("%w: malformed hello", ErrHandshakeFailed)
+	}
+	if !initiator {
+		if err := writeFrame(conn, local); err != nil {
+			return nil, err
+		}
+	}
+
+	remoteStatic := &crypto.PublicKey{Key: remote[32:]}
+	if bytes.Equal(remoteStatic.Key, priv.PublicKey().Key) {
+		return nil, fmt.Errorf("%w: connected to self", ErrHandshakeFailed)
+	}
+	if expected != nil && !bytes.Equal(remoteStatic.Key, expected.Key) {
+		return nil, fmt.Errorf("%w: got %s", ErrUnexpectedPeer, PeerID(remoteStatic))
+	}
+
+	remoteEphemeral, err := ecdh.X25519().NewPublicKey(remote[:32])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHandshakeFailed, err)
+	}
+	// fails on low order points yielding an all zero secret
+	secret, err := ephemeral.ECDH(remoteEphemeral)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHandshakeFailed, err)
+	}
+
+	// the transcript binds both hellos in initiator, responder order
+	initHello, respHello := local, remote
+	if !initiator {
+		initHello, respHello = remote, local
+	}
+	transcript := sha256.New()
+	transcript.Write([]byte(handshakeProtocol))
+	transcript.Write(initHello)
+	transcript.Write(respHello)
+	h := transcript.Sum(nil)
+
+	keys := hkdf(secret, h, []byte(handshakeProtocol), 2*sessionKeySize)
+	initKey, respKey := keys[:sessionKeySize], keys[sessionKeySize:]
+
+	// only the signature is accepted until the handshake completes
+	c := &secureConn{conn: conn, remote: remoteStatic, maxFrame: ed25519.SignatureSize}
+	sendKey, recvKey := initKey, respKey
+	if !initiator {
+		sendKey, recvKey = respKey, initKey
+	}
+	if c.send, err = newAEAD(sendKey); err != nil {
+		return nil, err
+	}
+	if c.recv, err = newAEAD(recvKey); err != nil {
+		return nil, err
+	}
+
+	// prove ownership of the static key; the role byte keeps a signature
+	// from being reflected back to its author
+	sig := priv.Sign(authMessage(h, initiator)).ToBytes()
+	if initiator {
+		if err := c.writeMsg(sig); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.verifyAuth(authMessage(h, !initiator)); err != nil {
+		return nil, err
+	}
+	if !initiator {
+		if err := c.writeMsg(sig); err != nil {
+			return nil, err
+		}
+	}
+	c.maxFrame = maxFrame
+
+	return c, nil
+}
+
+// verifyAuth reads the remote signature over msg and checks it
+// against the remote identity key
+func (c *secureConn) verifyAuth(msg []byte) error {
+	sig, err := c.readMsg()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrHandshakeFailed, err)
+	}
+	if len(sig) != ed25519.SignatureSize || !crypto.BytesToSignature(sig).Verify(c.remote, msg) {
+		return fmt.Errorf("%w: invalid identity signature", ErrHandshakeFailed)
+	}
+
+	return nil
+}
+
+// writeMsg encrypts payload and writes it as a single frame
+func (c *secureConn) writeMsg(payload []byte) error {
+	sealed := c.send.Seal(nil, nonce(c.sendNonce), payload, nil)
+	c.sendNonce++
+
+	return writeFrame(c.conn, sealed)
+}
+
+// readMsg reads and decrypts the next frame. Frames that fail to
+// authenticate are reported as errors; the connection is unusable after
+func (c *secureConn) readMsg() ([]byte, error) {
+	sealed, err := readFrame(c.conn, c.maxFrame+c.recv.Overhead())
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := c.recv.Open(sealed[:0], nonce(c.recvNonce), sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt frame: %w", err)
+	}
+	c.recvNonce++
+
+	return payload, nil
+}
+
+// authMessage is the message a side signs to authenticate itself
+func authMessage(transcript []byte, initiator bool) []byte {
+	role := byte(0)
+	if initiator {
+		role = 1
+	}
+
+	return append(append([]byte("auth"), transcript...), role)
+}
+
+// nonce returns the AES-GCM nonce for the n-th message in one direction
+func nonce(n uint64) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint64(b[4:], n)
+
+	return b
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// hkdf derives length bytes from secret as specified by RFC 5869 with SHA-256
+func hkdf(secret, salt, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	var out, prev []byte
+	for i := byte(1); len(out) < length; i++ {
+		expand := hmac.New(sha256.New, prk)
+		expand.Write(prev)
+		expand.Write(info)
+		expand.Write([]byte{i})
+		prev = expand.Sum(nil)
+		out = append(out, prev...)
+	}
+
+	return out[:length]
+}
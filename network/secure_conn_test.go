@@ -0,0 +1,183 @@
+package network
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+type handshakeResult struct {
+	conn *secureConn
+	err  error
+}
+
+// helper function running both sides of the handshake over an in-memory pipe.
+// expected is the identity the initiator requires of the responder
+func runHandshake(t *testing.T, initKey, respKey *crypto.PrivateKey, expected *crypto.PublicKey) (handshakeResult, handshakeResult, net.Conn) {
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+
+	respCh := make(chan handshakeResult, 1)
+	go func() {
+		c, err := handshake(b, respKey, false, nil, 1024)
+		if err != nil {
+			b.Close()
+		}
+		respCh <- handshakeResult{c, err}
+	}()
+
+	c, err := handshake(a, initKey, true, expected, 1024)
+	if err != nil {
+		a.Close()
+	}
+
+	return handshakeResult{c, err}, <-respCh, a
+}
+
+func generateKey(t *testing.T) *crypto.PrivateKey {
+	key, err := crypto.GeneratePrivateKey()
+	assert.NoError(t, err)
+
+	return key
+}
+
+func TestHandshake(t *testing.T) {
+	initKey, respKey := generateKey(t), generateKey(t)
+
+	init, resp, _ := runHandshake(t, initKey, respKey, respKey.PublicKey())
+	assert.NoError(t, init.err)
+	assert.NoError(t, resp.err)
+
+	// each side learns the identity of the other
+	assert.Equal(t, respKey.PublicKey().Key, init.conn.remote.Key)
+	assert.Equal(t, initKey.PublicKey().Key, resp.conn.remote.Key)
+
+	// messages flow both ways
+	for _, msg := range []string{"first", "second", ""} {
+		go init.conn.writeMsg([]byte(msg))
+		payload, err := resp.conn.readMsg()
+		assert.NoError(t, err)
+		assert.Equal(t, msg, string(payload))
+
+		go resp.conn.writeMsg([]byte(msg))
+		payload, err = init.conn.readMsg()
+		assert.NoError(t, err)
+		assert.Equal(t, msg, string(payload))
+	}
+}
+
+func TestHandshake_UnexpectedIdentity(t *testing.T) {
+	init, resp, _ := runHandshake(t, generateKey(t), generateKey(t), generateKey(t).PublicKey())
+
+	assert.ErrorIs(t, init.err, ErrUnexpectedPeer)
+	assert.Error(t, resp.err)
+}
+
+func TestHandshake_Self(t *testing.T) {
+	key := generateKey(t)
+	init, resp, _ := runHandshake(t, key, key, nil)
+
+	assert.ErrorIs(t, init.err, ErrHandshakeFailed)
+	assert.ErrorIs(t, resp.err, ErrHandshakeFailed)
+}
+
+func TestHandshake_ForgedIdentity(t *testing.T) {
+	victim, attacker := generateKey(t), generateKey(t)
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	// the attacker claims the victim's key but can only sign with its own
+	go func() {
+		defer b.Close()
+		hello, err := readFrame(b, helloSize)
+		if err != nil {
+			return
+		}
+		handshake(&replayHello{b, hello, victim.PublicKey()}, attacker, false, nil, 1024)
+	}()
+
+	_, err := handshake(a, generateKey(t), true, victim.PublicKey(), 1024)
+	assert.ErrorIs(t, err, ErrHandshakeFailed)
+}
+
+// replayHello feeds an already read hello back to the handshake
+// and swaps the static key in the hello it writes
+type replayHello struct {
+	net.Conn
+	hello   []byte
+	claimed *crypto.PublicKey
+}
+
+func (r *replayHello) Read(p []byte) (int, error) {
+	if r.hello != nil {
+		frame := &frameBuffer{}
+		writeFrame(frame, r.hello)
+		r.hello = nil
+		r.Conn = &prefixConn{Conn: r.Conn, prefix: frame.data}
+	}
+
+	return r.Conn.Read(p)
+}
+
+func (r *replayHello) Write(p []byte) (int, error) {
+	if len(p) == 4+helloSize && r.claimed != nil {
+		forged := append([]byte{}, p...)
+		copy(forged[4+32:], r.claimed.Key)
+		r.claimed = nil
+		return r.Conn.Write(forged)
+	}
+
+	return r.Conn.Write(p)
+}
+
+type frameBuffer struct{ data []byte }
+
+func (f *frameBuffer) Write(p []byte) (int, error) {
+	f.data = append(f.data, p...)
+	return len(p), nil
+}
+
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(p []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(p, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+
+	return c.Conn.Read(p)
+}
+
+func TestSecureConn_RejectsTamperedFrames(t *testing.T) {
+	init, resp, raw := runHandshake(t, generateKey(t), generateKey(t), nil)
+	assert.NoError(t, init.err)
+	assert.NoError(t, resp.err)
+
+	// a frame sealed for another position in the stream fails to open
+	sealed := init.conn.send.Seal(nil, nonce(5), []byte("out of order"), nil)
+	go writeFrame(raw, sealed)
+
+	_, err := resp.conn.readMsg()
+	assert.Error(t, err)
+}
+
+func TestHKDF(t *testing.T) {
+	// RFC 5869, test case 1
+	ikm, _ := hex.DecodeString("0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b")
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+
+	okm := hkdf(ikm, salt, info, 42)
+	assert.Equal(t, "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865", hex.EncodeToString(okm))
+}
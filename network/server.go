@@ -0,0 +1,326 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/majorshift/safari-chain/events"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultServerMempoolSize = 4096
+	defaultJanitorInterval   = time.Minute
+)
+
+// ErrServerStarted is returned when starting a server twice
+var ErrServerStarted = errors.New("server already started")
+
+// ServerOpts configures a Server
+type ServerOpts struct {
+	Transport   Transport           // connects the node to its peers; a TCPTransport is started and closed with the server
+	Genesis     *crypto.Block       // first block of the chain; required
+	ChainConfig *crypto.ChainConfig // protocol parameters; defaults to crypto.DefaultChainConfig
+	// PrivateKey is the validator key; the node produces blocks when set
+	PrivateKey *crypto.PrivateKey
+	BlockTime  time.Duration // interval between produced blocks; defaults to 5s
+	// Mempool configures the pool; its State and ChainID are taken from the
+	// chain, its Bus defaults to the chain's and MaxSize defaults to 4096
+	Mempool   MempoolOpts
+	Gossip    TxGossipOpts
+	Relay     BlockRelayOpts
+	Sync      SyncOpts
+	Peers     PeerManagerOpts
+	Discovery DiscoveryOpts
+	Logger    *logrus.Logger // used by every component configured without a logger
+}
+
+// Server is a full node. It owns the blockchain, the mempool and the
+// components keeping them in sync with the network, and routes the
+// messages received over the transport to them by type. Every message
+// goes through the peer manager first, which rate limits the peers and
+// scores them by the errors their messages cause
+type Server struct {
+	ServerOpts
+	chain     *crypto.Blockchain
+	mempool   *MemPool
+	peers     *PeerManager
+	gossip    *TxGossip
+	relay     *BlockRelay
+	sync      *SyncManager
+	discovery *Discovery
+	metrics   *Metrics
+	producer  *BlockProducer // nil unless PrivateKey is set
+	lock      sync.Mutex
+	cancel    context.CancelFunc // stops the running server; nil when not started
+	wg        sync.WaitGroup
+}
+
+func NewServer(opts ServerOpts) (*Server, error) {
+	if opts.Transport == nil {
+		return nil, errors.New("server needs a transport")
+	}
+	if opts.Genesis == nil {
+		return nil, errors.New("server needs a genesis block")
+	}
+	if opts.ChainConfig == nil {
+		opts.ChainConfig = crypto.DefaultChainConfig()
+	}
+	if opts.Logger == nil {
+		opts.Logger = logrus.New()
+	}
+	if opts.Gossip.Logger == nil {
+		opts.Gossip.Logger = opts.Logger
+	}
+	if opts.Relay.Logger == nil {
+		opts.Relay.Logger = opts.Logger
+	}
+	if opts.Sync.Logger == nil {
+		opts.Sync.Logger = opts.Logger
+	}
+	if opts.Peers.Logger == nil {
+		opts.Peers.Logger = opts.Logger
+	}
+	if opts.Discovery.Logger == nil {
+		opts.Discovery.Logger = opts.Logger
+	}
+	if opts.Mempool.Logger == nil {
+		opts.Mempool.Logger = opts.Logger
+	}
+
+	s := &Server{}
+	s.chain = crypto.NewBlockchainWithConfig(opts.Logger, opts.ChainConfig, opts.Genesis)
+
+	if opts.Mempool.MaxSize == 0 {
+		opts.Mempool.MaxSize = defaultServerMempoolSize
+	}
+	opts.Mempool.ChainID = opts.ChainConfig.ChainID
+	if opts.Mempool.Bus == nil {
+		opts.Mempool.Bus = s.chain.Events()
+	}
+	s.mempool = NewMempoolWithOpts(opts.Mempool)
+	s.mempool.SubscribeChain(s.chain)
+
+	s.peers = NewPeerManager(opts.Transport, opts.Peers)
+	if tcp, ok := opts.Transport.(*TCPTransport); ok && tcp.Gater == nil {
+		tcp.Gater = s.peers
+	}
+	if opts.Sync.Reporter == nil {
+		opts.Sync.Reporter = s.peers
+	}
+
+	s.gossip = NewTxGossip(opts.Transport, s.mempool, opts.Gossip)
+	s.sync = NewSyncManager(opts.Transport, s.chain, opts.Sync)
+	if opts.Relay.Tracker == nil {
+		opts.Relay.Tracker = s.sync
+	}
+	s.relay = NewBlockRelay(opts.Transport, s.chain, s.mempool, opts.Relay)
+	s.discovery = NewDiscovery(opts.Transport, opts.Discovery)
+	s.metrics = NewMetrics(s.chain, s.mempool)
+	if opts.PrivateKey != nil {
+		s.producer = NewBlockProducer(s.chain, s.mempool, ProducerOpts{
+			PrivateKey: opts.PrivateKey,
+			BlockTime:  opts.BlockTime,
+			Logger:     opts.Logger,
+		})
+	}
+	s.ServerOpts = opts
+
+	return s, nil
+}
+
+// Chain returns the blockchain of the node
+func (s *Server) Chain() *crypto.Blockchain {
+	return s.chain
+}
+
+// Events returns the bus the chain publishes its events on, which the
+// mempool shares unless configured with a bus of its own
+func (s *Server) Events() *events.Bus {
+	return s.chain.Events()
+}
+
+// Mempool returns the pool of pending transactions of the node
+func (s *Server) Mempool() *MemPool {
+	return s.mempool
+}
+
+// Peers returns the peer manager of the node
+func (s *Server) Peers() *PeerManager {
+	return s.peers
+}
+
+// Sync returns the sync manager of the node
+func (s *Server) Sync() *SyncManager {
+	return s.sync
+}
+
+// Discovery returns the peer discovery of the node
+func (s *Server) Discovery() *Discovery {
+	return s.discovery
+}
+
+// Metrics returns the metrics of the node, counted while it runs
+func (s *Server) Metrics() *Metrics {
+	return s.metrics
+}
+
+// Start restores the persisted bans, addresses and local transactions,
+// starts the transport and every component and processes incoming
+// messages until ctx is cancelled or Stop is called
+func (s *Server) Start(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.cancel != nil {
+		return ErrServerStarted
+	}
+
+	if err := s.peers.LoadBans(); err != nil {
+		return fmt.Errorf("loading bans: %w", err)
+	}
+	if err := s.discovery.Book.Load(); err != nil {
+		return fmt.Errorf("loading address book: %w", err)
+	}
+	loaded, dropped, err := s.mempool.LoadJournal()
+	if err != nil {
+		return fmt.Errorf("loading transaction journal: %w", err)
+	}
+	if loaded+dropped > 0 {
+		s.Logger.WithFields(logrus.Fields{"loaded": loaded, "dropped": dropped}).Info("restored local transactions")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	if tcp, ok := s.Transport.(*TCPTransport); ok {
+		if err := tcp.Start(ctx); err != nil {
+			cancel()
+			return err
+		}
+	}
+	s.cancel = cancel
+
+	s.run(ctx, s.sync.Start)
+	s.run(ctx, s.gossip.Start)
+	s.run(ctx, s.relay.Start)
+	s.run(ctx, s.discovery.Start)
+	s.run(ctx, s.mempool.StartJournal)
+	s.run(ctx, s.metrics.Start)
+	if s.mempool.TTL > 0 {
+		s.run(ctx, func(ctx context.Context) { s.mempool.StartJanitor(ctx, defaultJanitorInterval) })
+	}
+	if s.producer != nil {
+		s.run(ctx, s.producer.Start)
+	}
+	s.run(ctx, s.readLoop)
+
+	s.Logger.WithFields(logrus.Fields{
+		"addr":      s.Transport.Addr(),
+		"height":    s.chain.GetBlockchainHeight(),
+		"validator": s.producer != nil,
+	}).Info("server started")
+
+	return nil
+}
+
+// Stop shuts the server down and waits for every component to finish,
+// saving the address book and closing the journal. It does nothing if
+// the server is not running
+func (s *Server) Stop() {
+	s.lock.Lock()
+	cancel := s.cancel
+	s.cancel = nil
+	s.lock.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	s.wg.Wait()
+	if tcp, ok := s.Transport.(*TCPTransport); ok {
+		tcp.Close()
+	}
+
+	s.Logger.Info("server stopped")
+}
+
+// SubmitTx adds a transaction submitted to this node to the mempool
+// and announces it to the peers
+func (s *Server) SubmitTx(tx *crypto.Transaction) error {
+	if err := s.mempool.AddLocal(tx); err != nil {
+		return err
+	}
+
+	s.gossip.Announce(tx.Hash(crypto.TxHash{}))
+	return nil
+}
+
+// run calls fn in its own goroutine, tracked by the wait group
+func (s *Server) run(ctx context.Context, fn func(context.Context)) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		fn(ctx)
+	}()
+}
+
+// readLoop handles the messages received over the transport
+func (s *Server) readLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case rpc := <-s.Transport.Consume():
+			if err := s.processRPC(rpc); err != nil {
+				s.Logger.WithField("peer", rpc.From).WithError(err).Debug("failed to process message")
+				s.peers.ReportError(rpc.From, err)
+			}
+		}
+	}
+}
+
+// processRPC decodes a message and hands it to the component in charge of
+// its type. Messages of banned or flooding peers are dropped
+func (s *Server) processRPC(rpc RPC) error {
+	if s.peers.IsBanned(rpc.From) || !s.peers.AllowMessage(rpc.From) {
+		return nil
+	}
+
+	msg, err := DecodeMessage(rpc.Payload)
+	if err != nil {
+		return err
+	}
+
+	return s.handleMessage(rpc.From, msg)
+}
+
+func (s *Server) handleMessage(from NetAddr, msg *Message) error {
+	switch msg.Type {
+	case MessageStatus, MessageBlockAnnounce, MessageGetHeaders, MessageHeaders, MessageGetBlocks, MessageBlocks:
+		return s.sync.HandleMessage(from, msg)
+	case MessageTxAnnounce, MessageTxRequest, MessageTxs:
+		return s.gossip.HandleMessage(from, msg)
+	case MessageCompactBlock, MessageGetBlockTxs, MessageBlockTxs:
+		return s.relay.HandleMessage(from, msg)
+	case MessageGetAddr, MessageAddr:
+		return s.discovery.HandleMessage(from, msg)
+	case MessagePing:
+		p, err := msg.DecodePayload()
+		if err != nil {
+			return err
+		}
+		payload, err := EncodeMessage(&PongMessage{Nonce: p.(*PingMessage).Nonce})
+		if err != nil {
+			return err
+		}
+		return s.Transport.SendMessage(from, payload)
+	case MessagePong:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownMessageType, msg.Type)
+	}
+}
@@ -0,0 +1,153 @@
+package network
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// helper function creating and starting a server on a local transport until the test ends
+func startServer(t *testing.T, opts ServerOpts) *Server {
+	opts.Logger = logrus.New()
+	opts.Logger.SetLevel(logrus.WarnLevel)
+	s, err := NewServer(opts)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Start(context.Background()))
+	t.Cleanup(s.Stop)
+
+	return s
+}
+
+func TestServer_ProducesAndSyncsBlocks(t *testing.T) {
+	validator, _ := crypto.GeneratePrivateKey()
+	sender, _ := crypto.GeneratePrivateKey()
+	genesis := crypto.NewSignedBlockExample(validator, []*crypto.Transaction{}, 0, crypto.Hash{})
+	config := fundedConfig(100, sender)
+	fast := SyncOpts{Interval: 10 * time.Millisecond}
+
+	a := NewLocalTransport("a")
+	b := NewLocalTransport("b")
+	producer := startServer(t, ServerOpts{
+		Transport:   a,
+		Genesis:     genesis,
+		ChainConfig: config,
+		PrivateKey:  validator,
+		BlockTime:   20 * time.Millisecond,
+		Sync:        fast,
+	})
+	follower := startServer(t, ServerOpts{Transport: b, Genesis: genesis, ChainConfig: config, Sync: fast})
+
+	// the follower catches up, then follows the relayed blocks
+	assert.Eventually(t, func() bool { return producer.Chain().GetBlockchainHeight() >= 3 }, 2*time.Second, 5*time.Millisecond)
+	assert.NoError(t, a.Connect(b))
+	assert.Eventually(t, func() bool {
+		return follower.Chain().GetBlockchainHeight() >= producer.Chain().GetBlockchainHeight()-1
+	}, 2*time.Second, 5*time.Millisecond)
+
+	// a transaction submitted to the follower ends up in a produced block
+	tx := newTransferTx(t, sender, 0, 10)
+	assert.NoError(t, follower.SubmitTx(tx))
+	assert.Eventually(t, func() bool {
+		return follower.Chain().Account(sender.PublicKey()).Nonce == 1 && follower.Mempool().AllTxCount() == 0
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestServer_RoutesMessages(t *testing.T) {
+	validator, _ := crypto.GeneratePrivateKey()
+	genesis := crypto.NewSignedBlockExample(validator, []*crypto.Transaction{}, 0, crypto.Hash{})
+
+	a := NewLocalTransport("a")
+	peer := NewLocalTransport("x")
+	assert.NoError(t, peer.Connect(a))
+	s := startServer(t, ServerOpts{Transport: a, Genesis: genesis, Peers: PeerManagerOpts{BanThreshold: -PenaltyMalformed}})
+
+	payload, err := EncodeMessage(&PingMessage{Nonce: 42})
+	assert.NoError(t, err)
+	assert.NoError(t, peer.SendMessage("a", payload))
+	assert.Eventually(t, func() bool {
+		for {
+			select {
+			case rpc := <-peer.Consume():
+				msg, err := DecodeMessage(rpc.Payload)
+				assert.NoError(t, err)
+				if p, ok := msg.DecodePayload(); ok == nil && msg.Type == MessagePong {
+					assert.Equal(t, &PongMessage{Nonce: 42}, p)
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	}, time.Second, 5*time.Millisecond)
+
+	// garbage gets the sender banned
+	assert.NoError(t, peer.SendMessage("a", []byte{0xff}))
+	assert.Eventually(t, func() bool { return s.Peers().IsBanned("x") }, time.Second, 5*time.Millisecond)
+}
+
+func TestServer_StartStop(t *testing.T) {
+	validator, _ := crypto.GeneratePrivateKey()
+	genesis := crypto.NewSignedBlockExample(validator, []*crypto.Transaction{}, 0, crypto.Hash{})
+
+	_, err := NewServer(ServerOpts{Genesis: genesis})
+	assert.Error(t, err)
+
+	tcp := NewTCPTransport(TCPTransportOpts{ListenAddr: "127.0.0.1:0"})
+	s, err := NewServer(ServerOpts{Transport: tcp, Genesis: genesis})
+	assert.NoError(t, err)
+	assert.Same(t, s.Peers(), tcp.Gater)
+
+	assert.NoError(t, s.Start(context.Background()))
+	assert.ErrorIs(t, s.Start(context.Background()), ErrServerStarted)
+	s.Stop()
+	s.Stop()
+}
+
+// lossyTransport is a local transport losing the first compact blocks it sends
+type lossyTransport struct {
+	*LocalTransport
+	lock sync.Mutex
+	lose int
+}
+
+func (tr *lossyTransport) SendMessage(addr NetAddr, payload []byte) error {
+	msg, err := DecodeMessage(payload)
+	if err == nil && msg.Type == MessageCompactBlock {
+		tr.lock.Lock()
+		lost := tr.lose > 0
+		tr.lose--
+		tr.lock.Unlock()
+		if lost {
+			return nil
+		}
+	}
+
+	return tr.LocalTransport.SendMessage(addr, payload)
+}
+
+func TestServer_CatchesUpAfterMissedBlock(t *testing.T) {
+	validator, _ := crypto.GeneratePrivateKey()
+	genesis := crypto.NewSignedBlockExample(validator, []*crypto.Transaction{}, 0, crypto.Hash{})
+	fast := SyncOpts{Interval: 10 * time.Millisecond}
+
+	a := &lossyTransport{LocalTransport: NewLocalTransport("a"), lose: 1}
+	b := NewLocalTransport("b")
+	assert.NoError(t, a.Connect(b))
+	sender := startServer(t, ServerOpts{Transport: a, Genesis: genesis, Sync: fast})
+	follower := startServer(t, ServerOpts{Transport: b, Genesis: genesis, Sync: fast})
+	assert.Eventually(t, func() bool { return follower.Sync().Status().Peers == 1 }, time.Second, 5*time.Millisecond)
+
+	// the first block is lost; the second one tells the follower it is behind
+	for i := 0; i < 2; i++ {
+		chain := sender.Chain()
+		tip, err := chain.GetHeaderByHeight(chain.GetBlockchainHeight())
+		assert.NoError(t, err)
+		assert.NoError(t, chain.AddBlock(crypto.NewSignedBlockExample(validator, []*crypto.Transaction{}, tip.Height+1, crypto.BlockHash{}.Hash(tip))))
+	}
+	assert.Eventually(t, func() bool { return follower.Chain().GetBlockchainHeight() == 2 }, 2*time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,810 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultSyncWindow         = 256
+	defaultSyncBatch          = 16
+	defaultSyncHeaderLead     = 4096
+	defaultSyncRequestTimeout = 10 * time.Second
+	defaultSyncInterval       = time.Second
+	defaultSyncPeerRequests   = 2
+	defaultSyncStatusInterval = 10 * time.Second
+	maxBlocksResponseSize     = 2 << 20 // keeps block responses well below the default frame size
+)
+
+// ErrIncompatiblePeer is returned for a peer running another chain
+var ErrIncompatiblePeer = errors.New("peer is on another chain")
+
+// SyncOpts configures a SyncManager
+type SyncOpts struct {
+	Window         int           // blocks above the tip that may be downloaded ahead of import; defaults to 256
+	BatchSize      int           // blocks asked for in a single request; defaults to 16
+	HeaderLead     int           // headers downloaded ahead of the tip; defaults to 4096
+	PeerRequests   int           // block requests in flight per peer; defaults to 2
+	RequestTimeout time.Duration // a peer not answering within it is considered stalled; defaults to 10s
+	Interval       time.Duration // how often progress and timeouts are checked; defaults to 1s
+	StatusInterval time.Duration // how often our status is sent again to every peer; defaults to 10s
+	Reporter       PeerReporter  // told about peers timing out or serving invalid blocks; optional
+	Logger         *logrus.Logger
+}
+
+// SyncManager brings the chain up to date with the peers. Peers exchange
+// StatusMessages to learn each other's height. The manager then downloads
+// headers from the highest peer and checks that they link up, and fetches
+// the block bodies in batches from several peers in parallel within a
+// sliding window above the tip. Bodies are imported in height order with
+// AddBlock. Requests a peer does not answer in time are sent to another peer.
+// A higher peer whose headers do not link to the tip is on another branch:
+// its headers are requested further and further back until they link to
+// the chain, then the blocks of its branch are fetched and switched to with
+// SwitchFork. Branches are fetched in a single request, so forks deeper than
+// MaxBlocks are not followed
+type SyncManager struct {
+	SyncOpts
+	transport  Transport
+	chain      *crypto.Blockchain
+	lock       sync.Mutex
+	importLock sync.Mutex // serialises imports so blocks are added in order
+	peers      map[NetAddr]*syncPeer
+	headers    []*crypto.Header        // validated headers above the tip, in height order
+	headerReq  *syncRequest            // header request in flight
+	forkReq    *syncRequest            // request for the blocks of another branch, sent by step when not yet sent
+	requests   map[uint32]*syncRequest // block requests in flight by first height
+	bodies     map[uint32]*syncBody    // downloaded blocks waiting to be imported
+	statusSent map[NetAddr]time.Time   // when we last sent our status to every peer
+	genesis    crypto.Hash
+}
+
+// syncPeer is what the manager knows about a peer
+type syncPeer struct {
+	height       uint32    // height of the peer's chain tip
+	requests     int       // block requests in flight
+	stalledUntil time.Time // the peer gets no requests before then
+	forkBack     uint32    // when searching for the fork point, how far below the tip to ask for headers next
+}
+
+// syncRequest is a request in flight
+type syncRequest struct {
+	peer  NetAddr
+	from  uint32
+	count uint32
+	sent  time.Time
+	back  uint32 // for fork searches, how far below the tip the headers start
+}
+
+// syncBody is a downloaded block and the peer that served it
+type syncBody struct {
+	block *crypto.Block
+	from  NetAddr
+}
+
+// SyncStatus reports the progress of a SyncManager
+type SyncStatus struct {
+	Height  uint32 // height of the local chain
+	Target  uint32 // highest height reported by a peer
+	Peers   int    // peers whose status is known
+	Syncing bool   // whether the chain is behind the target
+}
+
+// outbound is a message to send once the lock is released
+type outbound struct {
+	to NetAddr
+	p  Payload
+}
+
+func NewSyncManager(transport Transport, chain *crypto.Blockchain, opts SyncOpts) *SyncManager {
+	if opts.Window == 0 {
+		opts.Window = defaultSyncWindow
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = defaultSyncBatch
+	}
+	if opts.BatchSize > MaxBlocks {
+		opts.BatchSize = MaxBlocks
+	}
+	if opts.HeaderLead == 0 {
+		opts.HeaderLead = defaultSyncHeaderLead
+	}
+	if opts.PeerRequests == 0 {
+		opts.PeerRequests = defaultSyncPeerRequests
+	}
+	if opts.RequestTimeout == 0 {
+		opts.RequestTimeout = defaultSyncRequestTimeout
+	}
+	if opts.Interval == 0 {
+		opts.Interval = defaultSyncInterval
+	}
+	if opts.StatusInterval == 0 {
+		opts.StatusInterval = defaultSyncStatusInterval
+	}
+	if opts.Logger == nil {
+		opts.Logger = logrus.New()
+	}
+
+	genesis, _ := chain.GetHeaderByHeight(0)
+
+	return &SyncManager{
+		SyncOpts:   opts,
+		transport:  transport,
+		chain:      chain,
+		peers:      make(map[NetAddr]*syncPeer),
+		requests:   make(map[uint32]*syncRequest),
+		bodies:     make(map[uint32]*syncBody),
+		statusSent: make(map[NetAddr]time.Time),
+		genesis:    crypto.BlockHash{}.Hash(genesis),
+	}
+}
+
+// Start checks for progress every Interval until ctx is cancelled
+func (s *SyncManager) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	s.step(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.step(now)
+		}
+	}
+}
+
+// Status returns the sync progress
+func (s *SyncManager) Status() SyncStatus {
+	height := s.chain.GetBlockchainHeight()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	status := SyncStatus{Height: height, Peers: len(s.peers)}
+	for _, peer := range s.peers {
+		status.Target = max(status.Target, peer.height)
+	}
+	status.Syncing = status.Target > height
+
+	return status
+}
+
+// UpdatePeerHeight records that the peer at addr has a block at height,
+// e.g. after it relayed one the chain could not import yet
+func (s *SyncManager) UpdatePeerHeight(addr NetAddr, height uint32) {
+	s.lock.Lock()
+	if peer, ok := s.peers[addr]; ok && height > peer.height {
+		peer.height = height
+	}
+	s.lock.Unlock()
+
+	s.step(time.Now())
+}
+
+// HandleMessage processes a sync message received from the peer from.
+// Only status, block announce, get headers, headers, get blocks and blocks
+// messages are accepted
+func (s *SyncManager) HandleMessage(from NetAddr, msg *Message) error {
+	p, err := msg.DecodePayload()
+	if err != nil {
+		return err
+	}
+
+	switch p := p.(type) {
+	case *StatusMessage:
+		err = s.handleStatus(from, p)
+	case *BlockAnnounceMessage:
+		s.UpdatePeerHeight(from, p.Height)
+	case *GetHeadersMessage:
+		err = s.handleGetHeaders(from, p)
+	case *HeadersMessage:
+		err = s.handleHeaders(from, p)
+	case *GetBlocksMessage:
+		err = s.handleGetBlocks(from, p)
+	case *BlocksMessage:
+		err = s.handleBlocks(from, p)
+	default:
+		return fmt.Errorf("sync manager cannot handle %s message", msg.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.step(time.Now())
+	return nil
+}
+
+// status returns the StatusMessage describing the local chain
+func (s *SyncManager) status() *StatusMessage {
+	height := s.chain.GetBlockchainHeight()
+	head, _ := s.chain.GetHeaderByHeight(height)
+
+	return &StatusMessage{
+		ChainID: s.chain.Config().ChainID,
+		Genesis: s.genesis,
+		Height:  height,
+		Head:    crypto.BlockHash{}.Hash(head),
+	}
+}
+
+// handleStatus records the height of a peer on the same chain and
+// answers with our own status if the peer has not received it yet
+func (s *SyncManager) handleStatus(from NetAddr, m *StatusMessage) error {
+	if m.ChainID != s.chain.Config().ChainID || m.Genesis != s.genesis {
+		return fmt.Errorf("%w: chain id %d, genesis %s", ErrIncompatiblePeer, m.ChainID, m.Genesis.ToString())
+	}
+
+	s.lock.Lock()
+	peer, ok := s.peers[from]
+	if !ok {
+		peer = &syncPeer{}
+		s.peers[from] = peer
+	}
+	peer.height = max(peer.height, m.Height)
+	_, sent := s.statusSent[from]
+	if !sent {
+		s.statusSent[from] = time.Now()
+	}
+	s.lock.Unlock()
+
+	if !sent {
+		return s.send(from, s.status())
+	}
+
+	return nil
+}
+
+// handleGetHeaders serves consecutive headers from the local chain
+func (s *SyncManager) handleGetHeaders(from NetAddr, m *GetHeadersMessage) error {
+	resp := &HeadersMessage{}
+	count := min(m.Count, MaxHeaders)
+	for h := m.From; h-m.From < count; h++ {
+		header, err := s.chain.GetHeaderByHeight(h)
+		if err != nil {
+			break
+		}
+		resp.Headers = append(resp.Headers, header)
+	}
+
+	return s.send(from, resp)
+}
+
+// handleGetBlocks serves consecutive blocks from the local chain,
+// stopping early to keep the response below maxBlocksResponseSize
+func (s *SyncManager) handleGetBlocks(from NetAddr, m *GetBlocksMessage) error {
+	resp := &BlocksMessage{}
+	size := 0
+	count := min(m.Count, MaxBlocks)
+	for h := m.From; h-m.From < count; h++ {
+		b, err := s.chain.GetBlockByHeight(h)
+		if err != nil {
+			break
+		}
+		size += b.Size()
+		if size > maxBlocksResponseSize && len(resp.Blocks) > 0 {
+			break
+		}
+		resp.Blocks = append(resp.Blocks, b)
+	}
+
+	return s.send(from, resp)
+}
+
+// handleHeaders appends the headers answering our request once they are
+// checked to extend the headers already known. Headers not linking to the
+// chain tip come from a peer on another branch, whose fork point is then
+// searched for
+func (s *SyncManager) handleHeaders(from NetAddr, m *HeadersMessage) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	req := s.headerReq
+	if req == nil || req.peer != from {
+		// unsolicited or answered too late
+		return nil
+	}
+	s.headerReq = nil
+	if len(m.Headers) == 0 {
+		return nil
+	}
+
+	prev := m.Headers[0]
+	for _, h := range m.Headers[1:] {
+		if h.Height != prev.Height+1 || h.PrevBlockHash != (crypto.BlockHash{}).Hash(prev) {
+			s.stall(from, time.Now())
+			return fmt.Errorf("peer %s sent headers not extending the chain at height %d", from, h.Height)
+		}
+		prev = h
+	}
+	if m.Headers[0].Height != req.from {
+		s.stall(from, time.Now())
+		return fmt.Errorf("peer %s sent headers from height %d instead of %d", from, m.Headers[0].Height, req.from)
+	}
+	if req.back > 0 {
+		return s.findFork(from, req, m.Headers)
+	}
+
+	last := s.headerTip()
+	if last == nil {
+		return nil
+	}
+	if m.Headers[0].PrevBlockHash != (crypto.BlockHash{}).Hash(last) {
+		peer, ok := s.peers[from]
+		if len(s.headers) > 0 || last.Height == 0 || !ok {
+			s.stall(from, time.Now())
+			return fmt.Errorf("peer %s sent headers not extending the chain at height %d", from, m.Headers[0].Height)
+		}
+		// the peer is on another branch
+		peer.forkBack = 1
+		return nil
+	}
+	s.headers = append(s.headers, m.Headers...)
+
+	return nil
+}
+
+// findFork looks for the point where the chain of the peer at from leaves
+// ours in the headers answering a fork search. Unless the first header
+// links to the chain, the search goes twice as far back. Otherwise the
+// blocks of the branch following the fork point are requested. Callers
+// hold the lock
+func (s *SyncManager) findFork(from NetAddr, req *syncRequest, headers []*crypto.Header) error {
+	peer, ok := s.peers[from]
+	if !ok {
+		return nil
+	}
+	peer.forkBack = 0
+
+	first := headers[0]
+	parent, err := s.chain.GetHeaderByHeight(first.Height - 1)
+	if err != nil {
+		// the tip moved back meanwhile; start over
+		return nil
+	}
+	if first.PrevBlockHash != (crypto.BlockHash{}).Hash(parent) {
+		if first.Height <= 1 {
+			s.stall(from, time.Now())
+			return fmt.Errorf("peer %s sent headers not linking to the genesis block", from)
+		}
+		peer.forkBack = req.back * 2
+		return nil
+	}
+
+	for _, h := range headers {
+		ours, err := s.chain.GetHeaderByHeight(h.Height)
+		if err == nil && (crypto.BlockHash{}).Hash(ours) == (crypto.BlockHash{}).Hash(h) {
+			continue
+		}
+
+		s.Logger.WithFields(logrus.Fields{"peer": from, "fork height": h.Height}).Info("found fork point")
+		s.forkReq = &syncRequest{peer: from, from: h.Height, count: min(peer.height-h.Height+1, MaxBlocks)}
+		return nil
+	}
+
+	// every header is on our chain as well, so there is no fork after all
+	return nil
+}
+
+// handleBlocks stores the bodies answering one of our requests. Blocks
+// must match the downloaded header at their height; the heights of the
+// request left unanswered are requested again
+func (s *SyncManager) handleBlocks(from NetAddr, m *BlocksMessage) error {
+	s.lock.Lock()
+	if fork := s.forkReq; fork != nil && fork.peer == from && len(m.Blocks) > 0 && m.Blocks[0].Height == fork.from {
+		s.forkReq = nil
+		s.lock.Unlock()
+		return s.switchFork(from, m.Blocks)
+	}
+
+	var req *syncRequest
+	if len(m.Blocks) > 0 {
+		req = s.requests[m.Blocks[0].Height]
+	}
+	if req == nil || req.peer != from {
+		s.lock.Unlock()
+		return nil
+	}
+	s.release(req)
+
+	var err error
+	for i, b := range m.Blocks {
+		header := s.header(req.from + uint32(i))
+		if uint32(i) >= req.count || header == nil || b.Hash(crypto.BlockHash{}) != (crypto.BlockHash{}).Hash(header) {
+			s.stall(from, time.Now())
+			err = fmt.Errorf("peer %s sent a block not matching the requested headers", from)
+			break
+		}
+		s.bodies[b.Height] = &syncBody{block: b, from: from}
+	}
+	s.lock.Unlock()
+
+	s.importBlocks()
+	return err
+}
+
+// switchFork switches the chain to the branch served by the peer at
+// from, provided the branch is longer
+func (s *SyncManager) switchFork(from NetAddr, branch []*crypto.Block) error {
+	s.importLock.Lock()
+	err := s.chain.SwitchFork(branch)
+	s.importLock.Unlock()
+
+	if err != nil {
+		s.lock.Lock()
+		s.stall(from, time.Now())
+		s.lock.Unlock()
+		return fmt.Errorf("peer %s served a branch that cannot be switched to: %w", from, err)
+	}
+
+	return nil
+}
+
+// importBlocks adds the downloaded blocks following the tip to the chain
+func (s *SyncManager) importBlocks() {
+	s.importLock.Lock()
+	defer s.importLock.Unlock()
+
+	for {
+		next := s.chain.GetBlockchainHeight() + 1
+
+		// the body stays in place until it is added so that it is not
+		// requested again in the meantime
+		s.lock.Lock()
+		body, ok := s.bodies[next]
+		s.lock.Unlock()
+		if !ok {
+			return
+		}
+
+		err := s.chain.AddBlock(body.block)
+
+		s.lock.Lock()
+		if s.bodies[next] == body {
+			delete(s.bodies, next)
+		}
+		// a conflict means the tip was taken meanwhile, by a produced block
+		if err != nil && !crypto.IsBenign(err) && !errors.Is(err, crypto.ErrBlockConflict) {
+			// the body is requested again, from another peer
+			s.Logger.WithField("peer", body.from).WithError(err).Warn("failed to import synced block")
+			s.stall(body.from, time.Now())
+			s.report(body.from, err)
+			s.lock.Unlock()
+			return
+		}
+		s.lock.Unlock()
+	}
+}
+
+// step sends our status to new peers and again every StatusInterval,
+// gives up on stalled requests and issues the header and block requests
+// needed to make progress
+func (s *SyncManager) step(now time.Time) {
+	// the tip is read under the lock so that concurrent steps never
+	// trim the headers against an older tip than a previous step did
+	s.lock.Lock()
+	tip := s.chain.GetBlockchainHeight()
+	tipHeader, err := s.chain.GetHeaderByHeight(tip)
+	if err != nil {
+		s.lock.Unlock()
+		return
+	}
+
+	var out []outbound
+
+	// track connected peers, forgetting the others and their requests
+	connected := make(map[NetAddr]bool)
+	for _, addr := range s.transport.Peers() {
+		connected[addr] = true
+		if sent, ok := s.statusSent[addr]; !ok || now.Sub(sent) >= s.StatusInterval {
+			s.statusSent[addr] = now
+			out = append(out, outbound{addr, nil})
+		}
+	}
+	for addr := range s.peers {
+		if !connected[addr] {
+			s.dropPeer(addr)
+		}
+	}
+	for addr := range s.statusSent {
+		if !connected[addr] {
+			delete(s.statusSent, addr)
+		}
+	}
+
+	// stalled requests
+	if s.headerReq != nil && now.Sub(s.headerReq.sent) >= s.RequestTimeout {
+		s.stall(s.headerReq.peer, now)
+		s.report(s.headerReq.peer, fmt.Errorf("%w: headers from %d", ErrPeerTimeout, s.headerReq.from))
+		s.headerReq = nil
+	}
+	for _, req := range s.requests {
+		if now.Sub(req.sent) >= s.RequestTimeout {
+			s.stall(req.peer, now)
+			s.report(req.peer, fmt.Errorf("%w: blocks from %d", ErrPeerTimeout, req.from))
+			s.release(req)
+		}
+	}
+	if s.forkReq != nil && !s.forkReq.sent.IsZero() && now.Sub(s.forkReq.sent) >= s.RequestTimeout {
+		s.stall(s.forkReq.peer, now)
+		s.report(s.forkReq.peer, fmt.Errorf("%w: branch from %d", ErrPeerTimeout, s.forkReq.from))
+		s.forkReq = nil
+	}
+
+	s.trim(tipHeader)
+	out = append(out, s.requestFork(now)...)
+	out = append(out, s.requestHeaders(tip, now)...)
+	out = append(out, s.requestBlocks(tip, now)...)
+	s.lock.Unlock()
+
+	var status *StatusMessage
+	for _, o := range out {
+		if o.p == nil {
+			if status == nil {
+				status = s.status()
+			}
+			o.p = status
+		}
+		if err := s.send(o.to, o.p); err != nil {
+			s.Logger.WithField("peer", o.to).WithError(err).Debug("failed to send sync message")
+		}
+	}
+}
+
+// trim drops the headers and bodies the chain has reached. If the chain
+// moved to another block than the downloaded header, the downloaded
+// headers are on another branch and are discarded. Callers hold the lock
+func (s *SyncManager) trim(tip *crypto.Header) {
+	for len(s.headers) > 0 && s.headers[0].Height <= tip.Height {
+		if s.headers[0].Height == tip.Height && (crypto.BlockHash{}).Hash(s.headers[0]) != (crypto.BlockHash{}).Hash(tip) {
+			s.headers = nil
+			break
+		}
+		s.headers = s.headers[1:]
+	}
+	for height := range s.bodies {
+		if height <= tip.Height {
+			delete(s.bodies, height)
+		}
+	}
+	if len(s.headers) > 0 && s.headers[0].PrevBlockHash != (crypto.BlockHash{}).Hash(tip) {
+		s.headers = nil
+	}
+	if len(s.headers) == 0 {
+		s.bodies = make(map[uint32]*syncBody)
+		for _, req := range s.requests {
+			s.release(req)
+		}
+	}
+}
+
+// requestFork asks for the blocks of the branch found by a fork search.
+// Callers hold the lock
+func (s *SyncManager) requestFork(now time.Time) []outbound {
+	req := s.forkReq
+	if req == nil || !req.sent.IsZero() {
+		return nil
+	}
+	req.sent = now
+
+	return []outbound{{req.peer, &GetBlocksMessage{From: req.from, Count: req.count}}}
+}
+
+// requestHeaders asks the highest peer for the headers following the
+// last known one, or for the headers going back from the tip when
+// searching for the point its chain leaves ours. Callers hold the lock
+func (s *SyncManager) requestHeaders(tip uint32, now time.Time) []outbound {
+	if s.headerReq != nil || s.forkReq != nil {
+		return nil
+	}
+
+	last := s.headerTip()
+	if last == nil || int(last.Height-tip) >= s.HeaderLead {
+		return nil
+	}
+
+	addr, peer := s.bestPeer(last.Height, now)
+	if peer == nil {
+		return nil
+	}
+
+	if peer.forkBack > 0 && tip > 0 {
+		back := min(peer.forkBack, tip)
+		from := tip + 1 - back
+		count := min(peer.height-from+1, MaxHeaders)
+		s.headerReq = &syncRequest{peer: addr, from: from, count: count, sent: now, back: back}
+
+		return []outbound{{addr, &GetHeadersMessage{From: from, Count: count}}}
+	}
+
+	count := min(peer.height-last.Height, MaxHeaders)
+	s.headerReq = &syncRequest{peer: addr, from: last.Height + 1, count: count, sent: now}
+
+	return []outbound{{addr, &GetHeadersMessage{From: last.Height + 1, Count: count}}}
+}
+
+// requestBlocks spreads batches of missing bodies within the window over
+// the peers with spare capacity. Callers hold the lock
+func (s *SyncManager) requestBlocks(tip uint32, now time.Time) []outbound {
+	var out []outbound
+
+	for height := tip + 1; len(s.headers) > 0 && height <= tip+uint32(s.Window); {
+		if s.header(height) == nil {
+			break
+		}
+		if req, ok := s.requests[height]; ok {
+			height += req.count
+			continue
+		}
+		if _, ok := s.bodies[height]; ok {
+			height++
+			continue
+		}
+
+		// a batch of consecutive heights neither downloaded nor requested
+		count := uint32(1)
+		for count < uint32(s.BatchSize) && height+count <= tip+uint32(s.Window) {
+			next := height + count
+			_, requested := s.requests[next]
+			_, downloaded := s.bodies[next]
+			if s.header(next) == nil || requested || downloaded {
+				break
+			}
+			count++
+		}
+
+		addr, peer := s.leastBusyPeer(height+count-1, now)
+		if peer == nil {
+			break
+		}
+		peer.requests++
+		req := &syncRequest{peer: addr, from: height, count: count, sent: now}
+		s.requests[height] = req
+		out = append(out, outbound{addr, &GetBlocksMessage{From: height, Count: count}})
+
+		height += count
+	}
+
+	return out
+}
+
+// bestPeer returns the highest peer above height that is not stalled.
+// Callers hold the lock
+func (s *SyncManager) bestPeer(height uint32, now time.Time) (NetAddr, *syncPeer) {
+	var bestAddr NetAddr
+	var best *syncPeer
+	for _, addr := range s.sortedPeers() {
+		peer := s.peers[addr]
+		if peer.height <= height || now.Before(peer.stalledUntil) {
+			continue
+		}
+		if best == nil || peer.height > best.height {
+			bestAddr, best = addr, peer
+		}
+	}
+
+	return bestAddr, best
+}
+
+// leastBusyPeer returns the peer with the fewest requests in flight among
+// those that have the block at height, are not stalled and have spare
+// capacity. Callers hold the lock
+func (s *SyncManager) leastBusyPeer(height uint32, now time.Time) (NetAddr, *syncPeer) {
+	var bestAddr NetAddr
+	var best *syncPeer
+	for _, addr := range s.sortedPeers() {
+		peer := s.peers[addr]
+		if peer.height < height || now.Before(peer.stalledUntil) || peer.requests >= s.PeerRequests {
+			continue
+		}
+		if best == nil || peer.requests < best.requests {
+			bestAddr, best = addr, peer
+		}
+	}
+
+	return bestAddr, best
+}
+
+// sortedPeers returns the peer addresses in a stable order. Callers hold the lock
+func (s *SyncManager) sortedPeers() []NetAddr {
+	addrs := make([]NetAddr, 0, len(s.peers))
+	for addr := range s.peers {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i] < addrs[j]
+	})
+
+	return addrs
+}
+
+// headerTip returns the last downloaded header, or the chain tip when
+// there is none. Callers hold the lock
+func (s *SyncManager) headerTip() *crypto.Header {
+	if len(s.headers) > 0 {
+		return s.headers[len(s.headers)-1]
+	}
+
+	header, err := s.chain.GetHeaderByHeight(s.chain.GetBlockchainHeight())
+	if err != nil {
+		return nil
+	}
+
+	return header
+}
+
+// header returns the downloaded header at height or nil. Callers hold the lock
+func (s *SyncManager) header(height uint32) *crypto.Header {
+	if len(s.headers) == 0 || height < s.headers[0].Height {
+		return nil
+	}
+
+	i := int(height - s.headers[0].Height)
+	if i >= len(s.headers) {
+		return nil
+	}
+
+	return s.headers[i]
+}
+
+// stall keeps requests away from the peer at addr for a while.
+// Callers hold the lock
+func (s *SyncManager) stall(addr NetAddr, now time.Time) {
+	if peer, ok := s.peers[addr]; ok {
+		peer.stalledUntil = now.Add(s.RequestTimeout)
+		s.Logger.WithField("peer", addr).Debug("sync peer stalled")
+	}
+}
+
+// report passes err caused by the peer at addr to the Reporter
+func (s *SyncManager) report(addr NetAddr, err error) {
+	if s.Reporter != nil {
+		s.Reporter.ReportError(addr, err)
+	}
+}
+
+// release forgets a block request so its heights can be requested again.
+// Callers hold the lock
+func (s *SyncManager) release(req *syncRequest) {
+	if s.requests[req.from] != req {
+		return
+	}
+
+	delete(s.requests, req.from)
+	if peer, ok := s.peers[req.peer]; ok {
+		peer.requests--
+	}
+}
+
+// dropPeer forgets a disconnected peer and its requests. Callers hold the lock
+func (s *SyncManager) dropPeer(addr NetAddr) {
+	for _, req := range s.requests {
+		if req.peer == addr {
+			s.release(req)
+		}
+	}
+	if s.headerReq != nil && s.headerReq.peer == addr {
+		s.headerReq = nil
+	}
+	if s.forkReq != nil && s.forkReq.peer == addr {
+		s.forkReq = nil
+	}
+	delete(s.peers, addr)
+}
+
+func (s *SyncManager) send(to NetAddr, p Payload) error {
+	payload, err := EncodeMessage(p)
+	if err != nil {
+		return err
+	}
+
+	return s.transport.SendMessage(to, payload)
+}
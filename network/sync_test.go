@@ -0,0 +1,262 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/majorshift/safari-chain/events"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type syncNode struct {
+	transport *LocalTransport
+	chain     *crypto.Blockchain
+	sync      *SyncManager
+	getBlocks atomic.Int32 // get blocks messages delivered to the node
+	stalled   atomic.Bool  // drops get blocks messages instead of answering them
+}
+
+// errorRecorder is a PeerReporter remembering the reported errors
+type errorRecorder struct {
+	lock sync.Mutex
+	errs map[NetAddr][]error
+}
+
+func (r *errorRecorder) ReportError(addr NetAddr, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.errs == nil {
+		r.errs = make(map[NetAddr][]error)
+	}
+	r.errs[addr] = append(r.errs[addr], err)
+}
+
+func (r *errorRecorder) get(addr NetAddr) []error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.errs[addr]
+}
+
+// helper function creating sync nodes sharing the same genesis block
+func newSyncNodes(t *testing.T, opts SyncOpts, addrs ...NetAddr) []*syncNode {
+	validator, _ := crypto.GeneratePrivateKey()
+	genesis := crypto.NewSignedBlockExample(validator, []*crypto.Transaction{}, 0, crypto.Hash{})
+
+	var nodes []*syncNode
+	for _, addr := range addrs {
+		n := &syncNode{
+			transport: NewLocalTransport(addr),
+			chain:     crypto.NewBlockchain(logrus.New(), genesis),
+		}
+		n.sync = NewSyncManager(n.transport, n.chain, opts)
+		pumpSync(t, n)
+		nodes = append(nodes, n)
+	}
+
+	return nodes
+}
+
+// helper function delivering the messages received by n to its sync manager until the test ends
+func pumpSync(t *testing.T, n *syncNode) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case rpc := <-n.transport.Consume():
+				msg, err := DecodeMessage(rpc.Payload)
+				if err != nil {
+					continue
+				}
+				if msg.Type == MessageGetBlocks {
+					n.getBlocks.Add(1)
+					if n.stalled.Load() {
+						continue
+					}
+				}
+				n.sync.HandleMessage(rpc.From, msg)
+			}
+		}
+	}()
+}
+
+// helper function running the sync manager of n until the test ends
+func startSync(t *testing.T, n *syncNode) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go n.sync.Start(ctx)
+}
+
+// helper function adding n empty blocks to chain and copying them to the others
+func extendChains(t *testing.T, n int, chain *crypto.Blockchain, others ...*crypto.Blockchain) {
+	for i := 0; i < n; i++ {
+		b := relayBlock(t, chain, []*crypto.Transaction{})
+		assert.NoError(t, chain.AddBlock(b))
+		for _, other := range others {
+			assert.NoError(t, other.AddBlock(b))
+		}
+	}
+}
+
+// helper function connecting b to peers and extending the chains of the
+// peers by n blocks once b knows all of them, so every peer takes part
+func connectAndExtend(t *testing.T, n int, b *syncNode, peers ...*syncNode) {
+	for _, peer := range peers {
+		assert.NoError(t, b.transport.Connect(peer.transport))
+	}
+	startSync(t, b)
+	assert.Eventually(t, func() bool { return b.sync.Status().Peers == len(peers) }, time.Second, 5*time.Millisecond)
+
+	chains := make([]*crypto.Blockchain, len(peers))
+	for i, peer := range peers {
+		chains[i] = peer.chain
+	}
+	extendChains(t, n, chains[0], chains[1:]...)
+
+	for _, peer := range peers {
+		payload, err := EncodeMessage(&BlockAnnounceMessage{Height: uint32(n)})
+		assert.NoError(t, err)
+		assert.NoError(t, peer.transport.SendMessage(b.transport.Addr(), payload))
+	}
+}
+
+func TestSyncManager_CatchesUp(t *testing.T) {
+	nodes := newSyncNodes(t, SyncOpts{BatchSize: 4, Window: 16, Interval: 10 * time.Millisecond}, "a", "b")
+	a, b := nodes[0], nodes[1]
+	extendChains(t, 40, a.chain)
+
+	assert.NoError(t, a.transport.Connect(b.transport))
+	startSync(t, b)
+
+	assert.Eventually(t, func() bool { return b.chain.GetBlockchainHeight() == 40 }, 2*time.Second, 5*time.Millisecond)
+	for h := uint32(0); h <= 40; h++ {
+		want, _ := a.chain.GetHeaderByHeight(h)
+		got, _ := b.chain.GetHeaderByHeight(h)
+		assert.Equal(t, crypto.BlockHash{}.Hash(want), crypto.BlockHash{}.Hash(got))
+	}
+
+	status := b.sync.Status()
+	assert.Equal(t, SyncStatus{Height: 40, Target: 40, Peers: 1}, status)
+}
+
+func TestSyncManager_DownloadsFromSeveralPeers(t *testing.T) {
+	nodes := newSyncNodes(t, SyncOpts{BatchSize: 2, Window: 32, PeerRequests: 1, Interval: 10 * time.Millisecond}, "a", "b", "c")
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	connectAndExtend(t, 30, b, a, c)
+
+	assert.Eventually(t, func() bool { return b.chain.GetBlockchainHeight() == 30 }, 2*time.Second, 5*time.Millisecond)
+	assert.Positive(t, a.getBlocks.Load())
+	assert.Positive(t, c.getBlocks.Load())
+}
+
+func TestSyncManager_RerequestsFromAnotherPeerWhenStalled(t *testing.T) {
+	reporter := &errorRecorder{}
+	opts := SyncOpts{BatchSize: 2, Window: 16, PeerRequests: 1, RequestTimeout: 50 * time.Millisecond, Interval: 10 * time.Millisecond, Reporter: reporter}
+	nodes := newSyncNodes(t, opts, "a", "b", "c")
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	a.stalled.Store(true)
+	connectAndExtend(t, 20, b, a, c)
+
+	assert.Eventually(t, func() bool { return b.chain.GetBlockchainHeight() == 20 }, 2*time.Second, 5*time.Millisecond)
+	assert.Positive(t, a.getBlocks.Load())
+
+	// a was reported for timing out, c was not
+	errs := reporter.get("a")
+	assert.NotEmpty(t, errs)
+	for _, err := range errs {
+		assert.True(t, errors.Is(err, ErrPeerTimeout))
+	}
+	assert.Empty(t, reporter.get("c"))
+}
+
+func TestSyncManager_IgnoresOtherChains(t *testing.T) {
+	nodes := newSyncNodes(t, SyncOpts{}, "a")
+	a := nodes[0]
+
+	status := a.sync.status()
+	status.Genesis = crypto.Hash{1}
+	msg, err := NewMessage(status)
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, a.sync.HandleMessage("x", msg), ErrIncompatiblePeer)
+	assert.Equal(t, 0, a.sync.Status().Peers)
+}
+
+func TestSyncManager_RejectsUnlinkedHeaders(t *testing.T) {
+	nodes := newSyncNodes(t, SyncOpts{}, "a", "b")
+	a, b := nodes[0], nodes[1]
+	extendChains(t, 3, a.chain)
+
+	// b plays the remote peer by hand
+	peer := NewLocalTransport("x")
+	assert.NoError(t, peer.Connect(b.transport))
+
+	status := a.sync.status()
+	msg, err := NewMessage(status)
+	assert.NoError(t, err)
+	assert.NoError(t, b.sync.HandleMessage("x", msg))
+
+	assert.IsType(t, &StatusMessage{}, nextPayload(t, peer))
+	req, ok := nextPayload(t, peer).(*GetHeadersMessage)
+	assert.True(t, ok)
+	assert.Equal(t, &GetHeadersMessage{From: 1, Count: 3}, req)
+
+	h1, _ := a.chain.GetHeaderByHeight(1)
+	h3, _ := a.chain.GetHeaderByHeight(3)
+	msg, err = NewMessage(&HeadersMessage{Headers: []*crypto.Header{h1, h3}})
+	assert.NoError(t, err)
+	assert.Error(t, b.sync.HandleMessage("x", msg))
+	assert.Nil(t, b.sync.header(1))
+}
+
+func TestSyncManager_SwitchesToLongerFork(t *testing.T) {
+	reporter := &errorRecorder{}
+	nodes := newSyncNodes(t, SyncOpts{Interval: 10 * time.Millisecond, Reporter: reporter}, "a", "b")
+	a, b := nodes[0], nodes[1]
+	sub := events.Subscribe[crypto.ChainReorg](b.chain.Events(), 1)
+
+	// the chains share 3 blocks, then a adds 4 blocks and b 2 others
+	extendChains(t, 3, a.chain, b.chain)
+	extendChains(t, 4, a.chain)
+	extendChains(t, 2, b.chain)
+
+	assert.NoError(t, a.transport.Connect(b.transport))
+	startSync(t, b)
+
+	assert.Eventually(t, func() bool { return b.chain.GetBlockchainHeight() == 7 }, 2*time.Second, 5*time.Millisecond)
+	for h := uint32(0); h <= 7; h++ {
+		want, _ := a.chain.GetHeaderByHeight(h)
+		got, _ := b.chain.GetHeaderByHeight(h)
+		assert.Equal(t, crypto.BlockHash{}.Hash(want), crypto.BlockHash{}.Hash(got))
+	}
+	reorg := <-sub.Events()
+	assert.Len(t, reorg.Detached, 2)
+	assert.Len(t, reorg.Added, 4)
+	assert.Empty(t, reporter.get("a"))
+}
+
+func TestSyncManager_ResendsStatus(t *testing.T) {
+	opts := SyncOpts{Interval: 10 * time.Millisecond, StatusInterval: 20 * time.Millisecond}
+	nodes := newSyncNodes(t, opts, "a", "b")
+	a, b := nodes[0], nodes[1]
+	assert.NoError(t, a.transport.Connect(b.transport))
+	startSync(t, a)
+	startSync(t, b)
+	assert.Eventually(t, func() bool { return b.sync.Status().Peers == 1 }, time.Second, 5*time.Millisecond)
+
+	// the blocks are neither announced nor relayed, yet b learns about them
+	extendChains(t, 3, a.chain)
+	assert.Eventually(t, func() bool { return b.chain.GetBlockchainHeight() == 3 }, 2*time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,553 @@
+package network
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultMaxFrameSize = 4 << 20 // 4 MiB, comfortably above the default maximum block size
+	defaultReadTimeout  = 2 * time.Minute
+	defaultWriteTimeout = 10 * time.Second
+	defaultDialTimeout  = 5 * time.Second
+	defaultHandshake    = 10 * time.Second
+	defaultMinBackoff   = 500 * time.Millisecond
+	defaultMaxBackoff   = 30 * time.Second
+	tcpConsumeBuffer    = 1024
+)
+
+// ErrFrameTooLarge is returned when a frame exceeds the maximum frame size
+var ErrFrameTooLarge = errors.New("frame exceeds maximum size")
+
+// TCPTransportOpts configures a TCPTransport
+type TCPTransportOpts struct {
+	ListenAddr       string             // address to accept connections on, e.g. ":3000"
+	PrivateKey       *crypto.PrivateKey // node identity key; a random one is generated when nil
+	StaticPeers      []NetAddr          // peers kept connected, see ParseNodeAddr; redialled with backoff when the connection drops
+	MaxFrameSize     int                // largest accepted message in bytes; defaults to 4 MiB
+	ReadTimeout      time.Duration      // a peer silent for this long is disconnected; defaults to 2m
+	WriteTimeout     time.Duration      // deadline for writing a single frame; defaults to 10s
+	DialTimeout      time.Duration      // defaults to 5s
+	HandshakeTimeout time.Duration      // deadline for authenticating a new connection; defaults to 10s
+	MinBackoff       time.Duration      // first delay before redialling a static peer; defaults to 500ms
+	MaxBackoff       time.Duration      // cap of the doubling redial delay; defaults to 30s
+	Gater            ConnectionGater    // decides which authenticated peers are kept; all are when nil
+	Logger           *logrus.Logger
+}
+
+// TCPTransport is a Transport exchanging length prefixed frames over TCP.
+// Every connection starts with a handshake authenticating both nodes by
+// their identity key, after which all frames are encrypted. Peers are
+// addressed by their PeerID rather than their network address.
+// Every connection is served by its own goroutine; all of them stop when
+// the context given to Start is cancelled or Close is called
+type TCPTransport struct {
+	TCPTransportOpts
+	listener  net.Listener
+	consumeCh chan RPC
+	lock      sync.RWMutex
+	peers     map[NetAddr]*tcpPeer
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// tcpPeer is an authenticated connection to a peer
+type tcpPeer struct {
+	conn      net.Conn
+	secure    *secureConn
+	id        NetAddr       // PeerID of the remote identity key
+	outbound  bool          // whether we dialled the peer
+	writeLock sync.Mutex    // serialises frames written to conn
+	done      chan struct{} // closed once the connection is gone
+}
+
+// PeerID returns the identifier of the node owning pub:
+// its hex encoded public key
+func PeerID(pub *crypto.PublicKey) NetAddr {
+	return NetAddr(hex.EncodeToString(pub.ToBytes()))
+}
+
+// NewNodeAddr returns the address of the node owning pub listening on
+// hostport, in the form "<peer id>@<host>:<port>"
+func NewNodeAddr(pub *crypto.PublicKey, hostport string) NetAddr {
+	return PeerID(pub) + "@" + NetAddr(hostport)
+}
+
+// ParseNodeAddr splits a node address into its identity key and network
+// address. A plain "<host>:<port>" is accepted too, in which case the key
+// is nil and any identity is accepted when dialling it
+func ParseNodeAddr(addr NetAddr) (*crypto.PublicKey, string, error) {
+	id, hostport, ok := strings.Cut(string(addr), "@")
+	if !ok {
+		return nil, string(addr), nil
+	}
+
+	key, err := hex.DecodeString(id)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, "", fmt.Errorf("invalid peer id in node address %q", addr)
+	}
+
+	return &crypto.PublicKey{Key: key}, hostport, nil
+}
+
+func NewTCPTransport(opts TCPTransportOpts) *TCPTransport {
+	if opts.MaxFrameSize == 0 {
+		opts.MaxFrameSize = defaultMaxFrameSize
+	}
+	if opts.ReadTimeout == 0 {
+		opts.ReadTimeout = defaultReadTimeout
+	}
+	if opts.WriteTimeout == 0 {
+		opts.WriteTimeout = defaultWriteTimeout
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = defaultDialTimeout
+	}
+	if opts.HandshakeTimeout == 0 {
+		opts.HandshakeTimeout = defaultHandshake
+	}
+	if opts.MinBackoff == 0 {
+		opts.MinBackoff = defaultMinBackoff
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = defaultMaxBackoff
+	}
+	if opts.Logger == nil {
+		opts.Logger = logrus.New()
+	}
+	if opts.PrivateKey == nil {
+		key, err := crypto.GeneratePrivateKey()
+		if err != nil {
+			panic(err)
+		}
+		opts.PrivateKey = key
+	}
+
+	return &TCPTransport{
+		TCPTransportOpts: opts,
+		consumeCh:        make(chan RPC, tcpConsumeBuffer),
+		peers:            make(map[NetAddr]*tcpPeer),
+	}
+}
+
+// Start listens on ListenAddr, accepts connections and keeps the static
+// peers connected until ctx is cancelled
+func (t *TCPTransport) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", t.ListenAddr)
+	if err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	t.listener = ln
+	t.ctx, t.cancel = context.WithCancel(ctx)
+	t.lock.Unlock()
+
+	t.wg.Add(1)
+	go t.acceptLoop()
+
+	for _, addr := range t.StaticPeers {
+		t.wg.Add(1)
+		go t.keepConnected(addr)
+	}
+
+	// tear everything down once the context ends
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		<-t.ctx.Done()
+		ln.Close()
+		for _, peer := range t.allPeers() {
+			peer.conn.Close()
+		}
+	}()
+
+	return nil
+}
+
+// Close stops the transport and waits for its goroutines to exit
+func (t *TCPTransport) Close() error {
+	t.lock.RLock()
+	cancel := t.cancel
+	t.lock.RUnlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	t.wg.Wait()
+
+	return nil
+}
+
+// Consume returns the channel delivering messages received from peers
+func (t *TCPTransport) Consume() <-chan RPC {
+	return t.consumeCh
+}
+
+// Connect dials the transport tr listens on
+func (t *TCPTransport) Connect(tr Transport) error {
+	_, err := t.Dial(tr.Addr())
+	return err
+}
+
+// Dial opens a connection to the node at addr unless one already exists.
+// addr is parsed by ParseNodeAddr; when it names an identity the
+// connection is dropped if the node authenticates with another key.
+// The PeerID the node authenticated with is returned
+func (t *TCPTransport) Dial(addr NetAddr) (NetAddr, error) {
+	peer, err := t.dial(addr)
+	if err != nil {
+		return "", err
+	}
+	return peer.id, nil
+}
+
+// SendMessage encrypts payload and writes it as a single frame to the
+// peer whose PeerID is addr
+func (t *TCPTransport) SendMessage(addr NetAddr, payload []byte) error {
+	t.lock.RLock()
+	peer, ok := t.peers[addr]
+	t.lock.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("%s: could not send message to unknown peer %s", t.Addr(), addr)
+	}
+	if len(payload) > t.MaxFrameSize {
+		return fmt.Errorf("%w: %d > %d", ErrFrameTooLarge, len(payload), t.MaxFrameSize)
+	}
+
+	peer.writeLock.Lock()
+	defer peer.writeLock.Unlock()
+
+	peer.conn.SetWriteDeadline(time.Now().Add(t.WriteTimeout))
+	if err := peer.secure.writeMsg(payload); err != nil {
+		// the read loop notices the broken connection and drops the peer
+		peer.conn.Close()
+		return err
+	}
+
+	return nil
+}
+
+// Disconnect closes the connection to the peer whose PeerID is addr
+func (t *TCPTransport) Disconnect(addr NetAddr) error {
+	t.lock.RLock()
+	peer, ok := t.peers[addr]
+	t.lock.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("%s: could not disconnect unknown peer %s", t.Addr(), addr)
+	}
+
+	// the read loop notices the closed connection and drops the peer
+	return peer.conn.Close()
+}
+
+// Broadcast sends payload to every connected peer. It tries all of them
+// and returns the first error encountered
+func (t *TCPTransport) Broadcast(payload []byte) error {
+	var firstErr error
+	for _, addr := range t.Peers() {
+		if err := t.SendMessage(addr, payload); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Addr returns the node address of the transport, combining its identity
+// with the address it listens on
+func (t *TCPTransport) Addr() NetAddr {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.listener != nil {
+		return NewNodeAddr(t.PrivateKey.PublicKey(), t.listener.Addr().String())
+	}
+
+	return NewNodeAddr(t.PrivateKey.PublicKey(), t.ListenAddr)
+}
+
+// ID returns the PeerID other nodes know this transport by
+func (t *TCPTransport) ID() NetAddr {
+	return PeerID(t.PrivateKey.PublicKey())
+}
+
+// Peers returns the ids of the connected peers in sorted order
+func (t *TCPTransport) Peers() []NetAddr {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	addrs := make([]NetAddr, 0, len(t.peers))
+	for addr := range t.peers {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i] < addrs[j]
+	})
+
+	return addrs
+}
+
+// acceptLoop serves inbound connections until the listener is closed
+func (t *TCPTransport) acceptLoop() {
+	defer t.wg.Done()
+
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			if t.ctx.Err() == nil {
+				t.Logger.WithError(err).Error("tcp accept failed")
+			}
+			return
+		}
+
+		// authenticate in the background so slow peers do not hold up others
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			if _, err := t.setupPeer(conn, nil, false); err != nil {
+				t.Logger.WithField("remote", conn.RemoteAddr()).WithError(err).Debug("inbound handshake failed")
+			}
+		}()
+	}
+}
+
+// dial connects to the node at addr and returns the peer, reusing an
+// existing connection
+func (t *TCPTransport) dial(addr NetAddr) (*tcpPeer, error) {
+	expected, hostport, err := ParseNodeAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t.lock.RLock()
+	ctx := t.ctx
+	var peer *tcpPeer
+	if expected != nil {
+		peer = t.peers[PeerID(expected)]
+	}
+	t.lock.RUnlock()
+
+	if peer != nil {
+		return peer, nil
+	}
+	if ctx == nil {
+		return nil, errors.New("tcp transport not started")
+	}
+
+	dialer := net.Dialer{Timeout: t.DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", hostport)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.setupPeer(conn, expected, true)
+}
+
+// setupPeer runs the handshake over conn and registers the authenticated
+// peer. If the node is already connected the existing peer is returned and
+// conn is closed. conn is closed on failure too
+func (t *TCPTransport) setupPeer(conn net.Conn, expected *crypto.PublicKey, outbound bool) (*tcpPeer, error) {
+	// abort the handshake as soon as the transport stops
+	stop := context.AfterFunc(t.ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetDeadline(time.Now().Add(t.HandshakeTimeout))
+	secure, err := handshake(conn, t.PrivateKey, outbound, expected, t.MaxFrameSize)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	peer := &tcpPeer{
+		conn:     conn,
+		secure:   secure,
+		id:       PeerID(secure.remote),
+		outbound: outbound,
+		done:     make(chan struct{}),
+	}
+	existing, err := t.addPeer(peer)
+	if err != nil || existing != peer {
+		conn.Close()
+	}
+
+	return existing, err
+}
+
+// keepConnected dials the static peer at addr whenever it is not connected,
+// doubling the delay between failed attempts up to MaxBackoff
+func (t *TCPTransport) keepConnected(addr NetAddr) {
+	defer t.wg.Done()
+
+	backoff := t.MinBackoff
+	for {
+		peer, err := t.dial(addr)
+		if err == nil {
+			backoff = t.MinBackoff
+			select {
+			case <-peer.done:
+				continue
+			case <-t.ctx.Done():
+				return
+			}
+		}
+
+		log := t.Logger.WithFields(logrus.Fields{
+			"peer":    addr,
+			"backoff": backoff,
+		}).WithError(err)
+		if errors.Is(err, ErrUnexpectedPeer) {
+			log.Warn("static peer presented another identity")
+		} else {
+			log.Debug("failed to dial static peer")
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-t.ctx.Done():
+			return
+		}
+
+		backoff *= 2
+		if backoff > t.MaxBackoff {
+			backoff = t.MaxBackoff
+		}
+	}
+}
+
+// addPeer registers peer and starts reading from it. If a connection to
+// the same node exists already, that peer is returned instead, unless both
+// nodes dialled each other: then both keep the connection dialled by the
+// node with the lower PeerID and the other one is closed. The Gater may
+// refuse the peer
+func (t *TCPTransport) addPeer(peer *tcpPeer) (*tcpPeer, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.ctx.Err() != nil {
+		return nil, t.ctx.Err()
+	}
+	existing, ok := t.peers[peer.id]
+	if ok {
+		if existing.outbound == peer.outbound || peer.outbound != (t.ID() < peer.id) {
+			return existing, nil
+		}
+		// the slot of the existing connection is taken over and its
+		// read loop leaves the replacement registered
+		existing.conn.Close()
+	} else if t.Gater != nil {
+		if err := t.Gater.AllowPeer(peer.id, peer.outbound); err != nil {
+			return nil, err
+		}
+	}
+
+	t.peers[peer.id] = peer
+	t.wg.Add(1)
+	go t.readLoop(peer)
+
+	return peer, nil
+}
+
+// readLoop delivers the frames received from peer until the connection fails,
+// times out or the transport stops, then drops the peer
+func (t *TCPTransport) readLoop(peer *tcpPeer) {
+	defer t.wg.Done()
+	defer t.removePeer(peer)
+
+	for {
+		peer.conn.SetReadDeadline(time.Now().Add(t.ReadTimeout))
+		payload, err := peer.secure.readMsg()
+		if err != nil {
+			if t.ctx.Err() == nil && !errors.Is(err, io.EOF) {
+				t.Logger.WithField("peer", peer.id).WithError(err).Debug("dropping peer")
+			}
+			return
+		}
+
+		select {
+		case t.consumeCh <- RPC{From: peer.id, Payload: payload}:
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+// removePeer closes the connection to peer and forgets it
+func (t *TCPTransport) removePeer(peer *tcpPeer) {
+	peer.conn.Close()
+
+	t.lock.Lock()
+	removed := t.peers[peer.id] == peer
+	if removed {
+		delete(t.peers, peer.id)
+	}
+	t.lock.Unlock()
+
+	if removed && t.Gater != nil {
+		t.Gater.PeerClosed(peer.id)
+	}
+	close(peer.done)
+}
+
+// allPeers returns a snapshot of the connected peers
+func (t *TCPTransport) allPeers() []*tcpPeer {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	peers := make([]*tcpPeer, 0, len(t.peers))
+	for _, peer := range t.peers {
+		peers = append(peers, peer)
+	}
+
+	return peers
+}
+
+// writeFrame writes payload prefixed with its length as a big endian uint32
+func writeFrame(w io.Writer, payload []byte) error {
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads a single length prefixed frame of at most max bytes
+func readFrame(r io.Reader, max int) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if uint64(size) > uint64(max) {
+		return nil, fmt.Errorf("%w: %d > %d", ErrFrameTooLarge, size, max)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// interface guard
+var _ Transport = (*TCPTransport)(nil)
@@ -0,0 +1,279 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// helper function starting a TCP transport on a random loopback port
+func startTCPTransport(t *testing.T, opts TCPTransportOpts) *TCPTransport {
+	if opts.ListenAddr == "" {
+		opts.ListenAddr = "127.0.0.1:0"
+	}
+
+	tr := NewTCPTransport(opts)
+	assert.NoError(t, tr.Start(context.Background()))
+	t.Cleanup(func() { tr.Close() })
+
+	return tr
+}
+
+// helper function waiting for the next message on tr
+func receive(t *testing.T, tr Transport) RPC {
+	select {
+	case rpc := <-tr.Consume():
+		return rpc
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for message")
+		return RPC{}
+	}
+}
+
+func TestFrame_RoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.NoError(t, writeFrame(buf, []byte("hello world")))
+	assert.NoError(t, writeFrame(buf, []byte{}))
+
+	payload, err := readFrame(buf, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello world"), payload)
+
+	payload, err = readFrame(buf, 64)
+	assert.NoError(t, err)
+	assert.Empty(t, payload)
+
+	// frames above the maximum are refused before reading the payload
+	assert.NoError(t, writeFrame(buf, make([]byte, 65)))
+	_, err = readFrame(buf, 64)
+	assert.ErrorIs(t, err, ErrFrameTooLarge)
+}
+
+func TestTCPTransport_SendAndReply(t *testing.T) {
+	a := startTCPTransport(t, TCPTransportOpts{})
+	b := startTCPTransport(t, TCPTransportOpts{})
+
+	assert.NoError(t, a.Connect(b))
+	assert.Equal(t, []NetAddr{b.ID()}, a.Peers())
+
+	// connecting again reuses the connection
+	assert.NoError(t, a.Connect(b))
+	assert.Equal(t, []NetAddr{b.ID()}, a.Peers())
+
+	assert.NoError(t, a.SendMessage(b.ID(), []byte("ping")))
+	rpc := receive(t, b)
+	assert.Equal(t, a.ID(), rpc.From)
+	assert.Equal(t, []byte("ping"), rpc.Payload)
+
+	// the inbound side answers through the identity it received from
+	assert.NoError(t, b.SendMessage(rpc.From, []byte("pong")))
+	rpc = receive(t, a)
+	assert.Equal(t, b.ID(), rpc.From)
+	assert.Equal(t, []byte("pong"), rpc.Payload)
+}
+
+func TestTCPTransport_Broadcast(t *testing.T) {
+	a := startTCPTransport(t, TCPTransportOpts{})
+	b := startTCPTransport(t, TCPTransportOpts{})
+	c := startTCPTransport(t, TCPTransportOpts{})
+
+	assert.NoError(t, a.Connect(b))
+	assert.NoError(t, a.Connect(c))
+	assert.NoError(t, a.Broadcast([]byte("hello everyone")))
+
+	assert.Equal(t, []byte("hello everyone"), receive(t, b).Payload)
+	assert.Equal(t, []byte("hello everyone"), receive(t, c).Payload)
+}
+
+// helper function opening a raw authenticated connection to tr
+func dialSecure(t *testing.T, tr *TCPTransport) (net.Conn, *secureConn) {
+	_, hostport, err := ParseNodeAddr(tr.Addr())
+	assert.NoError(t, err)
+
+	conn, err := net.Dial("tcp", hostport)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	key, err := crypto.GeneratePrivateKey()
+	assert.NoError(t, err)
+	secure, err := handshake(conn, key, true, tr.PrivateKey.PublicKey(), defaultMaxFrameSize)
+	assert.NoError(t, err)
+
+	return conn, secure
+}
+
+func TestParseNodeAddr(t *testing.T) {
+	key, err := crypto.GeneratePrivateKey()
+	assert.NoError(t, err)
+
+	pub, hostport, err := ParseNodeAddr(NewNodeAddr(key.PublicKey(), "127.0.0.1:3000"))
+	assert.NoError(t, err)
+	assert.Equal(t, key.PublicKey().Key, pub.Key)
+	assert.Equal(t, "127.0.0.1:3000", hostport)
+
+	pub, hostport, err = ParseNodeAddr("127.0.0.1:3000")
+	assert.NoError(t, err)
+	assert.Nil(t, pub)
+	assert.Equal(t, "127.0.0.1:3000", hostport)
+
+	_, _, err = ParseNodeAddr("abcd@127.0.0.1:3000")
+	assert.Error(t, err)
+}
+
+func TestTCPTransport_RejectsUnexpectedIdentity(t *testing.T) {
+	a := startTCPTransport(t, TCPTransportOpts{})
+	b := startTCPTransport(t, TCPTransportOpts{})
+	c := startTCPTransport(t, TCPTransportOpts{})
+
+	// c's identity at b's address
+	_, hostport, err := ParseNodeAddr(b.Addr())
+	assert.NoError(t, err)
+	_, err = a.Dial(NewNodeAddr(c.PrivateKey.PublicKey(), hostport))
+	assert.ErrorIs(t, err, ErrUnexpectedPeer)
+	assert.Empty(t, a.Peers())
+	assert.Eventually(t, func() bool { return len(b.Peers()) == 0 }, time.Second, 10*time.Millisecond)
+
+	// without an expected identity any node is accepted
+	id, err := a.Dial(NetAddr(hostport))
+	assert.NoError(t, err)
+	assert.Equal(t, b.ID(), id)
+	assert.Equal(t, []NetAddr{b.ID()}, a.Peers())
+}
+
+func TestTCPTransport_RejectsSelf(t *testing.T) {
+	a := startTCPTransport(t, TCPTransportOpts{})
+
+	_, err := a.Dial(a.Addr())
+	assert.ErrorIs(t, err, ErrHandshakeFailed)
+	assert.Empty(t, a.Peers())
+}
+
+func TestTCPTransport_DropsUnauthenticatedConnections(t *testing.T) {
+	b := startTCPTransport(t, TCPTransportOpts{HandshakeTimeout: 100 * time.Millisecond})
+	_, hostport, err := ParseNodeAddr(b.Addr())
+	assert.NoError(t, err)
+
+	conn, err := net.Dial("tcp", hostport)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// plaintext frames are not a valid handshake
+	assert.NoError(t, writeFrame(conn, []byte("hello")))
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.Empty(t, b.Peers())
+}
+
+func TestTCPTransport_DropsOversizedFrames(t *testing.T) {
+	b := startTCPTransport(t, TCPTransportOpts{MaxFrameSize: 16})
+	conn, secure := dialSecure(t, b)
+	assert.Eventually(t, func() bool { return len(b.Peers()) == 1 }, time.Second, 5*time.Millisecond)
+
+	assert.NoError(t, secure.writeMsg(make([]byte, 17)))
+
+	// the receiver hangs up
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err := conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool { return len(b.Peers()) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestTCPTransport_ReadTimeout(t *testing.T) {
+	b := startTCPTransport(t, TCPTransportOpts{ReadTimeout: 50 * time.Millisecond})
+	dialSecure(t, b)
+
+	assert.Eventually(t, func() bool { return len(b.Peers()) == 1 }, time.Second, 5*time.Millisecond)
+	// a silent peer is disconnected
+	assert.Eventually(t, func() bool { return len(b.Peers()) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestTCPTransport_ReconnectsStaticPeers(t *testing.T) {
+	// reserve an address for b before it is listening
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	key, err := crypto.GeneratePrivateKey()
+	assert.NoError(t, err)
+	a := startTCPTransport(t, TCPTransportOpts{
+		StaticPeers: []NetAddr{NewNodeAddr(key.PublicKey(), addr)},
+		MinBackoff:  10 * time.Millisecond,
+		MaxBackoff:  50 * time.Millisecond,
+	})
+
+	// b comes up after a started dialling
+	time.Sleep(30 * time.Millisecond)
+	b := startTCPTransport(t, TCPTransportOpts{ListenAddr: addr, PrivateKey: key})
+	assert.Eventually(t, func() bool { return len(a.Peers()) == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	// b restarts; a reconnects
+	b.Close()
+	assert.Eventually(t, func() bool { return len(a.Peers()) == 0 }, 2*time.Second, 10*time.Millisecond)
+	b = startTCPTransport(t, TCPTransportOpts{ListenAddr: addr, PrivateKey: key})
+	assert.Eventually(t, func() bool { return len(a.Peers()) == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, a.SendMessage(b.ID(), []byte("welcome back")))
+	assert.Equal(t, []byte("welcome back"), receive(t, b).Payload)
+}
+
+func TestTCPTransport_SimultaneousDials(t *testing.T) {
+	a := startTCPTransport(t, TCPTransportOpts{})
+	b := startTCPTransport(t, TCPTransportOpts{})
+
+	// both nodes dial each other at once
+	var wg sync.WaitGroup
+	for _, pair := range [][2]*TCPTransport{{a, b}, {b, a}} {
+		wg.Add(1)
+		go func(from, to *TCPTransport) {
+			defer wg.Done()
+			from.Dial(to.Addr())
+		}(pair[0], pair[1])
+	}
+	wg.Wait()
+
+	// both keep the connection dialled by the node with the lower id
+	lower := a.ID() < b.ID()
+	assert.Eventually(t, func() bool {
+		a.lock.RLock()
+		defer a.lock.RUnlock()
+		b.lock.RLock()
+		defer b.lock.RUnlock()
+
+		toB, ok := a.peers[b.ID()]
+		if !ok || toB.outbound != lower {
+			return false
+		}
+		toA, ok := b.peers[a.ID()]
+		return ok && toA.outbound != lower
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, a.SendMessage(b.ID(), []byte("ping")))
+	assert.Equal(t, []byte("ping"), receive(t, b).Payload)
+	assert.NoError(t, b.SendMessage(a.ID(), []byte("pong")))
+	assert.Equal(t, []byte("pong"), receive(t, a).Payload)
+}
+
+func TestTCPTransport_ShutdownWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := NewTCPTransport(TCPTransportOpts{ListenAddr: "127.0.0.1:0"})
+	assert.NoError(t, a.Start(ctx))
+	b := startTCPTransport(t, TCPTransportOpts{})
+	assert.NoError(t, a.Connect(b))
+
+	cancel()
+	// every goroutine exits
+	a.Close()
+	assert.Empty(t, a.Peers())
+	_, err := a.Dial(b.Addr())
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool { return len(b.Peers()) == 0 }, time.Second, 10*time.Millisecond)
+}
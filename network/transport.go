@@ -0,0 +1,32 @@
+package network
+
+// NetAddr is the address of a node on a transport
+type NetAddr string
+
+// RPC is a message received from a peer
+type RPC struct {
+	From    NetAddr // address of the sending peer
+	Payload []byte  // raw message; see the wire protocol for its format
+}
+
+// Transport moves messages between nodes. Implementations must be safe
+// for concurrent use
+type Transport interface {
+	// Consume returns the channel delivering messages received from peers
+	Consume() <-chan RPC
+	// Connect links this transport with tr so they can exchange messages
+	Connect(tr Transport) error
+	// SendMessage sends payload to the connected peer at addr
+	SendMessage(addr NetAddr, payload []byte) error
+	// Broadcast sends payload to every connected peer
+	Broadcast(payload []byte) error
+	// Disconnect drops the connection to the peer at addr
+	Disconnect(addr NetAddr) error
+	// Peers returns the addresses of the connected peers
+	Peers() []NetAddr
+	// Addr returns the address peers reach this transport at
+	Addr() NetAddr
+}
+
+// interface guard
+var _ Transport = (*LocalTransport)(nil)
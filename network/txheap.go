@@ -0,0 +1,166 @@
+package network
+
+import (
+	"container/heap"
+	"math/bits"
+	"sort"
+
+	"github.com/majorshift/safari-chain/crypto"
+)
+
+// compareFeeRate compares the fee per byte of a and b,
+// returning -1, 0 or +1 as a pays less, the same or more than b
+func compareFeeRate(a, b *crypto.Transaction) int {
+	// a.Fee/a.Size() vs b.Fee/b.Size() without division or overflow
+	aHi, aLo := bits.Mul64(a.Fee, uint64(b.Size()))
+	bHi, bLo := bits.Mul64(b.Fee, uint64(a.Size()))
+
+	switch {
+	case aHi < bHi || (aHi == bHi && aLo < bLo):
+		return -1
+	case aHi > bHi || (aHi == bHi && aLo > bLo):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// txHeap is a min-heap of transactions ordered by fee rate, with an index
+// from hash to heap position so any transaction can be removed in O(log n).
+// It is not safe for concurrent use; the mempool lock guards it
+type txHeap struct {
+	items  []*crypto.Transaction
+	hashes []crypto.Hash
+	index  map[crypto.Hash]int
+}
+
+func newTxHeap() *txHeap {
+	return &txHeap{index: make(map[crypto.Hash]int)}
+}
+
+func (h *txHeap) Len() int { return len(h.items) }
+
+func (h *txHeap) Less(i, j int) bool {
+	return compareFeeRate(h.items[i], h.items[j]) < 0
+}
+
+func (h *txHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.hashes[i], h.hashes[j] = h.hashes[j], h.hashes[i]
+	h.index[h.hashes[i]] = i
+	h.index[h.hashes[j]] = j
+}
+
+// Push and Pop are used by container/heap; use push and remove instead
+func (h *txHeap) Push(x any) {
+	tx := x.(*crypto.Transaction)
+	hash := tx.Hash(crypto.TxHash{})
+	h.index[hash] = len(h.items)
+	h.items = append(h.items, tx)
+	h.hashes = append(h.hashes, hash)
+}
+
+func (h *txHeap) Pop() any {
+	n := len(h.items) - 1
+	tx := h.items[n]
+	delete(h.index, h.hashes[n])
+	h.items = h.items[:n]
+	h.hashes = h.hashes[:n]
+	return tx
+}
+
+// push adds tx to the heap
+func (h *txHeap) push(tx *crypto.Transaction) {
+	heap.Push(h, tx)
+}
+
+// remove deletes the transaction matching hash, if present
+func (h *txHeap) remove(hash crypto.Hash) {
+	if i, ok := h.index[hash]; ok {
+		heap.Remove(h, i)
+	}
+}
+
+// peek returns the transaction paying the lowest fee rate or nil if empty
+func (h *txHeap) peek() *crypto.Transaction {
+	if len(h.items) == 0 {
+		return nil
+	}
+
+	return h.items[0]
+}
+
+// sortByPriority orders txs by decreasing fee rate while keeping the
+// transactions of each sender in nonce order. The next transaction of
+// every sender competes with the others; ties keep arrival order
+func sortByPriority(txs []*crypto.Transaction) []*crypto.Transaction {
+	// split by sender, remembering arrival order
+	var senders []string
+	queues := make(map[string][]senderHead)
+	for i, tx := range txs {
+		key := tx.SenderKey()
+		if _, ok := queues[key]; !ok {
+			senders = append(senders, key)
+		}
+		queues[key] = append(queues[key], senderHead{key: key, tx: tx, arrival: i})
+	}
+
+	// nonce order within each sender, seeding the heap with the lowest nonce
+	heads := &senderHeap{}
+	for _, key := range senders {
+		q := queues[key]
+		sort.SliceStable(q, func(i, j int) bool {
+			return q[i].tx.Nonce < q[j].tx.Nonce
+		})
+		heads.items = append(heads.items, q[0])
+		queues[key] = q[1:]
+	}
+	heap.Init(heads)
+
+	// repeatedly take the best head among all senders
+	sorted := make([]*crypto.Transaction, 0, len(txs))
+	for heads.Len() > 0 {
+		head := heap.Pop(heads).(senderHead)
+		sorted = append(sorted, head.tx)
+
+		if q := queues[head.key]; len(q) > 0 {
+			heap.Push(heads, q[0])
+			queues[head.key] = q[1:]
+		}
+	}
+
+	return sorted
+}
+
+// senderHead is the next transaction of a sender waiting to be ordered
+type senderHead struct {
+	key     string
+	tx      *crypto.Transaction
+	arrival int
+}
+
+// senderHeap is a max-heap of sender heads by fee rate, then arrival
+type senderHeap struct {
+	items []senderHead
+}
+
+func (h *senderHeap) Len() int { return len(h.items) }
+
+func (h *senderHeap) Less(i, j int) bool {
+	if c := compareFeeRate(h.items[i].tx, h.items[j].tx); c != 0 {
+		return c > 0
+	}
+
+	return h.items[i].arrival < h.items[j].arrival
+}
+
+func (h *senderHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *senderHeap) Push(x any) { h.items = append(h.items, x.(senderHead)) }
+
+func (h *senderHeap) Pop() any {
+	n := len(h.items) - 1
+	item := h.items[n]
+	h.items = h.items[:n]
+	return item
+}
@@ -0,0 +1,85 @@
+package network
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/majorshift/safari-chain/crypto"
+)
+
+var benchPoolSizes = []int{1000, 10000, 50000}
+
+// helper function creating n distinct unsigned transactions and their hashes
+func newBenchTxs(n int) ([]*crypto.Transaction, []crypto.Hash) {
+	txs := make([]*crypto.Transaction, n)
+	hashes := make([]crypto.Hash, n)
+	for i := range txs {
+		txs[i] = &crypto.Transaction{Data: []byte(fmt.Sprintf("tx %d", i))}
+		hashes[i] = txs[i].Hash(crypto.TxHash{})
+	}
+
+	return txs, hashes
+}
+
+// helper function filling a TxMap with txs
+func newBenchTxMap(txs []*crypto.Transaction) *TxMap {
+	m := NewTxMap()
+	for _, tx := range txs {
+		m.Add(tx)
+	}
+
+	return m
+}
+
+// BenchmarkTxMap_RemoveMiddle removes and re-adds a transaction from the middle of a full map
+func BenchmarkTxMap_RemoveMiddle(b *testing.B) {
+	for _, size := range benchPoolSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			txs, hashes := newBenchTxs(size)
+			m := newBenchTxMap(txs)
+			mid := size / 2
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.Remove(hashes[mid])
+				m.Add(txs[mid])
+			}
+		})
+	}
+}
+
+// BenchmarkTxMap_EvictOldest pops the oldest transaction and re-adds it at the back
+func BenchmarkTxMap_EvictOldest(b *testing.B) {
+	for _, size := range benchPoolSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			txs, _ := newBenchTxs(size)
+			m := newBenchTxMap(txs)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				oldest := m.First()
+				m.Remove(oldest.Hash(crypto.TxHash{}))
+				m.Add(oldest)
+			}
+		})
+	}
+}
+
+// BenchmarkTxMap_Add measures adding to a map of the given size
+func BenchmarkTxMap_Add(b *testing.B) {
+	for _, size := range benchPoolSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			txs, hashes := newBenchTxs(size + 1)
+			m := newBenchTxMap(txs[:size])
+			extra, extraHash := txs[size], hashes[size]
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.Add(extra)
+				b.StopTimer()
+				m.Remove(extraHash)
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package network
+
+import (
+	"sort"
+
+	"github.com/majorshift/safari-chain/crypto"
+)
+
+// senderQueue holds the pooled transactions of a single sender by nonce
+type senderQueue struct {
+	from *crypto.PublicKey
+	txs  map[uint64]*crypto.Transaction
+}
+
+func newSenderQueue(from *crypto.PublicKey) *senderQueue {
+	return &senderQueue{
+		from: from,
+		txs:  make(map[uint64]*crypto.Transaction),
+	}
+}
+
+// sorted returns the transactions of the queue in nonce order
+func (q *senderQueue) sorted() []*crypto.Transaction {
+	txs := make([]*crypto.Transaction, 0, len(q.txs))
+	for _, tx := range q.txs {
+		txs = append(txs, tx)
+	}
+	sort.Slice(txs, func(i, j int) bool {
+		return txs[i].Nonce < txs[j].Nonce
+	})
+
+	return txs
+}
@@ -0,0 +1,259 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/majorshift/safari-chain/network"
+)
+
+const defaultPendingLimit = 100
+
+// chain_getHeight returns the height of the chain head
+func (s *Server) getHeight(params json.RawMessage) (any, error) {
+	if err := positional(params, 0); err != nil {
+		return nil, err
+	}
+
+	return s.chain.GetBlockchainHeight(), nil
+}
+
+// chain_getBlockByHeight takes a height and returns the block at it
+func (s *Server) getBlockByHeight(params json.RawMessage) (any, error) {
+	var height uint32
+	if err := positional(params, 1, &height); err != nil {
+		return nil, err
+	}
+
+	b, err := s.chain.GetBlockByHeight(height)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return newBlock(b), nil
+}
+
+// chain_getBlockByHash takes a hex block hash and returns the block
+func (s *Server) getBlockByHash(params json.RawMessage) (any, error) {
+	var hash string
+	if err := positional(params, 1, &hash); err != nil {
+		return nil, err
+	}
+	h, err := parseHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := s.chain.GetBlockByHash(h)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return newBlock(b), nil
+}
+
+// chain_getHeader takes a height or a hex block hash and returns the
+// header of the block
+func (s *Server) getHeader(params json.RawMessage) (any, error) {
+	var id json.RawMessage
+	if err := positional(params, 1, &id); err != nil {
+		return nil, err
+	}
+
+	var height uint32
+	if err := json.Unmarshal(id, &height); err == nil {
+		h, err := s.chain.GetHeaderByHeight(height)
+		if err != nil {
+			return nil, notFound(err)
+		}
+		return newHeader(h), nil
+	}
+
+	var hash string
+	if err := json.Unmarshal(id, &hash); err != nil {
+		return nil, invalidParams("param 0 must be a height or a block hash")
+	}
+	h, err := parseHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.chain.GetBlockByHash(h)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return newHeader(b.Header), nil
+}
+
+// tx_get takes a hex transaction hash and returns the transaction, from
+// the chain or else from the mempool, along with where it was found
+func (s *Server) getTx(params json.RawMessage) (any, error) {
+	var hash string
+	if err := positional(params, 1, &hash); err != nil {
+		return nil, err
+	}
+	h, err := parseHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx, b, err := s.chain.GetTransaction(h); err == nil {
+		height := b.Height
+		return &TxResult{
+			Transaction: newTransaction(tx),
+			Status:      TxIncluded,
+			BlockHash:   b.Hash(crypto.BlockHash{}).ToString(),
+			BlockHeight: &height,
+		}, nil
+	} else if !errors.Is(err, crypto.ErrUnknownTx) {
+		return nil, err
+	}
+
+	tx := s.mempool.Get(h)
+	if tx == nil {
+		return nil, notFound(fmt.Errorf("transaction %s: %w", hash, crypto.ErrUnknownTx))
+	}
+
+	return &TxResult{Transaction: newTransaction(tx), Status: s.poolStatus(h)}, nil
+}
+
+// poolStatus tells whether the pooled transaction matching hash is pending or queued
+func (s *Server) poolStatus(hash crypto.Hash) TxStatus {
+	if s.mempool.IsPending(hash) {
+		return TxPending
+	}
+
+	return TxQueued
+}
+
+// tx_send takes a hex encoded signed transaction, submits it and returns
+// its hash. Rejections carry the reason as error data
+func (s *Server) sendTx(params json.RawMessage) (any, error) {
+	var raw string
+	if err := positional(params, 1, &raw); err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(raw)
+	if err != nil {
+		return nil, invalidParams("transaction is not hex encoded: %v", err)
+	}
+
+	tx := &crypto.Transaction{}
+	if err := tx.UnmarshalBinary(b); err != nil {
+		return nil, invalidParams("%v", err)
+	}
+
+	if err := s.Submit(tx); err != nil {
+		var rejected *network.RejectError
+		if errors.As(err, &rejected) {
+			return nil, &Error{Code: CodeTxRejected, Message: err.Error(), Data: rejected.Reason.String()}
+		}
+		return nil, err
+	}
+
+	return tx.Hash(crypto.TxHash{}).ToString(), nil
+}
+
+// mempool_status returns the number of pooled transactions
+func (s *Server) mempoolStatus(params json.RawMessage) (any, error) {
+	if err := positional(params, 0); err != nil {
+		return nil, err
+	}
+
+	total := s.mempool.AllTxCount()
+	pending := s.mempool.PendingTxCount()
+
+	return &MempoolStatus{Pending: pending, Queued: total - pending, Total: total}, nil
+}
+
+// node_metrics returns the metrics counted by the node since it started
+func (s *Server) nodeMetrics(params json.RawMessage) (any, error) {
+	if err := positional(params, 0); err != nil {
+		return nil, err
+	}
+
+	snapshot := s.Metrics.Snapshot()
+	return &snapshot, nil
+}
+
+// mempool_pending returns the executable transactions by priority. It
+// takes an optional limit, 100 or MaxPending if lower by default and at
+// most MaxPending
+func (s *Server) mempoolPending(params json.RawMessage) (any, error) {
+	limit := min(defaultPendingLimit, s.MaxPending)
+	if err := positional(params, 0, &limit); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > s.MaxPending {
+		return nil, invalidParams("limit must be between 1 and %d", s.MaxPending)
+	}
+
+	pending := s.mempool.GetPendingTx()
+	txs := make([]*Transaction, 0, min(limit, len(pending)))
+	for _, tx := range pending[:min(limit, len(pending))] {
+		txs = append(txs, newTransaction(tx))
+	}
+
+	return txs, nil
+}
+
+// positional decodes the params array into dst. The first required
+// params must be present, the others are optional
+func positional(params json.RawMessage, required int, dst ...any) error {
+	var items []json.RawMessage
+	if trimmed := bytes.TrimSpace(params); len(trimmed) > 0 && !bytes.Equal(trimmed, []byte("null")) {
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return invalidParams("params must be an array")
+		}
+	}
+	if len(items) < required || len(items) > len(dst) {
+		if required == len(dst) {
+			return invalidParams("expected %d params, got %d", required, len(items))
+		}
+		return invalidParams("expected %d to %d params, got %d", required, len(dst), len(items))
+	}
+
+	for i, item := range items {
+		if err := json.Unmarshal(item, dst[i]); err != nil {
+			return invalidParams("param %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+func parseHash(s string) (crypto.Hash, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return crypto.Hash{}, invalidParams("%q is not a hex hash", s)
+	}
+	h, err := crypto.BytesToHash(b)
+	if err != nil {
+		return crypto.Hash{}, invalidParams("%v", err)
+	}
+
+	return h, nil
+}
+
+func invalidParams(format string, args ...any) *Error {
+	return &Error{Code: CodeInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+func notFound(err error) *Error {
+	return &Error{Code: CodeNotFound, Message: err.Error()}
+}
+
+// parseAddress decodes a hex account address, with or without the 0x
+// prefix, and returns it in the lower case form notifications carry
+func parseAddress(s string) (string, error) {
+	addr, err := crypto.ParseAddress(s)
+	if err != nil {
+		return "", invalidParams("%v", err)
+	}
+
+	return addr.String(), nil
+}
@@ -0,0 +1,350 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/majorshift/safari-chain/events"
+	"github.com/majorshift/safari-chain/network"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultMaxRequestSize = 1 << 20 // 1 MiB, room for a batch of maximum size transactions
+	defaultMaxBatchSize   = 100
+	defaultMaxPending     = 1000
+	defaultShutdown       = 5 * time.Second
+	jsonrpcVersion        = "2.0"
+)
+
+// Error codes of the JSON-RPC 2.0 specification, followed by the
+// application codes of this API
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeNotFound       = -32000 // no such block or transaction
+	CodeTxRejected     = -32001 // the mempool refused the transaction
+)
+
+// Error is a JSON-RPC error object
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// ServerOpts configures a Server
+type ServerOpts struct {
+	MaxRequestSize int64 // largest accepted request body in bytes; defaults to 1 MiB
+	MaxBatchSize   int   // most calls in a batch request; defaults to 100
+	MaxPending     int   // most transactions mempool_pending returns; defaults to 1000
+	// Submit admits the transactions sent with tx_send; defaults to the
+	// AddLocal method of the mempool. Nodes pass one that also gossips them
+	Submit func(*crypto.Transaction) error
+	// Metrics answers node_metrics; the method is not served without it
+	Metrics *network.Metrics
+
+	SendBuffer       int           // messages queued per websocket connection before it is dropped; defaults to 256
+	MaxSubscriptions int           // subscriptions per websocket connection; defaults to 32
+	WriteTimeout     time.Duration // time allowed to write a websocket message; defaults to 10s
+	PingInterval     time.Duration // interval between websocket pings; defaults to 30s
+	Logger           *logrus.Logger
+}
+
+// Server answers JSON-RPC 2.0 requests about the chain and the mempool,
+// sent with HTTP POST or over a websocket connection. Batches are
+// supported; notifications, calls without an id, are run but not
+// answered. Websocket clients may also subscribe to topics, whose
+// notifications are pushed to them as the chain and the mempool change
+type Server struct {
+	ServerOpts
+	chain     *crypto.Blockchain
+	mempool   *network.MemPool
+	methods   map[string]method
+	wsMethods map[string]wsMethod
+	upgrader  websocket.Upgrader
+	subLock   sync.Mutex                  // guards the subscriptions and connections
+	subs      map[string]*subscription    // subscriptions by id
+	conns     map[*wsConn]struct{}        // open websocket connections
+	lastSub   uint64                      // last subscription id handed out
+	feeds     []*events.Subscription[any] // chain and mempool events feeding the subscriptions
+}
+
+// method runs a call with the given params and returns its result
+type method func(params json.RawMessage) (any, error)
+
+// request is a JSON-RPC request object
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// response is a JSON-RPC response object
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+func NewServer(chain *crypto.Blockchain, mempool *network.MemPool, opts ServerOpts) *Server {
+	if opts.MaxRequestSize == 0 {
+		opts.MaxRequestSize = defaultMaxRequestSize
+	}
+	if opts.MaxBatchSize == 0 {
+		opts.MaxBatchSize = defaultMaxBatchSize
+	}
+	if opts.MaxPending == 0 {
+		opts.MaxPending = defaultMaxPending
+	}
+	if opts.Submit == nil {
+		opts.Submit = mempool.AddLocal
+	}
+	if opts.SendBuffer == 0 {
+		opts.SendBuffer = defaultSendBuffer
+	}
+	if opts.MaxSubscriptions == 0 {
+		opts.MaxSubscriptions = defaultMaxSubscriptions
+	}
+	if opts.WriteTimeout == 0 {
+		opts.WriteTimeout = defaultWriteTimeout
+	}
+	if opts.PingInterval == 0 {
+		opts.PingInterval = defaultPingInterval
+	}
+	if opts.Logger == nil {
+		opts.Logger = logrus.New()
+	}
+
+	s := &Server{
+		ServerOpts: opts,
+		chain:      chain,
+		mempool:    mempool,
+		subs:       make(map[string]*subscription),
+		conns:      make(map[*wsConn]struct{}),
+	}
+	s.methods = map[string]method{
+		"chain_getHeight":        s.getHeight,
+		"chain_getBlockByHeight": s.getBlockByHeight,
+		"chain_getBlockByHash":   s.getBlockByHash,
+		"chain_getHeader":        s.getHeader,
+		"tx_get":                 s.getTx,
+		"tx_send":                s.sendTx,
+		"mempool_status":         s.mempoolStatus,
+		"mempool_pending":        s.mempoolPending,
+	}
+	if opts.Metrics != nil {
+		s.methods["node_metrics"] = s.nodeMetrics
+	}
+	s.wsMethods = map[string]wsMethod{
+		"subscribe":   s.subscribe,
+		"unsubscribe": s.unsubscribe,
+	}
+	// every transaction of a block is an event of its own
+	chainBuffer := max(eventBuffer, feedBlocks*(chain.Config().MaxTxsPerBlock()+1))
+	s.feeds = append(s.feeds, events.Subscribe[any](chain.Events(), chainBuffer))
+	if mempool.Bus != chain.Events() {
+		s.feeds = append(s.feeds, events.Subscribe[any](mempool.Bus, eventBuffer))
+	}
+	for _, sub := range s.feeds {
+		go s.forward(sub)
+	}
+
+	return s
+}
+
+// Close stops following the chain and the mempool and drops every
+// websocket connection. Start closes the server once its context is done
+func (s *Server) Close() {
+	for _, sub := range s.feeds {
+		sub.Unsubscribe()
+	}
+	s.closeConns()
+}
+
+// Start serves the API on addr until ctx is cancelled, then waits for
+// the requests in progress to finish
+func (s *Server) Start(ctx context.Context, addr string) (net.Addr, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.Logger.WithError(err).Error("rpc server failed")
+		}
+	}()
+	context.AfterFunc(ctx, func() {
+		shutdown, cancel := context.WithTimeout(context.Background(), defaultShutdown)
+		defer cancel()
+		srv.Shutdown(shutdown)
+		s.Close()
+	})
+
+	s.Logger.WithField("addr", ln.Addr()).Info("rpc server listening")
+	return ln.Addr(), nil
+}
+
+// ServeHTTP answers a single or batch JSON-RPC request, or upgrades the
+// request to a websocket connection
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		s.serveWS(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "JSON-RPC requests must be sent with POST or over a websocket", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.MaxRequestSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.write(w, http.StatusRequestEntityTooLarge, errorResponse(nil, &Error{
+				Code:    CodeInvalidRequest,
+				Message: fmt.Sprintf("request exceeds %d bytes", s.MaxRequestSize),
+			}))
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := s.process(body, nil)
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.write(w, http.StatusOK, resp)
+}
+
+// process answers a single or batch request received over HTTP, or over
+// the websocket connection conn when it is not nil. It returns nil when
+// there is nothing to answer, the request holding only notifications
+func (s *Server) process(body []byte, conn *wsConn) any {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		return s.processBatch(body, conn)
+	}
+
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		return errorResponse(nil, &Error{Code: CodeParseError, Message: err.Error()})
+	}
+	if resp := s.call(&req, conn); resp != nil {
+		return resp
+	}
+
+	return nil
+}
+
+// processBatch answers a batch of calls with the array of their responses
+func (s *Server) processBatch(body []byte, conn *wsConn) any {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return errorResponse(nil, &Error{Code: CodeParseError, Message: err.Error()})
+	}
+	if len(batch) == 0 {
+		return errorResponse(nil, &Error{Code: CodeInvalidRequest, Message: "empty batch"})
+	}
+	if len(batch) > s.MaxBatchSize {
+		return errorResponse(nil, &Error{
+			Code:    CodeInvalidRequest,
+			Message: fmt.Sprintf("batch of %d calls exceeds %d", len(batch), s.MaxBatchSize),
+		})
+	}
+
+	responses := make([]*response, 0, len(batch))
+	for _, raw := range batch {
+		var req request
+		if err := json.Unmarshal(raw, &req); err != nil {
+			responses = append(responses, errorResponse(nil, &Error{Code: CodeInvalidRequest, Message: err.Error()}))
+			continue
+		}
+		if resp := s.call(&req, conn); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+
+	if len(responses) == 0 {
+		return nil
+	}
+	return responses
+}
+
+// call runs a single request. It returns nil for notifications
+func (s *Server) call(req *request, conn *wsConn) *response {
+	if req.JSONRPC != jsonrpcVersion || req.Method == "" {
+		return errorResponse(req.ID, &Error{Code: CodeInvalidRequest, Message: `requests need "jsonrpc": "2.0" and a method`})
+	}
+
+	var result any
+	var err error
+	if m, ok := s.methods[req.Method]; ok {
+		result, err = m(req.Params)
+	} else if m, ok := s.wsMethods[req.Method]; ok {
+		if conn == nil {
+			err = &Error{Code: CodeInvalidRequest, Message: fmt.Sprintf("method %q needs a websocket connection", req.Method)}
+		} else {
+			result, err = m(conn, req.Params)
+		}
+	} else {
+		err = &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("method %q not found", req.Method)}
+	}
+
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			s.Logger.WithField("method", req.Method).WithError(err).Error("rpc call failed")
+			rpcErr = &Error{Code: CodeInternalError, Message: err.Error()}
+		}
+		return errorResponse(req.ID, rpcErr)
+	}
+
+	return &response{JSONRPC: jsonrpcVersion, Result: result, ID: req.ID}
+}
+
+func (s *Server) write(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.Logger.WithError(err).Debug("failed to write rpc response")
+	}
+}
+
+func errorResponse(id json.RawMessage, err *Error) *response {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	return &response{JSONRPC: jsonrpcVersion, Error: err, ID: id}
+}
@@ -0,0 +1,268 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/majorshift/safari-chain/network"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// testNode holds a chain with a funded sender and a mempool following it
+type testNode struct {
+	chain   *crypto.Blockchain
+	mempool *network.MemPool
+	sender  *crypto.PrivateKey
+	api     *Server
+	server  *httptest.Server
+}
+
+// helper function creating a node and serving its API until the test ends
+func newTestNode(t *testing.T, opts ServerOpts) *testNode {
+	validator, _ := crypto.GeneratePrivateKey()
+	sender, _ := crypto.GeneratePrivateKey()
+	config := crypto.DefaultChainConfig()
+	addr := sender.PublicKey().Address()
+	config.Alloc = map[string]uint64{addr.String(): 1000}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	genesis := crypto.NewSignedBlockExample(validator, []*crypto.Transaction{}, 0, crypto.Hash{})
+	chain := crypto.NewBlockchainWithConfig(logger, config, genesis)
+	mempool := network.NewMempoolWithOpts(network.MempoolOpts{MaxSize: 100, ChainID: config.ChainID, Bus: chain.Events()})
+	mempool.SubscribeChain(chain)
+
+	opts.Logger = logger
+	api := NewServer(chain, mempool, opts)
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	t.Cleanup(api.Close)
+
+	return &testNode{chain: chain, mempool: mempool, sender: sender, api: api, server: server}
+}
+
+// helper function signing a transfer from the funded sender
+func (n *testNode) transfer(t *testing.T, nonce uint64) *crypto.Transaction {
+	receiver, _ := crypto.GeneratePrivateKey()
+	tx := crypto.NewTransaction(n.sender.PublicKey(), receiver.PublicKey(), []byte("transfer"))
+	tx.Nonce = nonce
+	tx.Value = 10
+	tx.ChainID = n.chain.Config().ChainID
+	tx.Sign(n.sender)
+
+	return tx
+}
+
+// helper function adding a block of txs on top of the chain
+func (n *testNode) addBlock(t *testing.T, txs ...*crypto.Transaction) *crypto.Block {
+	height := n.chain.GetBlockchainHeight()
+	parent, err := n.chain.GetHeaderByHeight(height)
+	assert.NoError(t, err)
+
+	validator, _ := crypto.GeneratePrivateKey()
+	b := crypto.NewSignedBlockExample(validator, txs, height+1, crypto.BlockHash{}.Hash(parent))
+	assert.NoError(t, n.chain.AddBlock(b))
+
+	return b
+}
+
+// helper function posting body and decoding the response into v
+func (n *testNode) post(t *testing.T, body string, v any) int {
+	resp, err := http.Post(n.server.URL, "application/json", strings.NewReader(body))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	if v != nil && resp.StatusCode != http.StatusNoContent {
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+	return resp.StatusCode
+}
+
+// helper function calling method and decoding its result into result
+func (n *testNode) call(t *testing.T, method string, params []any, result any) *Error {
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	assert.NoError(t, err)
+
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+		ID     int             `json:"id"`
+	}
+	assert.Equal(t, http.StatusOK, n.post(t, string(body), &resp))
+	assert.Equal(t, 1, resp.ID)
+	if resp.Error == nil && result != nil {
+		assert.NoError(t, json.Unmarshal(resp.Result, result))
+	}
+
+	return resp.Error
+}
+
+func TestServer_ChainMethods(t *testing.T) {
+	n := newTestNode(t, ServerOpts{})
+	tx := n.transfer(t, 0)
+	b := n.addBlock(t, tx)
+	hash := b.Hash(crypto.BlockHash{}).ToString()
+
+	var height uint32
+	assert.Nil(t, n.call(t, "chain_getHeight", nil, &height))
+	assert.Equal(t, uint32(1), height)
+
+	var byHeight, byHash Block
+	assert.Nil(t, n.call(t, "chain_getBlockByHeight", []any{1}, &byHeight))
+	assert.Nil(t, n.call(t, "chain_getBlockByHash", []any{hash}, &byHash))
+	assert.Equal(t, byHeight, byHash)
+	assert.Equal(t, hash, byHash.Hash)
+	assert.Len(t, byHash.Transactions, 1)
+	assert.Equal(t, tx.Hash(crypto.TxHash{}).ToString(), byHash.Transactions[0].Hash)
+
+	var header Header
+	assert.Nil(t, n.call(t, "chain_getHeader", []any{hash}, &header))
+	assert.Equal(t, byHash.Header, header)
+	assert.Nil(t, n.call(t, "chain_getHeader", []any{0}, &header))
+	assert.Equal(t, uint32(0), header.Height)
+
+	var got TxResult
+	assert.Nil(t, n.call(t, "tx_get", []any{tx.Hash(crypto.TxHash{}).ToString()}, &got))
+	assert.Equal(t, TxIncluded, got.Status)
+	assert.Equal(t, hash, got.BlockHash)
+	assert.Equal(t, uint32(1), *got.BlockHeight)
+
+	// unknown blocks and bad params are reported with their codes
+	assert.Equal(t, CodeNotFound, n.call(t, "chain_getBlockByHeight", []any{5}, nil).Code)
+	assert.Equal(t, CodeNotFound, n.call(t, "chain_getBlockByHash", []any{crypto.Hash{}.ToString()}, nil).Code)
+	assert.Equal(t, CodeInvalidParams, n.call(t, "chain_getBlockByHash", []any{"zz"}, nil).Code)
+	assert.Equal(t, CodeInvalidParams, n.call(t, "chain_getBlockByHeight", nil, nil).Code)
+	assert.Equal(t, CodeInvalidParams, n.call(t, "chain_getHeader", []any{true}, nil).Code)
+	assert.Equal(t, CodeMethodNotFound, n.call(t, "chain_nope", nil, nil).Code)
+}
+
+func TestServer_TxAndMempoolMethods(t *testing.T) {
+	n := newTestNode(t, ServerOpts{})
+
+	// a pending and a queued transaction
+	var hashes []string
+	for _, nonce := range []uint64{0, 2} {
+		b, err := n.transfer(t, nonce).MarshalBinary()
+		assert.NoError(t, err)
+		var hash string
+		assert.Nil(t, n.call(t, "tx_send", []any{hex.EncodeToString(b)}, &hash))
+		hashes = append(hashes, hash)
+	}
+
+	var got TxResult
+	assert.Nil(t, n.call(t, "tx_get", []any{hashes[0]}, &got))
+	assert.Equal(t, TxPending, got.Status)
+	assert.Nil(t, got.BlockHeight)
+	assert.Nil(t, n.call(t, "tx_get", []any{hashes[1]}, &got))
+	assert.Equal(t, TxQueued, got.Status)
+	assert.Equal(t, CodeNotFound, n.call(t, "tx_get", []any{crypto.Hash{}.ToString()}, nil).Code)
+
+	var status MempoolStatus
+	assert.Nil(t, n.call(t, "mempool_status", nil, &status))
+	assert.Equal(t, MempoolStatus{Pending: 1, Queued: 1, Total: 2}, status)
+
+	var pending []*Transaction
+	assert.Nil(t, n.call(t, "mempool_pending", []any{10}, &pending))
+	assert.Len(t, pending, 1)
+	assert.Equal(t, hashes[0], pending[0].Hash)
+	assert.Equal(t, CodeInvalidParams, n.call(t, "mempool_pending", []any{0}, nil).Code)
+
+	// a used nonce is rejected with the reason as data
+	b, _ := n.transfer(t, 0).MarshalBinary()
+	n.addBlock(t, n.mempool.Get(mustHash(t, hashes[0])))
+	rpcErr := n.call(t, "tx_send", []any{hex.EncodeToString(b)}, nil)
+	assert.Equal(t, CodeTxRejected, rpcErr.Code)
+	assert.Equal(t, network.RejectNonceTooLow.String(), rpcErr.Data)
+	assert.Equal(t, CodeInvalidParams, n.call(t, "tx_send", []any{"00"}, nil).Code)
+}
+
+func TestServer_PendingLimitDefaultsToMaxPending(t *testing.T) {
+	n := newTestNode(t, ServerOpts{MaxPending: 1})
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		assert.NoError(t, n.mempool.Add(n.transfer(t, nonce)))
+	}
+
+	var pending []*Transaction
+	assert.Nil(t, n.call(t, "mempool_pending", nil, &pending))
+	assert.Len(t, pending, 1)
+	assert.Equal(t, CodeInvalidParams, n.call(t, "mempool_pending", []any{2}, nil).Code)
+}
+
+func TestServer_Protocol(t *testing.T) {
+	n := newTestNode(t, ServerOpts{MaxRequestSize: 256, MaxBatchSize: 2})
+
+	var resp response
+	assert.Equal(t, http.StatusOK, n.post(t, "{", &resp))
+	assert.Equal(t, CodeParseError, resp.Error.Code)
+	assert.Equal(t, http.StatusOK, n.post(t, `{"method":"chain_getHeight","id":1}`, &resp))
+	assert.Equal(t, CodeInvalidRequest, resp.Error.Code)
+
+	// batches are answered in order, without the notifications
+	var batch []struct {
+		Result json.RawMessage `json:"result"`
+		ID     int             `json:"id"`
+	}
+	status := n.post(t, `[{"jsonrpc":"2.0","method":"chain_getHeight","id":7},{"jsonrpc":"2.0","method":"mempool_status"}]`, &batch)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, batch, 1)
+	assert.Equal(t, 7, batch[0].ID)
+	assert.Equal(t, "0", string(batch[0].Result))
+
+	assert.Equal(t, http.StatusNoContent, n.post(t, `{"jsonrpc":"2.0","method":"chain_getHeight"}`, nil))
+	assert.Equal(t, http.StatusOK, n.post(t, `[]`, &resp))
+	assert.Equal(t, CodeInvalidRequest, resp.Error.Code)
+	call := `{"jsonrpc":"2.0","method":"chain_getHeight","id":1}`
+	assert.Equal(t, http.StatusOK, n.post(t, "["+strings.Repeat(call+",", 2)+call+"]", &resp))
+	assert.Equal(t, CodeInvalidRequest, resp.Error.Code)
+
+	// oversized bodies and other methods are refused
+	assert.Equal(t, http.StatusRequestEntityTooLarge, n.post(t, string(bytes.Repeat([]byte(" "), 300)), &resp))
+	assert.Equal(t, CodeInvalidRequest, resp.Error.Code)
+	get, err := http.Get(n.server.URL)
+	assert.NoError(t, err)
+	get.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, get.StatusCode)
+}
+
+// helper function decoding a hex hash
+func mustHash(t *testing.T, s string) crypto.Hash {
+	b, err := hex.DecodeString(s)
+	assert.NoError(t, err)
+	h, err := crypto.BytesToHash(b)
+	assert.NoError(t, err)
+
+	return h
+}
+
+func TestServer_NodeMetrics(t *testing.T) {
+	n := newTestNode(t, ServerOpts{})
+	assert.Equal(t, CodeMethodNotFound, n.call(t, "node_metrics", nil, nil).Code)
+
+	metrics := network.NewMetrics(n.chain, n.mempool)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go metrics.Start(ctx)
+
+	api := NewServer(n.chain, n.mempool, ServerOpts{Metrics: metrics, Logger: n.api.Logger})
+	served := &testNode{chain: n.chain, mempool: n.mempool, sender: n.sender, api: api, server: httptest.NewServer(api)}
+	t.Cleanup(served.server.Close)
+	t.Cleanup(api.Close)
+
+	n.addBlock(t, n.transfer(t, 0))
+	assert.Eventually(t, func() bool { return metrics.Snapshot().Blocks == 1 }, time.Second, 5*time.Millisecond)
+
+	var got network.MetricsSnapshot
+	assert.Nil(t, served.call(t, "node_metrics", nil, &got))
+	assert.Equal(t, uint32(1), got.Height)
+	assert.Equal(t, uint64(1), got.TxsIncluded)
+	assert.Equal(t, CodeInvalidParams, served.call(t, "node_metrics", []any{1}, nil).Code)
+}
@@ -0,0 +1,123 @@
+package rpc
+
+import (
+	"encoding/hex"
+
+	"github.com/majorshift/safari-chain/crypto"
+)
+
+// Header is the JSON view of a block header. Hashes, keys and byte
+// strings are hex encoded
+type Header struct {
+	Hash          string `json:"hash"`
+	Version       uint32 `json:"version"`
+	PrevBlockHash string `json:"prevBlockHash"`
+	MerkleRoot    string `json:"merkleRoot"`
+	Timestamp     int64  `json:"timestamp"`
+	Height        uint32 `json:"height"`
+}
+
+// Block is the JSON view of a block with its transactions
+type Block struct {
+	Header
+	Validator    string         `json:"validator"`
+	Signature    string         `json:"signature"`
+	Size         int            `json:"size"`
+	Transactions []*Transaction `json:"transactions"`
+}
+
+// Transaction is the JSON view of a transaction
+type Transaction struct {
+	Hash      string `json:"hash"`
+	From      string `json:"from"`
+	Sender    string `json:"sender"` // address of the From key
+	Receiver  string `json:"receiver"`
+	Value     uint64 `json:"value"`
+	Nonce     uint64 `json:"nonce"`
+	Fee       uint64 `json:"fee"`
+	Expiry    uint32 `json:"expiry"`
+	ChainID   uint32 `json:"chainId"`
+	Data      string `json:"data"`
+	Signature string `json:"signature"`
+}
+
+// TxStatus tells where a transaction returned by tx_get was found
+type TxStatus string
+
+const (
+	TxIncluded TxStatus = "included" // in a block of the chain
+	TxPending  TxStatus = "pending"  // in the mempool, executable
+	TxQueued   TxStatus = "queued"   // in the mempool, waiting for an earlier nonce
+)
+
+// TxResult is the result of tx_get
+type TxResult struct {
+	Transaction *Transaction `json:"transaction"`
+	Status      TxStatus     `json:"status"`
+	BlockHash   string       `json:"blockHash,omitempty"`
+	BlockHeight *uint32      `json:"blockHeight,omitempty"`
+}
+
+// MempoolStatus is the result of mempool_status
+type MempoolStatus struct {
+	Pending int `json:"pending"` // executable transactions
+	Queued  int `json:"queued"`  // transactions waiting for an earlier nonce
+	Total   int `json:"total"`
+}
+
+func newHeader(h *crypto.Header) *Header {
+	return &Header{
+		Hash:          crypto.BlockHash{}.Hash(h).ToString(),
+		Version:       h.Version,
+		PrevBlockHash: h.PrevBlockHash.ToString(),
+		MerkleRoot:    h.MerkleRoot.ToString(),
+		Timestamp:     h.Timestamp,
+		Height:        h.Height,
+	}
+}
+
+func newBlock(b *crypto.Block) *Block {
+	txs := make([]*Transaction, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		txs[i] = newTransaction(tx)
+	}
+
+	block := &Block{
+		Header:       *newHeader(b.Header),
+		Size:         b.Size(),
+		Transactions: txs,
+	}
+	if b.Validator != nil {
+		block.Validator = hex.EncodeToString(b.Validator.ToBytes())
+	}
+	if b.Signature != nil {
+		block.Signature = hex.EncodeToString(b.Signature.ToBytes())
+	}
+
+	return block
+}
+
+func newTransaction(tx *crypto.Transaction) *Transaction {
+	t := &Transaction{
+		Hash:    tx.Hash(crypto.TxHash{}).ToString(),
+		Value:   tx.Value,
+		Nonce:   tx.Nonce,
+		Fee:     tx.Fee,
+		Expiry:  tx.Expiry,
+		ChainID: tx.ChainID,
+		Data:    hex.EncodeToString(tx.Data),
+	}
+	if tx.From != nil {
+		t.From = hex.EncodeToString(tx.From.ToBytes())
+		addr := tx.From.Address()
+		t.Sender = addr.String()
+	}
+	if tx.Receiver != nil {
+		t.Receiver = hex.EncodeToString(tx.Receiver.ToBytes())
+	}
+	if tx.Signature != nil {
+		t.Signature = hex.EncodeToString(tx.Signature.ToBytes())
+	}
+
+	return t
+}
@@ -0,0 +1,401 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/majorshift/safari-chain/events"
+	"github.com/majorshift/safari-chain/network"
+)
+
+const (
+	defaultSendBuffer       = 256
+	defaultMaxSubscriptions = 32
+	defaultWriteTimeout     = 10 * time.Second
+	defaultPingInterval     = 30 * time.Second
+	eventBuffer             = 4096 // chain and mempool events awaiting notification, at least
+	feedBlocks              = 4    // full blocks the chain feed buffers while notifications are sent
+)
+
+// Topic names what a subscription is notified of
+type Topic string
+
+const (
+	TopicNewHeads   Topic = "newHeads"   // header of every block added to the chain
+	TopicPendingTxs Topic = "pendingTxs" // every transaction admitted to the mempool
+	TopicAddressTxs Topic = "addressTxs" // transactions sending to or from an address, when pooled then when included
+	TopicReorgs     Topic = "reorgs"     // blocks detached and attached by every reorg
+)
+
+// Reorg is the notification of the reorgs topic
+type Reorg struct {
+	Detached []*Header `json:"detached"` // former blocks of the chain, in height order
+	Added    []*Header `json:"added"`    // blocks of the new branch, in height order
+}
+
+// notification is the message pushing a result to a subscription
+type notification struct {
+	JSONRPC string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  notificationParams `json:"params"`
+}
+
+type notificationParams struct {
+	Subscription string          `json:"subscription"`
+	Topic        Topic           `json:"topic"`
+	Result       json.RawMessage `json:"result"`
+}
+
+// subscription is a topic a connection subscribed to
+type subscription struct {
+	id      string
+	topic   Topic
+	address string // hex address of the addressTxs topic
+	conn    *wsConn
+}
+
+// wsMethod runs a call made over the websocket connection conn
+type wsMethod func(conn *wsConn, params json.RawMessage) (any, error)
+
+// wsConn is a websocket connection. Responses and notifications are
+// queued to its send buffer and written by its own goroutine, so a slow
+// client never holds up the node; it is dropped once the buffer is full
+type wsConn struct {
+	server *Server
+	ws     *websocket.Conn
+	send   chan []byte
+	done   chan struct{} // closed when the connection is dropped
+	once   sync.Once
+	subs   map[string]*subscription // guarded by the server subscription lock
+}
+
+// subscribe takes a topic, and an address for the addressTxs topic, and
+// returns the id of the subscription that the notifications will carry
+func (s *Server) subscribe(conn *wsConn, params json.RawMessage) (any, error) {
+	var topic Topic
+	var address string
+	if err := positional(params, 1, &topic, &address); err != nil {
+		return nil, err
+	}
+
+	switch topic {
+	case TopicNewHeads, TopicPendingTxs, TopicReorgs:
+		if address != "" {
+			return nil, invalidParams("topic %s takes no address", topic)
+		}
+	case TopicAddressTxs:
+		var err error
+		if address, err = parseAddress(address); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, invalidParams("unknown topic %q", topic)
+	}
+
+	s.subLock.Lock()
+	defer s.subLock.Unlock()
+
+	if len(conn.subs) >= s.MaxSubscriptions {
+		return nil, &Error{Code: CodeInvalidRequest, Message: fmt.Sprintf("connection already has %d subscriptions", s.MaxSubscriptions)}
+	}
+	s.lastSub++
+	sub := &subscription{
+		id:      fmt.Sprintf("0x%x", s.lastSub),
+		topic:   topic,
+		address: address,
+		conn:    conn,
+	}
+	conn.subs[sub.id] = sub
+	s.subs[sub.id] = sub
+
+	return sub.id, nil
+}
+
+// unsubscribe takes a subscription id of the connection and cancels it.
+// It returns whether the subscription existed
+func (s *Server) unsubscribe(conn *wsConn, params json.RawMessage) (any, error) {
+	var id string
+	if err := positional(params, 1, &id); err != nil {
+		return nil, err
+	}
+
+	s.subLock.Lock()
+	defer s.subLock.Unlock()
+
+	if _, ok := conn.subs[id]; !ok {
+		return false, nil
+	}
+	delete(conn.subs, id)
+	delete(s.subs, id)
+
+	return true, nil
+}
+
+// serveWS upgrades the request to a websocket connection and serves it
+// until either side closes it
+func (s *Server) serveWS(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already answered with an error
+		s.Logger.WithError(err).Debug("failed to upgrade websocket connection")
+		return
+	}
+
+	conn := s.newConn(ws)
+	go conn.writeLoop()
+	conn.readLoop()
+
+	s.removeConn(conn)
+	conn.drop(websocket.CloseNormalClosure, "")
+}
+
+// newConn registers a websocket connection
+func (s *Server) newConn(ws *websocket.Conn) *wsConn {
+	conn := &wsConn{
+		server: s,
+		ws:     ws,
+		send:   make(chan []byte, s.SendBuffer),
+		done:   make(chan struct{}),
+		subs:   make(map[string]*subscription),
+	}
+
+	s.subLock.Lock()
+	s.conns[conn] = struct{}{}
+	s.subLock.Unlock()
+
+	return conn
+}
+
+// removeConn forgets a closed connection and its subscriptions
+func (s *Server) removeConn(conn *wsConn) {
+	s.subLock.Lock()
+	defer s.subLock.Unlock()
+
+	for id := range conn.subs {
+		delete(s.subs, id)
+	}
+	delete(s.conns, conn)
+}
+
+// forward notifies the subscriptions of the events received by sub
+// until it is cancelled. When the bus drops events because forwarding
+// fell behind, the connections with subscriptions are dropped, as they
+// missed notifications
+func (s *Server) forward(sub *events.Subscription[any]) {
+	var dropped uint64
+	for ev := range sub.Events() {
+		if n := sub.Dropped(); n > dropped {
+			dropped = n
+			s.dropSubscribers()
+		}
+		if !s.subscribed() {
+			continue
+		}
+
+		switch ev := ev.(type) {
+		case crypto.ChainReorg:
+			reorg := &Reorg{
+				Detached: make([]*Header, len(ev.Detached)),
+				Added:    make([]*Header, len(ev.Added)),
+			}
+			for i, b := range ev.Detached {
+				reorg.Detached[i] = newHeader(b.Header)
+			}
+			for i, b := range ev.Added {
+				reorg.Added[i] = newHeader(b.Header)
+			}
+			s.publish(TopicReorgs, "", reorg)
+		case crypto.BlockAdded:
+			s.publish(TopicNewHeads, "", newHeader(ev.Block.Header))
+		case crypto.TxIncluded:
+			height := ev.Block.Height
+			s.publishAddressTx(ev.Tx, &TxResult{
+				Transaction: newTransaction(ev.Tx),
+				Status:      TxIncluded,
+				BlockHash:   ev.Block.Hash(crypto.BlockHash{}).ToString(),
+				BlockHeight: &height,
+			})
+		case network.TxAdmitted:
+			tx := newTransaction(ev.Tx)
+			s.publish(TopicPendingTxs, "", tx)
+			s.publishAddressTx(ev.Tx, &TxResult{Transaction: tx, Status: s.poolStatus(ev.Tx.Hash(crypto.TxHash{}))})
+		}
+	}
+}
+
+// publishAddressTx notifies the subscriptions to the sender and receiver of tx
+func (s *Server) publishAddressTx(tx *crypto.Transaction, result *TxResult) {
+	if result.Transaction.Sender != "" {
+		s.publish(TopicAddressTxs, result.Transaction.Sender, result)
+	}
+	if tx.Receiver != nil {
+		addr := tx.Receiver.Address()
+		if receiver := addr.String(); receiver != result.Transaction.Sender {
+			s.publish(TopicAddressTxs, receiver, result)
+		}
+	}
+}
+
+// publish sends result to every subscription to topic, and to address
+// for the addressTxs topic. Connections whose buffer is full are dropped
+func (s *Server) publish(topic Topic, address string, result any) {
+	s.subLock.Lock()
+	defer s.subLock.Unlock()
+
+	var encoded json.RawMessage
+	for _, sub := range s.subs {
+		if sub.topic != topic || sub.address != address {
+			continue
+		}
+		if encoded == nil {
+			var err error
+			if encoded, err = json.Marshal(result); err != nil {
+				s.Logger.WithField("topic", topic).WithError(err).Error("failed to encode notification")
+				return
+			}
+		}
+
+		msg, err := json.Marshal(&notification{
+			JSONRPC: jsonrpcVersion,
+			Method:  "subscription",
+			Params:  notificationParams{Subscription: sub.id, Topic: topic, Result: encoded},
+		})
+		if err != nil {
+			s.Logger.WithField("topic", topic).WithError(err).Error("failed to encode notification")
+			return
+		}
+		sub.conn.queue(msg)
+	}
+}
+
+// subscribed tells whether there is any subscription to notify
+func (s *Server) subscribed() bool {
+	s.subLock.Lock()
+	defer s.subLock.Unlock()
+
+	return len(s.subs) > 0
+}
+
+// dropSubscribers drops the connections having any subscription
+func (s *Server) dropSubscribers() {
+	s.subLock.Lock()
+	var conns []*wsConn
+	for conn := range s.conns {
+		if len(conn.subs) > 0 {
+			conns = append(conns, conn)
+		}
+	}
+	s.subLock.Unlock()
+
+	if len(conns) > 0 {
+		s.Logger.WithField("connections", len(conns)).Warn("dropping websocket subscribers after missing events")
+	}
+	for _, conn := range conns {
+		conn.drop(websocket.CloseTryAgainLater, "missed notifications")
+	}
+}
+
+// closeConns drops every websocket connection, which the HTTP server
+// does not track once they are upgraded
+func (s *Server) closeConns() {
+	s.subLock.Lock()
+	conns := make([]*wsConn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.subLock.Unlock()
+
+	for _, conn := range conns {
+		conn.drop(websocket.CloseGoingAway, "server shutting down")
+	}
+}
+
+// queue adds msg to the send buffer, dropping the connection if it is full
+func (c *wsConn) queue(msg []byte) {
+	select {
+	case <-c.done:
+	case c.send <- msg:
+	default:
+		c.server.Logger.WithField("remote", c.ws.RemoteAddr()).Warn("dropping websocket subscriber falling behind")
+		c.drop(websocket.ClosePolicyViolation, "too far behind")
+	}
+}
+
+// drop closes the connection, once, telling the client the close code
+// unless it is 0. The close frame is written in the background so that
+// publishers never wait on a client
+func (c *wsConn) drop(code int, reason string) {
+	c.once.Do(func() {
+		close(c.done)
+		go func() {
+			if code != 0 {
+				deadline := time.Now().Add(c.server.WriteTimeout)
+				c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+			}
+			c.ws.Close()
+		}()
+	})
+}
+
+// readLoop answers the requests of the client until the connection fails
+func (c *wsConn) readLoop() {
+	c.ws.SetReadLimit(c.server.MaxRequestSize)
+	pongWait := 2 * c.server.PingInterval
+	c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, body, err := c.ws.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				c.server.Logger.WithField("remote", c.ws.RemoteAddr()).WithError(err).Debug("websocket connection closed")
+			}
+			return
+		}
+		c.ws.SetReadDeadline(time.Now().Add(pongWait))
+
+		resp := c.server.process(body, c)
+		if resp == nil {
+			continue
+		}
+		msg, err := json.Marshal(resp)
+		if err != nil {
+			c.server.Logger.WithError(err).Error("failed to encode rpc response")
+			continue
+		}
+		c.queue(msg)
+	}
+}
+
+// writeLoop writes the queued messages and pings the client until the
+// connection is dropped
+func (c *wsConn) writeLoop() {
+	ping := time.NewTicker(c.server.PingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(c.server.WriteTimeout))
+			if err := c.ws.WriteMessage(websocket.TextMessage, msg); err != nil {
+				c.server.Logger.WithField("remote", c.ws.RemoteAddr()).WithError(err).Debug("failed to write to websocket")
+				c.drop(0, "")
+				return
+			}
+		case <-ping.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.server.WriteTimeout)); err != nil {
+				c.drop(0, "")
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/majorshift/safari-chain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// wsClient is a websocket client keeping the notifications received while
+// waiting for responses
+type wsClient struct {
+	conn          *websocket.Conn
+	notifications []notificationParams
+	lastID        int
+}
+
+// helper function connecting to the websocket endpoint of the node
+func dialWS(t *testing.T, n *testNode) *wsClient {
+	url := "ws" + strings.TrimPrefix(n.server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return &wsClient{conn: conn}
+}
+
+// helper function reading the next message of the connection
+func (c *wsClient) read(t *testing.T) map[string]json.RawMessage {
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg map[string]json.RawMessage
+	assert.NoError(t, c.conn.ReadJSON(&msg))
+
+	return msg
+}
+
+// helper function calling method and decoding its result into result
+func (c *wsClient) call(t *testing.T, method string, params []any, result any) *Error {
+	c.lastID++
+	assert.NoError(t, c.conn.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": c.lastID, "method": method, "params": params}))
+
+	for {
+		msg := c.read(t)
+		if _, ok := msg["id"]; !ok {
+			var p notificationParams
+			assert.NoError(t, json.Unmarshal(msg["params"], &p))
+			c.notifications = append(c.notifications, p)
+			continue
+		}
+
+		if raw, ok := msg["error"]; ok {
+			var rpcErr Error
+			assert.NoError(t, json.Unmarshal(raw, &rpcErr))
+			return &rpcErr
+		}
+		if result != nil {
+			assert.NoError(t, json.Unmarshal(msg["result"], result))
+		}
+		return nil
+	}
+}
+
+// helper function returning the next notification, decoding its result into result
+func (c *wsClient) next(t *testing.T, result any) notificationParams {
+	if len(c.notifications) == 0 {
+		var p notificationParams
+		assert.NoError(t, json.Unmarshal(c.read(t)["params"], &p))
+		c.notifications = append(c.notifications, p)
+	}
+
+	p := c.notifications[0]
+	c.notifications = c.notifications[1:]
+	assert.NoError(t, json.Unmarshal(p.Result, result))
+	return p
+}
+
+// helper function subscribing to topic
+func (c *wsClient) subscribe(t *testing.T, topic Topic, args ...any) string {
+	var id string
+	assert.Nil(t, c.call(t, "subscribe", append([]any{topic}, args...), &id))
+
+	return id
+}
+
+func TestServer_Subscriptions(t *testing.T) {
+	n := newTestNode(t, ServerOpts{})
+	c := dialWS(t, n)
+	sender := n.sender.PublicKey().Address()
+
+	// plain calls work over the connection too
+	var height uint32
+	assert.Nil(t, c.call(t, "chain_getHeight", nil, &height))
+	assert.Equal(t, uint32(0), height)
+
+	heads := c.subscribe(t, TopicNewHeads)
+	pending := c.subscribe(t, TopicPendingTxs)
+	address := c.subscribe(t, TopicAddressTxs, "0x"+strings.ToUpper(sender.String()))
+
+	tx := n.transfer(t, 0)
+	assert.NoError(t, n.mempool.Add(tx))
+	var pooled Transaction
+	assert.Equal(t, pending, c.next(t, &pooled).Subscription)
+	assert.Equal(t, tx.Hash(crypto.TxHash{}).ToString(), pooled.Hash)
+	var result TxResult
+	assert.Equal(t, address, c.next(t, &result).Subscription)
+	assert.Equal(t, TxPending, result.Status)
+
+	b := n.addBlock(t, tx)
+	var header Header
+	p := c.next(t, &header)
+	assert.Equal(t, heads, p.Subscription)
+	assert.Equal(t, TopicNewHeads, p.Topic)
+	assert.Equal(t, b.Hash(crypto.BlockHash{}).ToString(), header.Hash)
+	assert.Equal(t, address, c.next(t, &result).Subscription)
+	assert.Equal(t, TxIncluded, result.Status)
+	assert.Equal(t, header.Hash, result.BlockHash)
+
+	// cancelled subscriptions are no longer notified
+	var ok bool
+	assert.Nil(t, c.call(t, "unsubscribe", []any{pending}, &ok))
+	assert.True(t, ok)
+	assert.Nil(t, c.call(t, "unsubscribe", []any{pending}, &ok))
+	assert.False(t, ok)
+	assert.NoError(t, n.mempool.Add(n.transfer(t, 1)))
+	assert.Equal(t, address, c.next(t, &result).Subscription)
+	assert.Empty(t, c.notifications)
+
+	assert.Equal(t, CodeInvalidParams, c.call(t, "subscribe", []any{"blocks"}, nil).Code)
+	assert.Equal(t, CodeInvalidParams, c.call(t, "subscribe", []any{TopicAddressTxs}, nil).Code)
+	assert.Equal(t, CodeInvalidParams, c.call(t, "subscribe", []any{TopicAddressTxs, "ab"}, nil).Code)
+	assert.Equal(t, CodeInvalidParams, c.call(t, "subscribe", []any{TopicAddressTxs, "0x" + sender.String() + "00"}, nil).Code)
+
+	// subscriptions need a websocket connection
+	var resp response
+	n.post(t, `{"jsonrpc":"2.0","id":1,"method":"subscribe","params":["newHeads"]}`, &resp)
+	assert.Equal(t, CodeInvalidRequest, resp.Error.Code)
+}
+
+func TestServer_ReorgSubscription(t *testing.T) {
+	n := newTestNode(t, ServerOpts{})
+	c := dialWS(t, n)
+	reorgs := c.subscribe(t, TopicReorgs)
+
+	old := n.addBlock(t)
+	genesis, err := n.chain.GetHeaderByHeight(0)
+	assert.NoError(t, err)
+	validator, _ := crypto.GeneratePrivateKey()
+	fork1 := crypto.NewSignedBlockExample(validator, nil, 1, crypto.BlockHash{}.Hash(genesis))
+	fork2 := crypto.NewSignedBlockExample(validator, nil, 2, fork1.Hash(crypto.BlockHash{}))
+	assert.NoError(t, n.chain.SwitchFork([]*crypto.Block{fork1, fork2}))
+
+	var reorg Reorg
+	assert.Equal(t, reorgs, c.next(t, &reorg).Subscription)
+	assert.Len(t, reorg.Detached, 1)
+	assert.Equal(t, old.Hash(crypto.BlockHash{}).ToString(), reorg.Detached[0].Hash)
+	assert.Len(t, reorg.Added, 2)
+	assert.Equal(t, fork2.Hash(crypto.BlockHash{}).ToString(), reorg.Added[1].Hash)
+}
+
+func TestServer_DropsSlowSubscribers(t *testing.T) {
+	n := newTestNode(t, ServerOpts{SendBuffer: 2, MaxSubscriptions: 1})
+
+	// the connection is served without its write loop, as if the client
+	// stopped reading and its socket filled up
+	api := NewServer(n.chain, n.mempool, ServerOpts{SendBuffer: 2, Logger: n.api.Logger})
+	t.Cleanup(api.Close)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := api.upgrader.Upgrade(w, r, nil)
+		assert.NoError(t, err)
+		conn := api.newConn(ws)
+		_, err = api.subscribe(conn, json.RawMessage(`["newHeads"]`))
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(slow.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(slow.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.Eventually(t, api.subscribed, time.Second, 5*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		n.addBlock(t)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "unexpected error %v", err)
+
+	// subscriptions are limited per connection
+	c := dialWS(t, n)
+	c.subscribe(t, TopicNewHeads)
+	assert.Equal(t, CodeInvalidRequest, c.call(t, "subscribe", []any{TopicReorgs}, nil).Code)
+}
+
+func TestServer_DropsSubscribersMissingEvents(t *testing.T) {
+	n := newTestNode(t, ServerOpts{})
+	c := dialWS(t, n)
+	c.subscribe(t, TopicNewHeads)
+	idle := dialWS(t, n)
+
+	// notifications are held up until the bus drops events
+	n.api.subLock.Lock()
+	for i := 0; i < cap(n.api.feeds[0].Events())+2; i++ {
+		n.chain.Events().Publish(struct{}{})
+	}
+	n.api.subLock.Unlock()
+
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := c.conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseTryAgainLater), "unexpected error %v", err)
+
+	// connections without subscriptions missed nothing
+	var height uint32
+	assert.Nil(t, idle.call(t, "chain_getHeight", nil, &height))
+}
+
+func TestServer_NotifiesFullBlocks(t *testing.T) {
+	n := newTestNode(t, ServerOpts{})
+	c := dialWS(t, n)
+	c.subscribe(t, TopicNewHeads)
+
+	// full blocks published while notifications are held up fit the feed
+	max := n.chain.Config().MaxTxsPerBlock()
+	var blocks []*crypto.Block
+	n.api.subLock.Lock()
+	for b := 0; b < 2; b++ {
+		txs := make([]*crypto.Transaction, max)
+		for i := range txs {
+			tx := crypto.NewTransaction(n.sender.PublicKey(), n.sender.PublicKey(), nil)
+			tx.Nonce = uint64(b*max + i)
+			tx.ChainID = n.chain.Config().ChainID
+			tx.Sign(n.sender)
+			txs[i] = tx
+		}
+		blocks = append(blocks, n.addBlock(t, txs...))
+	}
+	n.api.subLock.Unlock()
+
+	for _, b := range blocks {
+		var header Header
+		c.next(t, &header)
+		assert.Equal(t, b.Hash(crypto.BlockHash{}).ToString(), header.Hash)
+	}
+}
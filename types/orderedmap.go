@@ -0,0 +1,109 @@
+package types
+
+// OrderedMap is a generic map that remembers insertion order.
+// Entries live in an intrusive doubly linked list indexed by key, so
+// insertion, lookup, removal and access to the oldest entry are O(1)
+type OrderedMap[K comparable, V any] struct {
+	entries map[K]*entry[K, V]
+	root    entry[K, V] // sentinel; root.next is the oldest entry, root.prev the newest
+}
+
+// entry is a node of the linked list of an OrderedMap
+type entry[K comparable, V any] struct {
+	key        K
+	value      V
+	prev, next *entry[K, V]
+}
+
+func NewOrderedMap[K comparable, V any]() *OrderedMap[K, V] {
+	m := &OrderedMap[K, V]{entries: make(map[K]*entry[K, V])}
+	m.root.prev = &m.root
+	m.root.next = &m.root
+
+	return m
+}
+
+// Set inserts v under k at the back of the map.
+// Setting an existing key replaces its value and keeps its position
+func (m *OrderedMap[K, V]) Set(k K, v V) {
+	if e, ok := m.entries[k]; ok {
+		e.value = v
+		return
+	}
+
+	e := &entry[K, V]{key: k, value: v, prev: m.root.prev, next: &m.root}
+	m.root.prev.next = e
+	m.root.prev = e
+	m.entries[k] = e
+}
+
+// Get returns the value stored under k
+func (m *OrderedMap[K, V]) Get(k K) (V, bool) {
+	if e, ok := m.entries[k]; ok {
+		return e.value, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// Contains checks if k is in the map
+func (m *OrderedMap[K, V]) Contains(k K) bool {
+	_, ok := m.entries[k]
+	return ok
+}
+
+// Delete removes k from the map, if present
+func (m *OrderedMap[K, V]) Delete(k K) {
+	e, ok := m.entries[k]
+	if !ok {
+		return
+	}
+
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.prev, e.next = nil, nil // help the garbage collector
+	delete(m.entries, k)
+}
+
+// Oldest returns the first inserted key and value still in the map.
+// ok is false if the map is empty
+func (m *OrderedMap[K, V]) Oldest() (k K, v V, ok bool) {
+	if m.root.next == &m.root {
+		return k, v, false
+	}
+
+	return m.root.next.key, m.root.next.value, true
+}
+
+// Range calls fn for every entry from oldest to newest until fn returns false.
+// The map must not be modified by fn
+func (m *OrderedMap[K, V]) Range(fn func(k K, v V) bool) {
+	for e := m.root.next; e != &m.root; e = e.next {
+		if !fn(e.key, e.value) {
+			return
+		}
+	}
+}
+
+// Values returns the values of the map from oldest to newest
+func (m *OrderedMap[K, V]) Values() []V {
+	values := make([]V, 0, len(m.entries))
+	for e := m.root.next; e != &m.root; e = e.next {
+		values = append(values, e.value)
+	}
+
+	return values
+}
+
+// Len returns the number of entries in the map
+func (m *OrderedMap[K, V]) Len() int {
+	return len(m.entries)
+}
+
+// Clear removes every entry of the map
+func (m *OrderedMap[K, V]) Clear() {
+	m.entries = make(map[K]*entry[K, V])
+	m.root.prev = &m.root
+	m.root.next = &m.root
+}
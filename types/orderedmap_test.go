@@ -0,0 +1,64 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderedMap_KeepsInsertionOrder(t *testing.T) {
+	m := NewOrderedMap[string, int]()
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("c", 3)
+
+	// replacing a value keeps its position
+	m.Set("a", 10)
+	assert.Equal(t, []int{10, 2, 3}, m.Values())
+
+	k, v, ok := m.Oldest()
+	assert.True(t, ok)
+	assert.Equal(t, "a", k)
+	assert.Equal(t, 10, v)
+}
+
+func TestOrderedMap_Delete(t *testing.T) {
+	m := NewOrderedMap[string, int]()
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("c", 3)
+
+	m.Delete("b")
+	m.Delete("missing")
+	assert.Equal(t, []int{1, 3}, m.Values())
+	assert.False(t, m.Contains("b"))
+	assert.Equal(t, 2, m.Len())
+
+	m.Delete("a")
+	k, _, _ := m.Oldest()
+	assert.Equal(t, "c", k)
+
+	m.Delete("c")
+	_, _, ok := m.Oldest()
+	assert.False(t, ok)
+	assert.Empty(t, m.Values())
+}
+
+func TestOrderedMap_RangeStops(t *testing.T) {
+	m := NewOrderedMap[int, int]()
+	for i := 0; i < 5; i++ {
+		m.Set(i, i*i)
+	}
+
+	var seen []int
+	m.Range(func(k, v int) bool {
+		seen = append(seen, v)
+		return k < 2
+	})
+	assert.Equal(t, []int{0, 1, 4}, seen)
+
+	m.Clear()
+	assert.Equal(t, 0, m.Len())
+	m.Set(7, 49)
+	assert.Equal(t, []int{49}, m.Values())
+}